@@ -0,0 +1,100 @@
+function loadACLs(path) {
+    const list = document.querySelector("#acls");
+    list.replaceChildren();
+
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status !== 200) {
+            document.querySelector("#acl-feedback").style.display = "flex";
+            setUploadError("#acl-error", rq);
+            return;
+        }
+        for (const acl of rq.response) {
+            list.appendChild(getACLElement(acl));
+        }
+    });
+    rq.open("GET", `/acl${path}`);
+    rq.send();
+}
+
+function getACLElement(acl) {
+    const div = document.createElement("div");
+    div.classList.add("acl");
+
+    const subject = document.createElement("span");
+    subject.textContent = `${acl.subject_type === "group" ? "Group" : "User"} ${acl.subject}`;
+    div.appendChild(subject);
+
+    const info = document.createElement("span");
+    info.textContent = `${acl.deny ? "deny" : "allow"} ${acl.permission}`;
+    if (acl.inherited) {
+        info.textContent += ` - inherited from ${acl.path}`;
+    }
+    div.appendChild(info);
+
+    if (!acl.inherited) {
+        const remove = document.createElement("button");
+        remove.classList.add("btn", "danger");
+        remove.textContent = "Delete";
+        remove.addEventListener("click", () => deleteACL(acl));
+        div.appendChild(remove);
+    }
+    return div;
+}
+
+function deleteACL(acl) {
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status === 204) {
+            loadACLs(acl.path);
+        } else {
+            document.querySelector("#acl-feedback").style.display = "flex";
+            setUploadError("#acl-error", rq);
+        }
+    });
+    rq.open("DELETE", `/acl${acl.path}?subject_type=${encodeURIComponent(acl.subject_type)}&subject=${encodeURIComponent(acl.subject)}`);
+    rq.send();
+}
+
+register("#acl-btn", "click", () => {
+    loadACLs(document.querySelector("#acl-path").value);
+    document.querySelector("#acl-dialog").showModal();
+});
+
+register("#acl-confirm-btn", "click", () => {
+    const path = document.querySelector("#acl-path").value;
+
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status === 204) {
+            document.querySelector("#acl-subject").value = "";
+            document.querySelector("#acl-error").textContent = "";
+            document.querySelector("#acl-feedback").style.display = "none";
+            loadACLs(path);
+        } else {
+            document.querySelector("#acl-feedback").style.display = "flex";
+            setUploadError("#acl-error", rq);
+        }
+    });
+    rq.open("PUT", `/acl${path}`);
+    rq.setRequestHeader("Content-Type", "application/json");
+    rq.send(JSON.stringify({
+        subject_type: document.querySelector("#acl-subject-type").value,
+        subject: document.querySelector("#acl-subject").value,
+        permission: document.querySelector("#acl-permission").value,
+        deny: document.querySelector("#acl-deny").value === "true",
+    }));
+});
+
+register("#acl-cancel-btn", "click", () => {
+    document.querySelector("#acl-dialog").close();
+});
+
+register("#acl-dialog", "close", () => {
+    document.querySelector("#acls").replaceChildren();
+    document.querySelector("#acl-error").textContent = "";
+    document.querySelector("#acl-feedback").style.display = "none";
+});
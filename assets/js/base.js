@@ -63,6 +63,74 @@ function uploadFile(method, path, file, dir, name, description, doneCallback, er
     requests.push(rq);
 }
 
+// uploadPresigned uploads a new file directly to the storage with the URLs from /uploads and completes the upload afterwards.
+// It takes the same arguments as uploadFile, but only supports creating files.
+function uploadPresigned(method, path, file, dir, name, description, doneCallback, errorCallback, progressCallback) {
+    const createRq = sendJSON("POST", "/uploads", {
+        path: `${path.replace(/\/$/, "")}/${name || file.name}`,
+        size: file.size,
+        content_type: file.type,
+        description: description,
+    }, () => {
+        const upload = createRq.response;
+        const parts = upload.parts || [{number: 0, url: upload.url}];
+        const loaded = new Array(parts.length).fill(0);
+        const completed = [];
+
+        const uploadPart = (i) => {
+            if (i === parts.length) {
+                sendJSON("POST", `/uploads/${upload.id}`, {parts: completed}, doneCallback, errorCallback);
+                return;
+            }
+            const part = parts[i];
+            const content = upload.part_size ? file.slice((part.number - 1) * upload.part_size, part.number * upload.part_size) : file;
+
+            const rq = new XMLHttpRequest();
+            // S3 errors are not json, so the status text is shown instead
+            rq.responseType = "json";
+            rq.addEventListener("load", () => {
+                if (rq.status < 200 || rq.status >= 300) {
+                    errorCallback(rq);
+                    return;
+                }
+                completed.push({number: part.number, etag: rq.getResponseHeader("ETag")});
+                uploadPart(i + 1);
+            });
+            rq.upload.addEventListener("error", () => {
+                errorCallback(rq);
+            });
+            rq.upload.addEventListener("progress", (e) => {
+                loaded[i] = e.loaded;
+                progressCallback({loaded: loaded.reduce((a, b) => a + b, 0), total: file.size});
+            });
+            rq.open("PUT", part.url);
+            rq.send(content);
+            requests.push(rq);
+        };
+        uploadPart(0);
+    }, errorCallback);
+}
+
+function sendJSON(method, path, body, doneCallback, errorCallback) {
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status >= 200 && rq.status < 300) {
+            doneCallback(rq);
+        } else {
+            errorCallback(rq);
+        }
+    });
+    rq.addEventListener("error", () => {
+        errorCallback(rq);
+    });
+    rq.open(method, path);
+    rq.setRequestHeader("Content-Type", "application/json");
+    rq.send(JSON.stringify(body));
+    requests.push(rq);
+    return rq;
+}
+
 function setUploadError(errorID, request) {
     document.querySelector(errorID).textContent = request.response ? request.response.message : request.statusText || "Unknown error";
 }
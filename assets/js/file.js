@@ -29,6 +29,10 @@ registerAll(".file-more", "change", (e) => {
             openEditDialog(e.target.dataset);
             break;
 
+        case "versions":
+            openVersionsDialog(e.target.dataset);
+            break;
+
         case "move":
             openMoveDialog();
             break;
@@ -38,7 +42,7 @@ registerAll(".file-more", "change", (e) => {
             break;
 
         case "share":
-            document.querySelector("#share-dialog").showModal();
+            openShareDialog(e.target.dataset);
             break;
     }
     e.target.value = "none";
@@ -0,0 +1,235 @@
+register("#token-new-btn", "click", () => {
+    document.querySelector("#token-dialog").showModal();
+});
+
+register("#token-confirm-btn", "click", () => {
+    const expiresAt = document.querySelector("#token-expires-at").value;
+
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status !== 201) {
+            document.querySelector("#token-feedback").style.display = "flex";
+            setUploadError("#token-error", rq);
+            return;
+        }
+        document.querySelector("#token-new").hidden = true;
+        document.querySelector("#token-created").hidden = false;
+        document.querySelector("#token-value").value = rq.response.token;
+        document.querySelector("#token-confirm-btn").disabled = true;
+    });
+    rq.open("POST", "/settings/tokens");
+    rq.setRequestHeader("Content-Type", "application/json");
+    rq.send(JSON.stringify({
+        name: document.querySelector("#token-name").value,
+        scope: document.querySelector("#token-scope").value,
+        expires_at: expiresAt ? new Date(expiresAt).toISOString() : null,
+    }));
+});
+
+register("#token-value", "focus", (e) => {
+    e.target.select();
+});
+
+register("#token-cancel-btn", "click", () => {
+    document.querySelector("#token-dialog").close();
+});
+
+register("#token-dialog", "close", () => {
+    if (!document.querySelector("#token-created").hidden) {
+        window.location.reload();
+        return;
+    }
+    document.querySelector("#token-error").textContent = "";
+    document.querySelector("#token-feedback").style.display = "none";
+});
+
+registerAll(".token-revoke-btn", "click", (e) => {
+    if (!confirm(`Are you sure you want to revoke the token ${e.target.dataset.name}?`)) {
+        return;
+    }
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status === 204) {
+            window.location.reload();
+        } else {
+            alert(rq.response ? rq.response.message : rq.statusText);
+        }
+    });
+    rq.open("DELETE", `/settings/tokens/${e.target.dataset.id}`);
+    rq.send();
+});
+
+registerAll(".user-more", "change", (e) => {
+    const select = e.target;
+    const action = select.value;
+    select.value = "none";
+    if (action === "edit") {
+        const dialog = document.querySelector("#user-dialog");
+        dialog.dataset.id = select.dataset.id;
+        dialog.dataset.home = select.dataset.home;
+        dialog.dataset.quota = select.dataset.quota;
+        dialog.dataset.role = select.dataset.role;
+        dialog.dataset.disabled = select.dataset.disabled;
+        document.querySelector("#user-home").value = select.dataset.home;
+        document.querySelector("#user-quota").value = select.dataset.quota;
+        document.querySelector("#user-role").value = select.dataset.role;
+        document.querySelector("#user-disabled").value = select.dataset.disabled;
+        dialog.showModal();
+    } else if (action === "delete") {
+        const dialog = document.querySelector("#user-delete-dialog");
+        dialog.dataset.id = select.dataset.id;
+        dialog.querySelector("h2").textContent = `Delete ${select.dataset.name}`;
+        dialog.showModal();
+    } else if (action === "reset") {
+        if (!confirm(`Create a link for ${select.dataset.name} to set a new password?`)) {
+            return;
+        }
+        const rq = new XMLHttpRequest();
+        rq.responseType = "json";
+        rq.addEventListener("load", () => {
+            if (rq.status !== 201) {
+                alert(rq.response ? rq.response.message : rq.statusText);
+                return;
+            }
+            showLink(`Password reset for ${select.dataset.name}`, rq.response);
+        });
+        rq.open("POST", `/settings/users/${select.dataset.id}/password-reset`);
+        rq.send();
+    }
+});
+
+register("#user-confirm-btn", "click", () => {
+    const dialog = document.querySelector("#user-dialog");
+    // only changed fields are sent, admins can not change the role or disable their own account
+    const body = {};
+    const home = document.querySelector("#user-home").value.trim();
+    if (home !== dialog.dataset.home) {
+        body.home = home;
+    }
+    const quota = document.querySelector("#user-quota").value.trim();
+    if (quota !== dialog.dataset.quota) {
+        body.quota = quota;
+    }
+    const role = document.querySelector("#user-role").value;
+    if (role !== dialog.dataset.role) {
+        body.role = role;
+    }
+    const disabled = document.querySelector("#user-disabled").value;
+    if (disabled !== dialog.dataset.disabled) {
+        body.disabled = disabled === "true";
+    }
+
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status === 204) {
+            window.location.reload();
+        } else {
+            document.querySelector("#user-feedback").style.display = "flex";
+            setUploadError("#user-error", rq);
+        }
+    });
+    rq.open("PATCH", `/settings/users/${dialog.dataset.id}`);
+    rq.setRequestHeader("Content-Type", "application/json");
+    rq.send(JSON.stringify(body));
+});
+
+register("#user-cancel-btn", "click", () => {
+    document.querySelector("#user-dialog").close();
+});
+
+register("#user-dialog", "close", () => {
+    document.querySelector("#user-error").textContent = "";
+    document.querySelector("#user-feedback").style.display = "none";
+});
+
+register("#user-delete-files", "change", (e) => {
+    document.querySelector("#user-delete-to").disabled = e.target.value !== "transfer";
+});
+
+register("#user-delete-confirm-btn", "click", () => {
+    const dialog = document.querySelector("#user-delete-dialog");
+    const files = document.querySelector("#user-delete-files").value;
+    const query = new URLSearchParams({files: files});
+    if (files === "transfer") {
+        query.set("to", document.querySelector("#user-delete-to").value.trim());
+    }
+
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status === 204) {
+            window.location.reload();
+        } else {
+            document.querySelector("#user-delete-feedback").style.display = "flex";
+            setUploadError("#user-delete-error", rq);
+        }
+    });
+    rq.open("DELETE", `/settings/users/${dialog.dataset.id}?${query}`);
+    rq.send();
+});
+
+register("#user-delete-cancel-btn", "click", () => {
+    document.querySelector("#user-delete-dialog").close();
+});
+
+register("#user-delete-dialog", "close", () => {
+    document.querySelector("#user-delete-to").value = "";
+    document.querySelector("#user-delete-error").textContent = "";
+    document.querySelector("#user-delete-feedback").style.display = "none";
+});
+
+register("#invite-new-btn", "click", () => {
+    document.querySelector("#invite-dialog").showModal();
+});
+
+register("#invite-confirm-btn", "click", () => {
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status !== 201) {
+            document.querySelector("#invite-feedback").style.display = "flex";
+            setUploadError("#invite-error", rq);
+            return;
+        }
+        document.querySelector("#invite-dialog").close();
+        showLink("Invitation", rq.response);
+    });
+    rq.open("POST", "/settings/invitations");
+    rq.setRequestHeader("Content-Type", "application/json");
+    rq.send(JSON.stringify({
+        role: document.querySelector("#invite-role").value,
+    }));
+});
+
+register("#invite-cancel-btn", "click", () => {
+    document.querySelector("#invite-dialog").close();
+});
+
+register("#invite-dialog", "close", () => {
+    document.querySelector("#invite-error").textContent = "";
+    document.querySelector("#invite-feedback").style.display = "none";
+});
+
+// showLink shows an invitation or password reset link, it can not be shown again.
+function showLink(title, response) {
+    const dialog = document.querySelector("#link-dialog");
+    dialog.querySelector("h2").textContent = title;
+    document.querySelector("#link-expires").textContent = `Copy the link now, it will not be shown again. It expires ${new Date(response.expires_at).toLocaleString()}.`;
+    document.querySelector("#link-value").value = new URL(response.url, window.location.origin).toString();
+    dialog.showModal();
+}
+
+register("#link-value", "focus", (e) => {
+    e.target.select();
+});
+
+register("#link-close-btn", "click", () => {
+    document.querySelector("#link-dialog").close();
+});
+
+register("#link-dialog", "close", () => {
+    window.location.reload();
+});
@@ -0,0 +1,113 @@
+function openShareDialog(dataset) {
+    document.querySelector("#share-path").value = dataset.file;
+    const permission = document.querySelector("#share-permission");
+    permission.value = "read";
+    permission.disabled = dataset.dir !== "true";
+    loadShares(dataset.file);
+    document.querySelector("#share-dialog").showModal();
+}
+
+function loadShares(path) {
+    const list = document.querySelector("#shares");
+    list.replaceChildren();
+
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status !== 200) {
+            document.querySelector("#share-feedback").style.display = "flex";
+            setUploadError("#share-error", rq);
+            return;
+        }
+        for (const share of rq.response) {
+            list.appendChild(getShareElement(share));
+        }
+    });
+    rq.open("GET", `/shares?path=${encodeURIComponent(path)}`);
+    rq.send();
+}
+
+function getShareElement(share) {
+    const div = document.createElement("div");
+    div.classList.add("share");
+
+    const link = document.createElement("input");
+    link.type = "text";
+    link.readOnly = true;
+    link.value = new URL(share.url, window.location.origin).href;
+    link.addEventListener("focus", () => link.select());
+    div.appendChild(link);
+
+    const info = document.createElement("span");
+    const details = [share.permission];
+    if (share.password) {
+        details.push("password");
+    }
+    if (share.expires_at) {
+        details.push(`expires ${new Date(share.expires_at).toLocaleString()}`);
+    }
+    details.push(share.max_downloads > 0 ? `${share.downloads}/${share.max_downloads} downloads` : `${share.downloads} downloads`);
+    info.textContent = details.join(" - ");
+    div.appendChild(info);
+
+    const remove = document.createElement("button");
+    remove.classList.add("btn", "danger");
+    remove.textContent = "Delete";
+    remove.addEventListener("click", () => deleteShare(share));
+    div.appendChild(remove);
+    return div;
+}
+
+function deleteShare(share) {
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status === 204) {
+            loadShares(share.path);
+        } else {
+            document.querySelector("#share-feedback").style.display = "flex";
+            setUploadError("#share-error", rq);
+        }
+    });
+    rq.open("DELETE", `/shares/${share.token}`);
+    rq.send();
+}
+
+register("#share-confirm-btn", "click", () => {
+    const path = document.querySelector("#share-path").value;
+    const expiresAt = document.querySelector("#share-expires-at").value;
+    const maxDownloads = document.querySelector("#share-max-downloads").value;
+
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status === 201) {
+            document.querySelector("#share-password").value = "";
+            document.querySelector("#share-expires-at").value = "";
+            document.querySelector("#share-max-downloads").value = "";
+            loadShares(path);
+        } else {
+            document.querySelector("#share-feedback").style.display = "flex";
+            setUploadError("#share-error", rq);
+        }
+    });
+    rq.open("POST", "/shares");
+    rq.setRequestHeader("Content-Type", "application/json");
+    rq.send(JSON.stringify({
+        path: path,
+        password: document.querySelector("#share-password").value,
+        permission: document.querySelector("#share-permission").value,
+        max_downloads: maxDownloads ? parseInt(maxDownloads) : 0,
+        expires_at: expiresAt ? new Date(expiresAt).toISOString() : null,
+    }));
+});
+
+register("#share-cancel-btn", "click", () => {
+    document.querySelector("#share-dialog").close();
+});
+
+register("#share-dialog", "close", () => {
+    document.querySelector("#shares").replaceChildren();
+    document.querySelector("#share-error").textContent = "";
+    document.querySelector("#share-feedback").style.display = "none";
+});
@@ -0,0 +1,36 @@
+registerAll(".trash-more", "change", (e) => {
+    e.preventDefault();
+    e.stopPropagation();
+
+    switch (e.target.value) {
+        case "restore":
+            sendTrashRequest("POST", `/trash/${e.target.dataset.id}`);
+            break;
+        case "delete":
+            if (confirm("Are you sure you want to permanently delete this file?")) {
+                sendTrashRequest("DELETE", `/trash/${e.target.dataset.id}`);
+            }
+            break;
+    }
+    e.target.value = "none";
+});
+
+register("#trash-empty-btn", "click", () => {
+    if (confirm("Are you sure you want to permanently delete all files in the trash?")) {
+        sendTrashRequest("DELETE", "/trash");
+    }
+});
+
+function sendTrashRequest(method, path) {
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status === 204) {
+            window.location.reload();
+        } else {
+            alert(rq.response ? rq.response.message : rq.statusText);
+        }
+    });
+    rq.open(method, path);
+    rq.send();
+}
@@ -24,7 +24,8 @@ register("#upload-confirm-btn", "click", (e) => {
         fileName.disabled = true;
         fileDescription.disabled = true;
 
-        uploadFile("POST",
+        const upload = document.body.dataset.presignUploads === "true" ? uploadPresigned : uploadFile;
+        upload("POST",
             uploadDir.value,
             files[i],
             undefined,
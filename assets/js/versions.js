@@ -0,0 +1,75 @@
+function openVersionsDialog(dataset) {
+    const list = document.querySelector("#versions");
+    list.replaceChildren();
+
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status !== 200) {
+            document.querySelector("#versions-feedback").style.display = "flex";
+            setUploadError("#versions-error", rq);
+            return;
+        }
+        if (rq.response.length === 0) {
+            list.textContent = "No previous versions";
+            return;
+        }
+        for (const version of rq.response) {
+            list.appendChild(getVersionElement(dataset, version));
+        }
+    });
+    rq.open("GET", `/versions${dataset.file}`);
+    rq.send();
+    document.querySelector("#versions-dialog").showModal();
+}
+
+function getVersionElement(dataset, version) {
+    const div = document.createElement("div");
+    div.classList.add("version");
+
+    const info = document.createElement("span");
+    info.textContent = `v${version.version} - ${new Date(version.created_at).toLocaleString()} - ${version.size} bytes - ${version.owner}`;
+    div.appendChild(info);
+
+    const download = document.createElement("a");
+    download.classList.add("btn");
+    download.href = `/versions${dataset.file}?version=${version.version}`;
+    download.textContent = "Download";
+    div.appendChild(download);
+
+    if (dataset.owner === "true") {
+        const restore = document.createElement("button");
+        restore.classList.add("btn", "primary");
+        restore.textContent = "Restore";
+        restore.addEventListener("click", () => restoreVersion(dataset.file, version.version));
+        div.appendChild(restore);
+    }
+    return div;
+}
+
+function restoreVersion(file, version) {
+    if (!confirm(`Are you sure you want to restore version ${version}?`)) {
+        return;
+    }
+    const rq = new XMLHttpRequest();
+    rq.responseType = "json";
+    rq.addEventListener("load", () => {
+        if (rq.status === 204) {
+            window.location.reload();
+        } else {
+            document.querySelector("#versions-feedback").style.display = "flex";
+            setUploadError("#versions-error", rq);
+        }
+    });
+    rq.open("POST", `/versions${file}?version=${version}`);
+    rq.send();
+}
+
+register("#versions-close-btn", "click", () => {
+    document.querySelector("#versions-dialog").close();
+});
+
+register("#versions-dialog", "close", () => {
+    document.querySelector("#versions-error").textContent = "";
+    document.querySelector("#versions-feedback").style.display = "none";
+});
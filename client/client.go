@@ -0,0 +1,168 @@
+// Package client is a Go client for the godrive JSON API.
+//
+//	c := client.New("https://godrive.example.com", client.WithToken("gdp_..."))
+//	files, err := c.List(ctx, "/docs")
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Error is returned for every response with an unexpected status code.
+// Move and Delete also return it with status 207 if some files were skipped.
+type Error struct {
+	Message   string `json:"message"`
+	Status    int    `json:"status"`
+	Path      string `json:"path"`
+	RequestID string `json:"request_id"`
+}
+
+func (e *Error) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("godrive: %d %s (request id: %s)", e.Status, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("godrive: %d %s", e.Status, e.Message)
+}
+
+// Config configures a Client.
+type Config struct {
+	HTTPClient *http.Client
+	Token      string
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// ConfigOpt changes the Config of a Client.
+type ConfigOpt func(config *Config)
+
+func (c *Config) Apply(opts []ConfigOpt) {
+	for _, opt := range opts {
+		opt(c)
+	}
+}
+
+// WithHTTPClient sets the http.Client used for all requests.
+func WithHTTPClient(httpClient *http.Client) ConfigOpt {
+	return func(config *Config) {
+		config.HTTPClient = httpClient
+	}
+}
+
+// WithToken authenticates all requests with a personal access token.
+func WithToken(token string) ConfigOpt {
+	return func(config *Config) {
+		config.Token = token
+	}
+}
+
+// New creates a Client for the godrive server at baseURL.
+func New(baseURL string, opts ...ConfigOpt) *Client {
+	config := DefaultConfig()
+	config.Apply(opts)
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		config:  *config,
+	}
+}
+
+// Client talks to a godrive server. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	config  Config
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, endpoint string, query url.Values, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+	u = u.JoinPath(endpoint)
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.Token != "" {
+		rq.Header.Set("Authorization", "Bearer "+c.config.Token)
+	}
+	return rq, nil
+}
+
+// do sends the request and decodes a json response into v if v is not nil.
+func (c *Client) do(rq *http.Request, v any) error {
+	rs, err := c.config.HTTPClient.Do(rq)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+
+	if err = checkResponse(rs); err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	if err = json.NewDecoder(rs.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
+
+// sendJSON sends body as json and decodes a json response into v if v is not nil.
+func (c *Client) sendJSON(ctx context.Context, method string, endpoint string, body any, v any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	rq, err := c.newRequest(ctx, method, endpoint, nil, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	return c.do(rq, v)
+}
+
+// stream sends the request and returns the response body on success.
+func (c *Client) stream(rq *http.Request) (io.ReadCloser, error) {
+	rs, err := c.config.HTTPClient.Do(rq)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkResponse(rs); err != nil {
+		_ = rs.Body.Close()
+		return nil, err
+	}
+	return rs.Body, nil
+}
+
+func checkResponse(rs *http.Response) error {
+	if rs.StatusCode >= 200 && rs.StatusCode < 300 && rs.StatusCode != http.StatusMultiStatus {
+		return nil
+	}
+
+	apiErr := &Error{
+		Status: rs.StatusCode,
+		Path:   rs.Request.URL.Path,
+	}
+	if strings.HasPrefix(rs.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(rs.Body).Decode(apiErr); err == nil && apiErr.Message != "" {
+			return apiErr
+		}
+	}
+	apiErr.Message = http.StatusText(rs.StatusCode)
+	return apiErr
+}
@@ -0,0 +1,459 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/topi314/godrive/godrive"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestClient starts a godrive server without authentication on a temporary SQLite database and local storage.
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	_, ts := newTestServer(t, nil)
+	return New(ts.URL, WithHTTPClient(ts.Client()))
+}
+
+// newTestServer starts a godrive server on a temporary SQLite database and local storage.
+// With authConfig set, the server uses the database for its sessions.
+func newTestServer(t *testing.T, authConfig *godrive.AuthConfig) (*godrive.DB, *httptest.Server) {
+	t.Helper()
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cfg := godrive.Config{
+		Database: godrive.DatabaseConfig{
+			Type: godrive.DatabaseTypeSQLite,
+			Path: filepath.Join(dir, "godrive.db"),
+		},
+		Storage: godrive.StorageConfig{
+			Type: godrive.StorageTypeLocal,
+			Path: filepath.Join(dir, "storage"),
+		},
+		Auth: authConfig,
+	}
+	db, err := godrive.NewDB(ctx, cfg.Database, os.DirFS("../sql/migrations"))
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if _, err = db.MigrateUp(ctx, 0); err != nil {
+		t.Fatalf("failed to migrate database: %s", err)
+	}
+
+	tracer := trace.NewNoopTracerProvider().Tracer("godrive")
+	storage, err := godrive.NewStorage(ctx, cfg.Storage, tracer)
+	if err != nil {
+		t.Fatalf("failed to create storage: %s", err)
+	}
+
+	tmpl := func(w io.Writer, name string, data any) error {
+		return nil
+	}
+	writer := func(w io.Writer) error {
+		return nil
+	}
+	var auth *godrive.Auth
+	if authConfig != nil {
+		auth = &godrive.Auth{Sessions: db}
+	}
+	srv := godrive.NewServer("test", cfg, db, auth, storage, tracer, nil, http.Dir(dir), tmpl, writer, writer)
+	ts := httptest.NewServer(srv.Routes())
+	t.Cleanup(ts.Close)
+
+	return db, ts
+}
+
+func upload(t *testing.T, c *Client, dir string, name string, content string) {
+	t.Helper()
+	if err := c.Upload(context.Background(), dir, name, strings.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Fatalf("failed to upload %s/%s: %s", dir, name, err)
+	}
+}
+
+func download(t *testing.T, c *Client, filePath string) string {
+	t.Helper()
+	r, err := c.Download(context.Background(), filePath)
+	if err != nil {
+		t.Fatalf("failed to download %s: %s", filePath, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", filePath, err)
+	}
+	return string(data)
+}
+
+func fileNames(list *FileList) []string {
+	names := make([]string, len(list.Files))
+	for i, file := range list.Files {
+		names[i] = file.Name
+	}
+	return names
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	var sent int64
+	content := "hello godrive"
+	err := c.Upload(ctx, "/docs", "hello.txt", strings.NewReader(content), int64(len(content)), &UploadOptions{
+		Description: "greeting",
+		Progress: func(n int64, total int64) {
+			sent = n
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if sent != int64(len(content)) {
+		t.Errorf("progress reported %d bytes, want %d", sent, len(content))
+	}
+
+	if got := download(t, c, "/docs/hello.txt"); got != content {
+		t.Errorf("downloaded %q, want %q", got, content)
+	}
+
+	r, err := c.DownloadRange(ctx, "/docs/hello.txt", 6, 8)
+	if err != nil {
+		t.Fatalf("failed to download range: %s", err)
+	}
+	data, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(data) != "god" {
+		t.Errorf("downloaded range %q, want %q", data, "god")
+	}
+
+	file, err := c.Stat(ctx, "/docs/hello.txt")
+	if err != nil {
+		t.Fatalf("failed to stat: %s", err)
+	}
+	if file.Size != uint64(len(content)) || file.Description != "greeting" || file.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected file: %+v", file)
+	}
+
+	if _, err = c.Stat(ctx, "/docs"); !errors.Is(err, ErrIsDir) {
+		t.Errorf("stat of a folder returned %v, want ErrIsDir", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	upload(t, c, "/docs", "a.txt", "first")
+
+	content := "second"
+	if err := c.Update(ctx, "/docs/a.txt", strings.NewReader(content), int64(len(content)), &UpdateOptions{Name: "b.txt"}); err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+	if got := download(t, c, "/docs/b.txt"); got != content {
+		t.Errorf("downloaded %q, want %q", got, content)
+	}
+
+	if err := c.Update(ctx, "/docs/b.txt", nil, 0, &UpdateOptions{Description: "changed"}); err != nil {
+		t.Fatalf("failed to update metadata: %s", err)
+	}
+	file, err := c.Stat(ctx, "/docs/b.txt")
+	if err != nil {
+		t.Fatalf("failed to stat: %s", err)
+	}
+	if file.Description != "changed" || file.Size != uint64(len(content)) {
+		t.Errorf("unexpected file after metadata update: %+v", file)
+	}
+}
+
+func TestList(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	upload(t, c, "/", "root.txt", "root")
+	upload(t, c, "/docs", "a.txt", "a")
+	upload(t, c, "/docs/sub", "b.txt", "b")
+
+	list, err := c.List(ctx, "/docs")
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+	if got := strings.Join(fileNames(list), ","); got != "sub,a.txt" && got != "a.txt,sub" {
+		t.Errorf("listed %s, want a.txt and sub", got)
+	}
+
+	list, err = c.ListRecursive(ctx, "/docs")
+	if err != nil {
+		t.Fatalf("failed to list recursive: %s", err)
+	}
+	if len(list.Files) != 2 {
+		t.Errorf("listed %d files recursively, want 2: %v", len(list.Files), fileNames(list))
+	}
+}
+
+func TestMoveCopyDelete(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	upload(t, c, "/docs", "a.txt", "a")
+	upload(t, c, "/docs", "b.txt", "b")
+
+	if err := c.Move(ctx, "/docs/a.txt", "/moved/a.txt"); err != nil {
+		t.Fatalf("failed to move: %s", err)
+	}
+	if got := download(t, c, "/moved/a.txt"); got != "a" {
+		t.Errorf("downloaded %q after move, want %q", got, "a")
+	}
+
+	if err := c.Copy(ctx, "/docs", "/copy", "b.txt"); err != nil {
+		t.Fatalf("failed to copy: %s", err)
+	}
+	if got := download(t, c, "/copy/b.txt"); got != "b" {
+		t.Errorf("downloaded %q after copy, want %q", got, "b")
+	}
+
+	if err := c.Delete(ctx, "/docs", "b.txt"); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+	if _, err := c.Stat(ctx, "/docs/b.txt"); !isStatus(err, http.StatusNotFound) {
+		t.Errorf("stat after delete returned %v, want 404", err)
+	}
+	if got := download(t, c, "/copy/b.txt"); got != "b" {
+		t.Errorf("copy was changed by deleting the original: %q", got)
+	}
+}
+
+func TestShares(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	upload(t, c, "/docs", "a.txt", "shared")
+
+	share, err := c.CreateShare(ctx, ShareRequest{Path: "/docs/a.txt"})
+	if err != nil {
+		t.Fatalf("failed to create share: %s", err)
+	}
+	if share.Permission != SharePermissionRead || share.Path != "/docs/a.txt" {
+		t.Errorf("unexpected share: %+v", share)
+	}
+
+	rs, err := http.Get(c.ShareURL(*share))
+	if err != nil {
+		t.Fatalf("failed to get share: %s", err)
+	}
+	data, _ := io.ReadAll(rs.Body)
+	_ = rs.Body.Close()
+	if rs.StatusCode != http.StatusOK || string(data) != "shared" {
+		t.Errorf("share returned %d %q, want 200 %q", rs.StatusCode, data, "shared")
+	}
+
+	shares, err := c.Shares(ctx, "/docs/a.txt")
+	if err != nil {
+		t.Fatalf("failed to list shares: %s", err)
+	}
+	if len(shares) != 1 || shares[0].Token != share.Token {
+		t.Errorf("listed shares %+v, want the created share", shares)
+	}
+
+	if err = c.DeleteShare(ctx, share.Token); err != nil {
+		t.Fatalf("failed to delete share: %s", err)
+	}
+	if shares, err = c.Shares(ctx, ""); err != nil || len(shares) != 0 {
+		t.Errorf("listed shares %+v (%v) after delete, want none", shares, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	upload(t, c, "/docs", "a.txt", "a")
+
+	tests := []struct {
+		name   string
+		do     func() error
+		status int
+	}{
+		{
+			name: "stat missing file",
+			do: func() error {
+				_, err := c.Stat(ctx, "/docs/missing.txt")
+				return err
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "download missing file",
+			do: func() error {
+				_, err := c.Download(ctx, "/docs/missing.txt")
+				return err
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "upload existing file",
+			do: func() error {
+				return c.Upload(ctx, "/docs", "a.txt", strings.NewReader("b"), 1, nil)
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name: "upload with wrong size",
+			do: func() error {
+				return c.Upload(ctx, "/docs", "b.txt", strings.NewReader("abc"), 1, nil)
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "update missing file",
+			do: func() error {
+				return c.Update(ctx, "/docs/missing.txt", nil, 0, &UpdateOptions{Description: "x"})
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "move to reserved path",
+			do: func() error {
+				return c.Move(ctx, "/docs/a.txt", "/.godrive/a.txt")
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "move to same path",
+			do: func() error {
+				return c.Move(ctx, "/docs/a.txt", "/docs/a.txt")
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "share missing file",
+			do: func() error {
+				_, err := c.CreateShare(ctx, ShareRequest{Path: "/docs/missing.txt"})
+				return err
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "delete missing share",
+			do: func() error {
+				return c.DeleteShare(ctx, "missing")
+			},
+			status: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.do()
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got error %v, want *Error", err)
+			}
+			if apiErr.Status != tt.status {
+				t.Errorf("got status %d (%s), want %d", apiErr.Status, apiErr.Message, tt.status)
+			}
+			if apiErr.Message == "" {
+				t.Error("error has no message")
+			}
+		})
+	}
+
+	if got := download(t, c, "/docs/a.txt"); got != "a" {
+		t.Errorf("failed requests changed the file to %q", got)
+	}
+}
+
+func TestToken(t *testing.T) {
+	db, ts := newTestServer(t, &godrive.AuthConfig{
+		Mode: godrive.AuthModeLocal,
+		Groups: godrive.AuthGroups{
+			Admin:  "admin",
+			User:   "user",
+			Viewer: "viewer",
+		},
+	})
+	ctx := context.Background()
+
+	password, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %s", err)
+	}
+	if err = db.UpsertUser(ctx, "alice", "alice", "alice@localhost", "", []string{"user"}); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+	if err = db.SetUserPassword(ctx, "alice", string(password)); err != nil {
+		t.Fatalf("failed to set password: %s", err)
+	}
+
+	// personal access tokens are created with a session
+	jar, _ := cookiejar.New(nil)
+	httpClient := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	rs, err := httpClient.PostForm(ts.URL+"/login", url.Values{"username": {"alice"}, "password": {"password"}})
+	if err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+	_ = rs.Body.Close()
+	if rs.StatusCode != http.StatusFound {
+		t.Fatalf("login returned %d, want 302", rs.StatusCode)
+	}
+	rs, err = httpClient.Post(ts.URL+"/settings/tokens", "application/json", strings.NewReader(`{"name": "client", "scope": "write"}`))
+	if err != nil {
+		t.Fatalf("failed to create token: %s", err)
+	}
+	var tokenRs godrive.TokenResponse
+	err = json.NewDecoder(rs.Body).Decode(&tokenRs)
+	_ = rs.Body.Close()
+	if rs.StatusCode != http.StatusCreated || err != nil {
+		t.Fatalf("creating a token returned %d (%v), want 201", rs.StatusCode, err)
+	}
+
+	c := New(ts.URL, WithHTTPClient(ts.Client()), WithToken(tokenRs.Token))
+	upload(t, c, "/docs", "a.txt", "a")
+	file, err := c.Stat(ctx, "/docs/a.txt")
+	if err != nil {
+		t.Fatalf("failed to stat with token: %s", err)
+	}
+	if !file.IsOwner {
+		t.Errorf("uploaded file is owned by %q, want alice", file.Owner)
+	}
+
+	expectUnauthorized := func(token string) {
+		t.Helper()
+		_, err := New(ts.URL, WithHTTPClient(ts.Client()), WithToken(token)).List(ctx, "/docs")
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("got error %v, want *Error", err)
+		}
+		if apiErr.Status != http.StatusUnauthorized {
+			t.Errorf("got status %d (%s), want 401", apiErr.Status, apiErr.Message)
+		}
+	}
+	expectUnauthorized(godrive.TokenPrefix + "invalid")
+
+	rq, _ := http.NewRequest(http.MethodDelete, ts.URL+"/settings/tokens/"+tokenRs.ID, nil)
+	if rs, err = httpClient.Do(rq); err != nil {
+		t.Fatalf("failed to delete token: %s", err)
+	}
+	_ = rs.Body.Close()
+	if rs.StatusCode != http.StatusNoContent {
+		t.Fatalf("deleting the token returned %d, want 204", rs.StatusCode)
+	}
+	expectUnauthorized(tokenRs.Token)
+}
+
+func isStatus(err error, status int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Status == status
+}
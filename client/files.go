@@ -0,0 +1,304 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const filesEndpoint = "/api/v1/files"
+
+// ErrIsDir is returned by Client.Stat for folders.
+var ErrIsDir = errors.New("path is a folder")
+
+// File is a file or a synthesized folder.
+type File struct {
+	Path        string     `json:"path"`
+	Dir         string     `json:"dir"`
+	Name        string     `json:"name"`
+	IsDir       bool       `json:"is_dir"`
+	Size        uint64     `json:"size"`
+	ContentType string     `json:"content_type"`
+	Description string     `json:"description"`
+	Owner       string     `json:"owner"`
+	IsOwner     bool       `json:"is_owner"`
+	CreatedAt   *time.Time `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+type FileList struct {
+	Path  string `json:"path"`
+	Files []File `json:"files"`
+}
+
+// ProgressFunc is called while a file is uploaded with the number of bytes sent so far and the total size.
+type ProgressFunc func(sent int64, total int64)
+
+type UploadOptions struct {
+	Description string
+	// ContentType defaults to the type of the file extension.
+	ContentType string
+	Progress    ProgressFunc
+}
+
+type UpdateOptions struct {
+	// Dir moves the file into another folder, the current folder is kept if empty.
+	Dir string
+	// Name renames the file, the current name is kept if empty.
+	Name        string
+	Description string
+	ContentType string
+	Progress    ProgressFunc
+}
+
+// List returns the direct children of a folder. Subfolders are synthesized from the files below them.
+func (c *Client) List(ctx context.Context, dir string) (*FileList, error) {
+	return c.list(ctx, dir, nil)
+}
+
+// ListRecursive returns all files below a folder.
+func (c *Client) ListRecursive(ctx context.Context, dir string) (*FileList, error) {
+	return c.list(ctx, dir, url.Values{"recursive": {"true"}})
+}
+
+func (c *Client) list(ctx context.Context, dir string, query url.Values) (*FileList, error) {
+	rq, err := c.newRequest(ctx, http.MethodGet, path.Join(filesEndpoint, dir), query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var list FileList
+	if err = c.do(rq, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Stat returns the metadata of a single file or ErrIsDir if the path is a folder.
+func (c *Client) Stat(ctx context.Context, filePath string) (*File, error) {
+	rq, err := c.newRequest(ctx, http.MethodGet, path.Join(filesEndpoint, filePath), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var raw json.RawMessage
+	if err = c.do(rq, &raw); err != nil {
+		return nil, err
+	}
+
+	// folders return a listing instead of the file metadata
+	var list FileList
+	if err = json.Unmarshal(raw, &list); err == nil && list.Files != nil {
+		return nil, ErrIsDir
+	}
+	var file File
+	if err = json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	return &file, nil
+}
+
+// Download returns the content of a file.
+func (c *Client) Download(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	return c.DownloadRange(ctx, filePath, 0, -1)
+}
+
+// DownloadRange returns the bytes from start to end (inclusive) of a file. An end of -1 reads until the end of the file.
+func (c *Client) DownloadRange(ctx context.Context, filePath string, start int64, end int64) (io.ReadCloser, error) {
+	rq, err := c.newRequest(ctx, http.MethodGet, path.Join(filesEndpoint, filePath), url.Values{"dl": {"true"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if start > 0 || end >= 0 {
+		if end >= 0 {
+			rq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		} else {
+			rq.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+		}
+	}
+	return c.stream(rq)
+}
+
+// DownloadZip returns a zip archive of a folder. If names are given only these files and folders of the folder are included.
+func (c *Client) DownloadZip(ctx context.Context, dir string, names ...string) (io.ReadCloser, error) {
+	dl := "true"
+	if len(names) > 0 {
+		dl = strings.Join(names, ",")
+	}
+	rq, err := c.newRequest(ctx, http.MethodGet, path.Join(filesEndpoint, dir), url.Values{"dl": {dl}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.stream(rq)
+}
+
+// Upload creates the file name in dir with size bytes read from r.
+func (c *Client) Upload(ctx context.Context, dir string, name string, r io.Reader, size int64, opts *UploadOptions) error {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	return c.sendFile(ctx, http.MethodPost, dir, fileRequest{
+		Size:        size,
+		Description: opts.Description,
+	}, name, r, size, opts.ContentType, opts.Progress)
+}
+
+// Update replaces the content of a file with size bytes read from r and changes its name, folder or description.
+// Pass a nil reader and a size of 0 to only change the metadata. The previous content is kept as a version by the server.
+func (c *Client) Update(ctx context.Context, filePath string, r io.Reader, size int64, opts *UpdateOptions) error {
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+	dir := opts.Dir
+	if dir == "" {
+		dir = path.Dir(filePath)
+	}
+	name := opts.Name
+	if name == "" {
+		name = path.Base(filePath)
+	}
+	if r == nil {
+		r = bytes.NewReader(nil)
+		size = 0
+	}
+	return c.sendFile(ctx, http.MethodPatch, filePath, fileRequest{
+		Size:        size,
+		Description: opts.Description,
+		Dir:         dir,
+	}, name, r, size, opts.ContentType, opts.Progress)
+}
+
+// Move moves a file or folder to the destination path. If names are given only these files and folders of the folder are moved into the destination.
+func (c *Client) Move(ctx context.Context, filePath string, destination string, names ...string) error {
+	rq, err := c.newFilesRequest(ctx, http.MethodPut, filePath, names)
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Destination", destination)
+	return c.do(rq, nil)
+}
+
+// Copy copies a file or folder to the destination path. If names are given only these files and folders of the folder are copied into the destination.
+func (c *Client) Copy(ctx context.Context, filePath string, destination string, names ...string) error {
+	rq, err := c.newFilesRequest(ctx, "COPY", filePath, names)
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Destination", destination)
+	return c.do(rq, nil)
+}
+
+// Delete moves a file or folder into the trash. If names are given only these files and folders of the folder are deleted.
+func (c *Client) Delete(ctx context.Context, filePath string, names ...string) error {
+	rq, err := c.newFilesRequest(ctx, http.MethodDelete, filePath, names)
+	if err != nil {
+		return err
+	}
+	return c.do(rq, nil)
+}
+
+func (c *Client) newFilesRequest(ctx context.Context, method string, filePath string, names []string) (*http.Request, error) {
+	var body io.Reader
+	if len(names) > 0 {
+		data, err := json.Marshal(names)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	rq, err := c.newRequest(ctx, method, path.Join(filesEndpoint, filePath), nil, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		rq.Header.Set("Content-Type", "application/json")
+	}
+	return rq, nil
+}
+
+type fileRequest struct {
+	Size        int64  `json:"size"`
+	Description string `json:"description"`
+	Dir         string `json:"dir,omitempty"`
+}
+
+// sendFile streams the multipart body expected by the server: a json part followed by the file part.
+func (c *Client) sendFile(ctx context.Context, method string, filePath string, fileRq fileRequest, name string, r io.Reader, size int64, contentType string, progress ProgressFunc) error {
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+	if progress != nil {
+		r = &progressReader{r: r, total: size, progress: progress}
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeMultipart(mw, fileRq, name, r, contentType))
+	}()
+
+	rq, err := c.newRequest(ctx, method, path.Join(filesEndpoint, filePath), nil, pr)
+	if err != nil {
+		_ = pr.Close()
+		return err
+	}
+	rq.Header.Set("Content-Type", mw.FormDataContentType())
+	err = c.do(rq, nil)
+	_ = pr.Close()
+	return err
+}
+
+func writeMultipart(mw *multipart.Writer, fileRq fileRequest, name string, r io.Reader, contentType string) error {
+	jsonHeader := textproto.MIMEHeader{}
+	jsonHeader.Set("Content-Disposition", `form-data; name="json"`)
+	jsonHeader.Set("Content-Type", "application/json")
+	part, err := mw.CreatePart(jsonHeader)
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(part).Encode(fileRq); err != nil {
+		return err
+	}
+
+	fileHeader := textproto.MIMEHeader{}
+	fileHeader.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+		"name":     "file",
+		"filename": name,
+	}))
+	fileHeader.Set("Content-Type", contentType)
+	if part, err = mw.CreatePart(fileHeader); err != nil {
+		return err
+	}
+	if _, err = io.Copy(part, r); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.progress(r.sent, r.total)
+	}
+	return n, err
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+const sharesEndpoint = "/shares"
+
+type SharePermission string
+
+const (
+	SharePermissionRead   SharePermission = "read"
+	SharePermissionUpload SharePermission = "upload"
+)
+
+type ShareRequest struct {
+	Path     string `json:"path"`
+	Password string `json:"password,omitempty"`
+	// Permission defaults to SharePermissionRead. Only folders can be shared with SharePermissionUpload.
+	Permission SharePermission `json:"permission,omitempty"`
+	// MaxDownloads limits how often the share can be downloaded, 0 means unlimited.
+	MaxDownloads uint64     `json:"max_downloads"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+type Share struct {
+	Token string `json:"token"`
+	// URL is relative to the server, use Client.ShareURL for the absolute url.
+	URL          string          `json:"url"`
+	Path         string          `json:"path"`
+	Owner        string          `json:"owner"`
+	Password     bool            `json:"password"`
+	Permission   SharePermission `json:"permission"`
+	MaxDownloads uint64          `json:"max_downloads"`
+	Downloads    uint64          `json:"downloads"`
+	ExpiresAt    *time.Time      `json:"expires_at"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// CreateShare creates a public link to a file or folder.
+func (c *Client) CreateShare(ctx context.Context, shareRq ShareRequest) (*Share, error) {
+	data, err := json.Marshal(shareRq)
+	if err != nil {
+		return nil, err
+	}
+	rq, err := c.newRequest(ctx, http.MethodPost, sharesEndpoint, nil, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	rq.Header.Set("Content-Type", "application/json")
+
+	var share Share
+	if err = c.do(rq, &share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// Shares returns the shares of the current user, optionally limited to a path.
+func (c *Client) Shares(ctx context.Context, sharePath string) ([]Share, error) {
+	var query url.Values
+	if sharePath != "" {
+		query = url.Values{"path": {sharePath}}
+	}
+	rq, err := c.newRequest(ctx, http.MethodGet, sharesEndpoint, query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var shares []Share
+	if err = c.do(rq, &shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+func (c *Client) DeleteShare(ctx context.Context, token string) error {
+	rq, err := c.newRequest(ctx, http.MethodDelete, path.Join(sharesEndpoint, token), nil, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(rq, nil)
+}
+
+// ShareURL returns the absolute url of a share.
+func (c *Client) ShareURL(share Share) string {
+	return c.baseURL + share.URL
+}
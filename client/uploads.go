@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+)
+
+const uploadsEndpoint = "/api/v1/uploads"
+
+type presignedUploadRequest struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	Description string `json:"description"`
+}
+
+type presignedUpload struct {
+	ID        string          `json:"id"`
+	URL       string          `json:"url"`
+	PartSize  int64           `json:"part_size"`
+	Parts     []presignedPart `json:"parts"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+type presignedPart struct {
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+}
+
+type uploadedPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+}
+
+// UploadPresigned creates the file name in dir like Upload, but sends the content directly to the storage of the server with presigned URLs.
+// The server has to use the S3 storage with presigned uploads enabled. Unfinished uploads are aborted if an error occurs.
+func (c *Client) UploadPresigned(ctx context.Context, dir string, name string, r io.Reader, size int64, opts *UploadOptions) error {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if opts.Progress != nil {
+		r = &progressReader{r: r, total: size, progress: opts.Progress}
+	}
+
+	var upload presignedUpload
+	if err := c.sendJSON(ctx, http.MethodPost, uploadsEndpoint, presignedUploadRequest{
+		Path:        path.Join("/", dir, name),
+		Size:        size,
+		ContentType: contentType,
+		Description: opts.Description,
+	}, &upload); err != nil {
+		return err
+	}
+
+	if err := c.uploadParts(ctx, upload, r, size); err != nil {
+		c.abortUpload(upload.ID)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) uploadParts(ctx context.Context, upload presignedUpload, r io.Reader, size int64) error {
+	parts := upload.Parts
+	partSize := upload.PartSize
+	if upload.URL != "" {
+		parts = []presignedPart{{URL: upload.URL}}
+		partSize = size
+	}
+
+	uploaded := make([]uploadedPart, 0, len(parts))
+	for i, part := range parts {
+		n := partSize
+		if remaining := size - int64(i)*partSize; remaining < n {
+			n = remaining
+		}
+		etag, err := c.putPart(ctx, part.URL, io.LimitReader(r, n), n)
+		if err != nil {
+			return fmt.Errorf("error uploading part %d: %w", part.Number, err)
+		}
+		uploaded = append(uploaded, uploadedPart{
+			Number: part.Number,
+			ETag:   etag,
+		})
+	}
+
+	return c.sendJSON(ctx, http.MethodPost, path.Join(uploadsEndpoint, upload.ID), struct {
+		Parts []uploadedPart `json:"parts"`
+	}{Parts: uploaded}, nil)
+}
+
+// putPart uploads to a presigned URL, which must not be sent with the token of the client.
+func (c *Client) putPart(ctx context.Context, url string, r io.Reader, size int64) (string, error) {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPut, url, r)
+	if err != nil {
+		return "", err
+	}
+	rq.ContentLength = size
+	if size == 0 {
+		rq.Body = http.NoBody
+	}
+	rs, err := c.config.HTTPClient.Do(rq)
+	if err != nil {
+		return "", err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode < 200 || rs.StatusCode >= 300 {
+		return "", &Error{
+			Status:  rs.StatusCode,
+			Path:    rq.URL.Path,
+			Message: http.StatusText(rs.StatusCode),
+		}
+	}
+	return rs.Header.Get("ETag"), nil
+}
+
+func (c *Client) abortUpload(id string) {
+	// the context of the upload may be canceled already
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rq, err := c.newRequest(ctx, http.MethodDelete, path.Join(uploadsEndpoint, id), nil, nil)
+	if err != nil {
+		return
+	}
+	_ = c.do(rq, nil)
+}
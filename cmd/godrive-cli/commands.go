@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/topi314/godrive/client"
+)
+
+func (c *cli) ls(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ExitOnError)
+	recursive := flags.Bool("r", false, "list all files below the folder")
+	_ = flags.Parse(args)
+
+	dir := "/"
+	if flags.NArg() > 0 {
+		dir = remotePath(flags.Arg(0))
+	}
+
+	var (
+		list *client.FileList
+		err  error
+	)
+	if *recursive {
+		list, err = c.client.ListRecursive(ctx, dir)
+	} else {
+		list, err = c.client.List(ctx, dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SIZE\tUPDATED\tOWNER\tNAME")
+	for _, file := range list.Files {
+		name := file.Name
+		if *recursive {
+			name = strings.TrimPrefix(strings.TrimPrefix(file.Path, list.Path), "/")
+		}
+		if file.IsDir {
+			name += "/"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", humanize.IBytes(file.Size), file.UpdatedAt.Local().Format(time.DateTime), file.Owner, name)
+	}
+	return w.Flush()
+}
+
+func (c *cli) put(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("put", flag.ExitOnError)
+	description := flags.String("m", "", "description of the uploaded files")
+	_ = flags.Parse(args)
+	if flags.NArg() < 2 {
+		return errors.New("usage: put [-m description] <local>... <dir>")
+	}
+
+	dir := remotePath(flags.Arg(flags.NArg() - 1))
+	var transfers []transfer
+	for _, local := range flags.Args()[:flags.NArg()-1] {
+		err := filepath.WalkDir(local, func(localPath string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(filepath.Dir(filepath.Clean(local)), localPath)
+			if err != nil {
+				return err
+			}
+			transfers = append(transfers, transfer{
+				local:       localPath,
+				remote:      path.Join(dir, filepath.ToSlash(rel)),
+				description: *description,
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// existing files are replaced, which keeps their previous content as a version
+	for i, t := range transfers {
+		_, err := c.client.Stat(ctx, t.remote)
+		if err == nil {
+			transfers[i].replace = true
+		} else if !isNotFound(err) {
+			return err
+		}
+	}
+	return c.upload(ctx, transfers)
+}
+
+func (c *cli) get(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	asZip := flags.Bool("zip", false, "download a folder as zip archive")
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errors.New("usage: get [-zip] <path> [local]")
+	}
+
+	remote := remotePath(flags.Arg(0))
+	local := flags.Arg(1)
+	if local == "" {
+		local = path.Base(remote)
+	} else if info, err := os.Stat(local); err == nil && info.IsDir() {
+		local = filepath.Join(local, path.Base(remote))
+	}
+
+	file, err := c.client.Stat(ctx, remote)
+	if err == nil {
+		return c.download(ctx, []transfer{{
+			local:     local,
+			remote:    remote,
+			updatedAt: file.UpdatedAt,
+		}})
+	}
+	if !errors.Is(err, client.ErrIsDir) {
+		return err
+	}
+
+	if *asZip {
+		if !strings.HasSuffix(local, ".zip") {
+			local += ".zip"
+		}
+		fmt.Printf("download %s -> %s\n", remote, local)
+		if c.dryRun {
+			return nil
+		}
+		rc, err := c.client.DownloadZip(ctx, remote)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return writeLocalFile(local, rc, time.Time{})
+	}
+
+	list, err := c.client.ListRecursive(ctx, remote)
+	if err != nil {
+		return err
+	}
+	transfers := make([]transfer, 0, len(list.Files))
+	for _, file := range list.Files {
+		rel := strings.TrimPrefix(file.Path, strings.TrimSuffix(remote, "/")+"/")
+		transfers = append(transfers, transfer{
+			local:     filepath.Join(local, filepath.FromSlash(rel)),
+			remote:    file.Path,
+			updatedAt: file.UpdatedAt,
+		})
+	}
+	return c.download(ctx, transfers)
+}
+
+func (c *cli) mv(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: mv <path> <destination>")
+	}
+	src, dst := remotePath(args[0]), remotePath(args[1])
+	fmt.Printf("move %s -> %s\n", src, dst)
+	if c.dryRun {
+		return nil
+	}
+	return c.client.Move(ctx, src, dst)
+}
+
+func (c *cli) cp(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: cp <path> <destination>")
+	}
+	src, dst := remotePath(args[0]), remotePath(args[1])
+	fmt.Printf("copy %s -> %s\n", src, dst)
+	if c.dryRun {
+		return nil
+	}
+	return c.client.Copy(ctx, src, dst)
+}
+
+func (c *cli) rm(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: rm <path>...")
+	}
+	var errs error
+	for _, arg := range args {
+		remote := remotePath(arg)
+		fmt.Printf("delete %s\n", remote)
+		if c.dryRun {
+			continue
+		}
+		if err := c.client.Delete(ctx, remote); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("error deleting %s: %w", remote, err))
+		}
+	}
+	return errs
+}
+
+func (c *cli) share(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("share", flag.ExitOnError)
+	list := flags.Bool("list", false, "list your shares, optionally only the ones of path")
+	revoke := flags.String("revoke", "", "delete the share with this token")
+	password := flags.String("password", "", "password required to open the share")
+	expiresIn := flags.Duration("expires", 0, "how long the share is valid, 0 never expires")
+	maxDownloads := flags.Uint64("max-downloads", 0, "how often the share can be downloaded, 0 is unlimited")
+	upload := flags.Bool("upload", false, "allow uploads into a shared folder")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "usage: share [flags] <path> | share -list [path] | share -revoke <token>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	switch {
+	case *revoke != "":
+		fmt.Printf("revoke %s\n", *revoke)
+		if c.dryRun {
+			return nil
+		}
+		return c.client.DeleteShare(ctx, *revoke)
+	case *list:
+		var sharePath string
+		if flags.NArg() > 0 {
+			sharePath = remotePath(flags.Arg(0))
+		}
+		shares, err := c.client.Shares(ctx, sharePath)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "TOKEN\tPATH\tPERMISSION\tDOWNLOADS\tEXPIRES\tURL")
+		for _, share := range shares {
+			downloads := fmt.Sprint(share.Downloads)
+			if share.MaxDownloads > 0 {
+				downloads += fmt.Sprintf("/%d", share.MaxDownloads)
+			}
+			expires := "never"
+			if share.ExpiresAt != nil {
+				expires = share.ExpiresAt.Local().Format(time.DateTime)
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", share.Token, share.Path, share.Permission, downloads, expires, c.client.ShareURL(share))
+		}
+		return w.Flush()
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("missing path")
+	}
+	shareRq := client.ShareRequest{
+		Path:         remotePath(flags.Arg(0)),
+		Password:     *password,
+		Permission:   client.SharePermissionRead,
+		MaxDownloads: *maxDownloads,
+	}
+	if *upload {
+		shareRq.Permission = client.SharePermissionUpload
+	}
+	if *expiresIn > 0 {
+		expiresAt := time.Now().Add(*expiresIn)
+		shareRq.ExpiresAt = &expiresAt
+	}
+	if c.dryRun {
+		fmt.Printf("share %s\n", shareRq.Path)
+		return nil
+	}
+	share, err := c.client.CreateShare(ctx, shareRq)
+	if err != nil {
+		return err
+	}
+	fmt.Println(c.client.ShareURL(*share))
+	return nil
+}
+
+// remotePath makes every path on the server absolute.
+func remotePath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// writeLocalFile writes r to name via a temporary file and sets its modification time if it is not zero.
+func writeLocalFile(name string, r io.Reader, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err = f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		if err = os.Chtimes(f.Name(), modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,120 @@
+// Command godrive-cli manages files on a godrive server via its JSON API.
+//
+//	godrive-cli -server https://godrive.example.com -token gdp_... ls /docs
+//
+// The server and token default to the GODRIVE_SERVER and GODRIVE_TOKEN environment variables.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/topi314/godrive/client"
+)
+
+const usage = `usage: godrive-cli [flags] <command> [args]
+
+commands:
+  ls [-r] [path]                     list a folder
+  put [-m description] <local>... <dir>
+                                     upload files or folders into a folder
+  get [-zip] <path> [local]          download a file or folder
+  mv <path> <destination>            move or rename a file or folder
+  cp <path> <destination>            copy a file or folder
+  rm <path>...                       move files or folders into the trash
+  share [flags] <path>               create a public link, see "share -h"
+  sync [-pull] [-delete] <local> <dir>
+                                     mirror a local folder to a folder on the server or back with -pull
+
+flags:
+`
+
+// cli holds the global flags shared by all commands.
+type cli struct {
+	client   *client.Client
+	parallel int
+	dryRun   bool
+	presign  bool
+}
+
+func main() {
+	flags := flag.NewFlagSet("godrive-cli", flag.ExitOnError)
+	flags.Usage = func() {
+		_, _ = fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	server := flags.String("server", envOr("GODRIVE_SERVER", "http://localhost"), "url of the godrive server")
+	token := flags.String("token", os.Getenv("GODRIVE_TOKEN"), "personal access token")
+	parallel := flags.Int("parallel", 4, "number of parallel transfers")
+	dryRun := flags.Bool("dry-run", false, "only print what would be changed")
+	presign := flags.Bool("presign", false, "upload new files directly to the S3 storage of the server")
+	_ = flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *parallel < 1 {
+		*parallel = 1
+	}
+
+	var opts []client.ConfigOpt
+	if *token != "" {
+		opts = append(opts, client.WithToken(*token))
+	}
+	c := &cli{
+		client:   client.New(*server, opts...),
+		parallel: *parallel,
+		dryRun:   *dryRun,
+		presign:  *presign,
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := c.run(ctx, args[0], args[1:]); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "godrive-cli:", err)
+		os.Exit(1)
+	}
+}
+
+func (c *cli) run(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "ls":
+		return c.ls(ctx, args)
+	case "put":
+		return c.put(ctx, args)
+	case "get":
+		return c.get(ctx, args)
+	case "mv":
+		return c.mv(ctx, args)
+	case "cp":
+		return c.cp(ctx, args)
+	case "rm":
+		return c.rm(ctx, args)
+	case "share":
+		return c.share(ctx, args)
+	case "sync":
+		return c.sync(ctx, args)
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// isNotFound reports whether err is a 404 returned by the server.
+func isNotFound(err error) bool {
+	var clientErr *client.Error
+	return errors.As(err, &clientErr) && clientErr.Status == 404
+}
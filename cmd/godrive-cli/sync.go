@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/topi314/godrive/client"
+)
+
+// transfer is a single file to upload or download.
+type transfer struct {
+	local  string
+	remote string
+	// replace updates an existing file on the server instead of creating it.
+	replace     bool
+	description string
+	// updatedAt is set as modification time of downloaded files, so they are not transferred again by the next sync.
+	updatedAt time.Time
+}
+
+// sync mirrors a local folder to a folder on the server or the other way around.
+// Files are transferred if they are missing, their sizes differ or the source was modified after the target.
+func (c *cli) sync(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	pull := flags.Bool("pull", false, "mirror the folder on the server to the local folder")
+	deleteExtra := flags.Bool("delete", false, "delete files which do not exist in the source")
+	_ = flags.Parse(args)
+	if flags.NArg() != 2 {
+		return errors.New("usage: sync [-pull] [-delete] <local> <dir>")
+	}
+	localDir, remoteDir := filepath.Clean(flags.Arg(0)), remotePath(flags.Arg(1))
+
+	localFiles, err := listLocal(localDir)
+	if err != nil {
+		return err
+	}
+	remoteFiles, err := c.listRemote(ctx, remoteDir)
+	if err != nil {
+		return err
+	}
+
+	if *pull {
+		var transfers []transfer
+		for _, rel := range sortedKeys(remoteFiles) {
+			remote := remoteFiles[rel]
+			local, ok := localFiles[rel]
+			if ok && int64(remote.Size) == local.Size() && !truncate(remote.UpdatedAt).After(truncate(local.ModTime())) {
+				continue
+			}
+			transfers = append(transfers, transfer{
+				local:     filepath.Join(localDir, filepath.FromSlash(rel)),
+				remote:    remote.Path,
+				updatedAt: remote.UpdatedAt,
+			})
+		}
+		if err = c.download(ctx, transfers); err != nil {
+			return err
+		}
+		if !*deleteExtra {
+			return nil
+		}
+
+		var errs error
+		for _, rel := range sortedKeys(localFiles) {
+			if _, ok := remoteFiles[rel]; ok {
+				continue
+			}
+			local := filepath.Join(localDir, filepath.FromSlash(rel))
+			fmt.Printf("delete %s\n", local)
+			if c.dryRun {
+				continue
+			}
+			errs = errors.Join(errs, os.Remove(local))
+		}
+		return errs
+	}
+
+	var transfers []transfer
+	for _, rel := range sortedKeys(localFiles) {
+		local := localFiles[rel]
+		remote, ok := remoteFiles[rel]
+		if ok && int64(remote.Size) == local.Size() && !truncate(local.ModTime()).After(truncate(remote.UpdatedAt)) {
+			continue
+		}
+		transfers = append(transfers, transfer{
+			local:   filepath.Join(localDir, filepath.FromSlash(rel)),
+			remote:  path.Join(remoteDir, rel),
+			replace: ok,
+		})
+	}
+	if err = c.upload(ctx, transfers); err != nil {
+		return err
+	}
+	if !*deleteExtra {
+		return nil
+	}
+
+	var errs error
+	for _, rel := range sortedKeys(remoteFiles) {
+		if _, ok := localFiles[rel]; ok {
+			continue
+		}
+		remote := remoteFiles[rel].Path
+		fmt.Printf("delete %s\n", remote)
+		if c.dryRun {
+			continue
+		}
+		if err = c.client.Delete(ctx, remote); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("error deleting %s: %w", remote, err))
+		}
+	}
+	return errs
+}
+
+// listLocal returns all regular files below dir by their slash separated path relative to dir.
+func listLocal(dir string) (map[string]fs.FileInfo, error) {
+	files := map[string]fs.FileInfo{}
+	err := filepath.WalkDir(dir, func(localPath string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && localPath == dir {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, localPath)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = info
+		return nil
+	})
+	return files, err
+}
+
+// listRemote returns all files below dir by their path relative to dir.
+func (c *cli) listRemote(ctx context.Context, dir string) (map[string]client.File, error) {
+	list, err := c.client.ListRecursive(ctx, dir)
+	if isNotFound(err) {
+		return map[string]client.File{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]client.File, len(list.Files))
+	for _, file := range list.Files {
+		files[strings.TrimPrefix(file.Path, strings.TrimSuffix(dir, "/")+"/")] = file
+	}
+	return files, nil
+}
+
+func (c *cli) upload(ctx context.Context, transfers []transfer) error {
+	return c.parallelize(ctx, transfers, func(ctx context.Context, t transfer) error {
+		fmt.Printf("upload %s -> %s\n", t.local, t.remote)
+		if c.dryRun {
+			return nil
+		}
+		f, err := os.Open(t.local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		if t.replace {
+			return c.client.Update(ctx, t.remote, f, info.Size(), &client.UpdateOptions{
+				Description: t.description,
+			})
+		}
+		opts := &client.UploadOptions{
+			Description: t.description,
+		}
+		if c.presign {
+			return c.client.UploadPresigned(ctx, path.Dir(t.remote), path.Base(t.remote), f, info.Size(), opts)
+		}
+		return c.client.Upload(ctx, path.Dir(t.remote), path.Base(t.remote), f, info.Size(), opts)
+	})
+}
+
+func (c *cli) download(ctx context.Context, transfers []transfer) error {
+	return c.parallelize(ctx, transfers, func(ctx context.Context, t transfer) error {
+		fmt.Printf("download %s -> %s\n", t.remote, t.local)
+		if c.dryRun {
+			return nil
+		}
+		rc, err := c.client.Download(ctx, t.remote)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return writeLocalFile(t.local, rc, t.updatedAt)
+	})
+}
+
+// parallelize runs fn for all transfers with at most c.parallel at the same time and returns all errors.
+func (c *cli) parallelize(ctx context.Context, transfers []transfer, fn func(ctx context.Context, t transfer) error) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs error
+	)
+	queue := make(chan transfer)
+	for i := 0; i < c.parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				if err := fn(ctx, t); err != nil {
+					mu.Lock()
+					errs = errors.Join(errs, fmt.Errorf("error transferring %s: %w", t.remote, err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, t := range transfers {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- t
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return errors.Join(errs, err)
+	}
+	return errs
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// truncate drops sub second precision which not every file system keeps.
+func truncate(t time.Time) time.Time {
+	return t.Truncate(time.Second)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"github.com/topi314/godrive/godrive"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func migrations() fs.FS {
+	migrationsFS, _ := fs.Sub(Migrations, "sql/migrations")
+	return migrationsFS
+}
+
+// newStorage creates the configured storage with encryption and deduplication.
+func newStorage(ctx context.Context, cfg godrive.StorageConfig, db *godrive.DB, tracer trace.Tracer) (godrive.Storage, error) {
+	// presigned URLs access the objects as they are stored, which are neither decrypted nor deduplicated paths
+	if cfg.Presign != nil && (cfg.Type != godrive.StorageTypeS3 || cfg.Encryption != nil || cfg.Deduplicate) {
+		return nil, errors.New("presigned URLs require the s3 storage without encryption and deduplication")
+	}
+	storage, err := godrive.NewStorage(ctx, cfg, tracer)
+	if err != nil {
+		return nil, err
+	}
+	// encryption wraps the storage first, so deduplication still compares the plain content
+	if cfg.Encryption != nil {
+		if storage, err = godrive.NewEncryptStorage(storage, *cfg.Encryption, tracer); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Deduplicate {
+		storage = godrive.NewDedupStorage(storage, db, tracer)
+	}
+	return storage, nil
+}
+
+func runCommand(cfg godrive.Config, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(cfg, args[1:])
+	case "rekey":
+		return runRekey(cfg)
+	case "fsck":
+		return runFsck(cfg, args[1:])
+	case "storage":
+		return runStorage(cfg, args[1:])
+	case "invite":
+		return runInvite(cfg, args[1:])
+	}
+	return fmt.Errorf("unknown command: %s", args[0])
+}
+
+// runMigrate implements "godrive migrate status|up|down [steps]".
+func runMigrate(cfg godrive.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: godrive migrate status|up|down [steps]")
+	}
+	var steps int
+	if len(args) > 1 {
+		var err error
+		if steps, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid steps: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	db, err := godrive.NewDB(ctx, cfg.Database, migrations())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var migrated []godrive.Migration
+	switch args[0] {
+	case "status":
+		status, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, migration := range status {
+			appliedAt := "pending"
+			if migration.AppliedAt != nil {
+				appliedAt = migration.AppliedAt.Format(time.RFC3339)
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", migration.Version, migration.Name, appliedAt)
+		}
+		return w.Flush()
+	case "up":
+		migrated, err = db.MigrateUp(ctx, steps)
+	case "down":
+		migrated, err = db.MigrateDown(ctx, steps)
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+	for _, migration := range migrated {
+		fmt.Printf("%s %d_%s\n", args[0], migration.Version, migration.Name)
+	}
+	return err
+}
+
+// runRekey implements "godrive rekey". It re-wraps the data keys of all objects with the current encryption key and encrypts unencrypted objects.
+// Old keys can be removed from the config once it finished without errors. godrive has to be stopped while it runs,
+// otherwise files uploaded during the rekey can be overwritten with their previous content.
+func runRekey(cfg godrive.Config) error {
+	if cfg.Storage.Encryption == nil {
+		return errors.New("storage encryption is not configured")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	db, err := godrive.NewDB(ctx, cfg.Database, migrations())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tracer := trace.NewNoopTracerProvider().Tracer(Namespace)
+	storage, err := godrive.NewStorage(ctx, cfg.Storage, tracer)
+	if err != nil {
+		return err
+	}
+	if storage, err = godrive.NewEncryptStorage(storage, *cfg.Storage.Encryption, tracer); err != nil {
+		return err
+	}
+
+	var rekeyed int
+	err = godrive.RekeyStorage(ctx, storage, db, func(filePath string, err error) {
+		if err != nil {
+			fmt.Printf("error %s: %s\n", filePath, err)
+			return
+		}
+		rekeyed++
+		fmt.Printf("rekeyed %s\n", filePath)
+	})
+	fmt.Printf("rekeyed %d objects\n", rekeyed)
+	return err
+}
+
+// runFsck implements "godrive fsck [-delete-orphans] [-import] [-owner id] [-fix-sizes]".
+func runFsck(cfg godrive.Config, args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	var opts godrive.FsckOptions
+	flags.BoolVar(&opts.DeleteOrphans, "delete-orphans", false, "delete objects without database row")
+	flags.BoolVar(&opts.Import, "import", false, "create files for objects without database row instead of deleting them")
+	flags.StringVar(&opts.ImportOwner, "owner", "", "id of the user owning imported files, required with -import")
+	flags.BoolVar(&opts.FixSizes, "fix-sizes", false, "update sizes in the database to the size of the objects")
+	_ = flags.Parse(args)
+	if opts.Import && opts.ImportOwner == "" {
+		return errors.New("-import requires -owner")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	db, err := godrive.NewDB(ctx, cfg.Database, migrations())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err = godrive.CheckFsckOptions(ctx, db, opts, cfg.Auth != nil); err != nil {
+		return err
+	}
+
+	storage, err := newStorage(ctx, cfg.Storage, db, trace.NewNoopTracerProvider().Tracer(Namespace))
+	if err != nil {
+		return err
+	}
+
+	report, err := godrive.Fsck(ctx, db, storage, opts)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PROBLEM\tKIND\tPATH\tSIZE\tEXPECTED\tREPAIRED")
+	for _, problem := range report.Problems {
+		repaired := strconv.FormatBool(problem.Repaired)
+		if problem.Error != "" {
+			repaired = problem.Error
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", problem.Type, problem.Kind, problem.Path, problem.Size, problem.ExpectedSize, repaired)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("checked %d objects and %d rows, found %d problems\n", report.Objects, report.Rows, len(report.Problems))
+	if unrepaired := report.Unrepaired(); unrepaired > 0 {
+		return fmt.Errorf("%d problems were not repaired", unrepaired)
+	}
+	return nil
+}
+
+// runInvite implements "godrive invite [-role role]". It creates an invitation for a local account, for example for the first admin.
+func runInvite(cfg godrive.Config, args []string) error {
+	flags := flag.NewFlagSet("invite", flag.ExitOnError)
+	role := flags.String("role", string(godrive.RoleUser), "role of the invited user, one of admin, user and viewer")
+	_ = flags.Parse(args)
+
+	if cfg.Auth == nil || cfg.Auth.Mode != godrive.AuthModeLocal {
+		return errors.New("invitations require the local auth mode")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	db, err := godrive.NewDB(ctx, cfg.Database, migrations())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// the first admin is usually invited before the server ran for the first time
+	if _, err = db.MigrateUp(ctx, 0); err != nil {
+		return err
+	}
+
+	token, invitation, err := godrive.NewInvitation(ctx, db, godrive.Role(*role), "cli")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("invitation with role %s expires at %s, accept it at:\n/invite/%s\n", invitation.Role, invitation.ExpiresAt.Format(time.RFC3339), token)
+	return nil
+}
+
+func runStorage(cfg godrive.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: godrive storage migrate [-from config] -to config [-parallel n]")
+	}
+	switch args[0] {
+	case "migrate":
+		return runStorageMigrate(cfg, args[1:])
+	}
+	return fmt.Errorf("unknown storage command: %s", args[0])
+}
+
+// runStorageMigrate implements "godrive storage migrate [-from config] -to config [-parallel n]".
+// It copies all objects from the storage of one config to the storage of another, the database of the current config is used for both.
+// Once it finished without errors the server can be switched to the new storage, running it again only copies objects changed since.
+func runStorageMigrate(cfg godrive.Config, args []string) error {
+	flags := flag.NewFlagSet("storage migrate", flag.ExitOnError)
+	fromPath := flags.String("from", "", "path to the config of the storage to copy from, defaults to the current config")
+	toPath := flags.String("to", "", "path to the config of the storage to copy to")
+	parallel := flags.Int("parallel", 4, "number of objects copied at the same time")
+	_ = flags.Parse(args)
+
+	if *toPath == "" {
+		return errors.New("missing -to config")
+	}
+	from := cfg.Storage
+	if *fromPath != "" {
+		var err error
+		if from, err = loadStorageConfig(*fromPath); err != nil {
+			return err
+		}
+	}
+	to, err := loadStorageConfig(*toPath)
+	if err != nil {
+		return err
+	}
+	if from.Location() == to.Location() {
+		return errors.New("source and destination storage are the same")
+	}
+	// the database only knows one layout, paths are either stored as blobs or as themselves
+	if from.Deduplicate != to.Deduplicate {
+		return errors.New("source and destination storage must both deduplicate or both not")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	db, err := godrive.NewDB(ctx, cfg.Database, migrations())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tracer := trace.NewNoopTracerProvider().Tracer(Namespace)
+	fromStorage, err := newMigrateStorage(ctx, from, tracer)
+	if err != nil {
+		return fmt.Errorf("error creating source storage: %w", err)
+	}
+	toStorage, err := newMigrateStorage(ctx, to, tracer)
+	if err != nil {
+		return fmt.Errorf("error creating destination storage: %w", err)
+	}
+
+	counts := map[godrive.MigrateStatus]int{}
+	err = godrive.MigrateStorage(ctx, db, fromStorage, toStorage, godrive.MigrateOptions{
+		Target:   to.Location(),
+		Parallel: *parallel,
+	}, func(filePath string, status godrive.MigrateStatus, err error) {
+		counts[status]++
+		if err != nil {
+			fmt.Printf("%s %s: %s\n", status, filePath, err)
+			return
+		}
+		fmt.Printf("%s %s\n", status, filePath)
+	})
+	fmt.Printf("copied %d, skipped %d, missing %d and failed %d objects\n",
+		counts[godrive.MigrateStatusCopied],
+		counts[godrive.MigrateStatusSkipped],
+		counts[godrive.MigrateStatusMissing],
+		counts[godrive.MigrateStatusFailed],
+	)
+	return err
+}
+
+// newMigrateStorage creates the storage of cfg with encryption but without deduplication, so blobs are copied as they are stored.
+func newMigrateStorage(ctx context.Context, cfg godrive.StorageConfig, tracer trace.Tracer) (godrive.Storage, error) {
+	storage, err := godrive.NewStorage(ctx, cfg, tracer)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Encryption != nil {
+		return godrive.NewEncryptStorage(storage, *cfg.Encryption, tracer)
+	}
+	return storage, nil
+}
+
+// loadStorageConfig reads the storage section of another godrive config.
+func loadStorageConfig(cfgPath string) (godrive.StorageConfig, error) {
+	v := viper.New()
+	v.SetConfigFile(cfgPath)
+	if err := v.ReadInConfig(); err != nil {
+		return godrive.StorageConfig{}, fmt.Errorf("error reading config %s: %w", cfgPath, err)
+	}
+	var cfg godrive.Config
+	if err := v.Unmarshal(&cfg, func(config *mapstructure.DecoderConfig) {
+		config.TagName = "cfg"
+	}); err != nil {
+		return godrive.StorageConfig{}, fmt.Errorf("error unmarshalling config %s: %w", cfgPath, err)
+	}
+	return cfg.Storage, nil
+}
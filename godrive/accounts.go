@@ -0,0 +1,355 @@
+package godrive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// invitationLifespan is how long invitations can be accepted.
+	invitationLifespan = 7 * 24 * time.Hour
+	// passwordResetLifespan is how long password reset links can be used.
+	passwordResetLifespan = 24 * time.Hour
+	minPasswordLength     = 8
+)
+
+var (
+	ErrInvalidLogin = errors.New("invalid username or password")
+	ErrWeakPassword = fmt.Errorf("password must be at least %d characters long", minPasswordLength)
+)
+
+// NewInvitation creates an invitation for a local account with the role and returns the token to accept it with.
+func NewInvitation(ctx context.Context, db *DB, role Role, createdBy string) (string, *Invitation, error) {
+	if role.permissions() == nil {
+		return "", nil, errors.New("invalid role, must be one of: admin, user, viewer")
+	}
+	token, err := newSecret()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	invitation := Invitation{
+		Hash:      hashToken(token),
+		Role:      role,
+		CreatedBy: createdBy,
+		ExpiresAt: now.Add(invitationLifespan),
+		CreatedAt: now,
+	}
+	if err = db.CreateInvitation(ctx, invitation); err != nil {
+		return "", nil, err
+	}
+	return token, &invitation, nil
+}
+
+func invitationURL(token string) string {
+	return "/invite/" + token
+}
+
+func passwordResetURL(token string) string {
+	return "/reset/" + token
+}
+
+// LocalAccountRoutes are the pages to log in, accept invitations and reset passwords with local accounts.
+func (s *Server) LocalAccountRoutes(r chi.Router) {
+	r.Post("/login", s.PostLogin)
+	r.Get("/invite/{token}", s.GetInvitation)
+	r.Post("/invite/{token}", s.AcceptInvitation)
+	r.Get("/reset/{token}", s.GetPasswordReset)
+	r.Post("/reset/{token}", s.ResetPassword)
+}
+
+// PostLogin checks the username and password of a local user and starts a session.
+func (s *Server) PostLogin(w http.ResponseWriter, r *http.Request) {
+	vars := AccountVariables{
+		Action:   "login",
+		Username: r.PostFormValue("username"),
+	}
+
+	user, err := s.db.GetUserByName(r.Context(), vars.Username)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if user == nil || user.Password == "" || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(r.PostFormValue("password"))) != nil {
+		vars.Error = ErrInvalidLogin.Error()
+		s.renderAccount(w, r, vars, http.StatusUnauthorized)
+		return
+	}
+	if user.Disabled {
+		vars.Error = ErrUserDisabled.Error()
+		s.renderAccount(w, r, vars, http.StatusForbidden)
+		return
+	}
+
+	if err = s.startLocalSession(r.Context(), w, user.ID); err != nil {
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (s *Server) GetInvitation(w http.ResponseWriter, r *http.Request) {
+	vars := AccountVariables{
+		Action: "invitation",
+		Token:  chi.URLParam(r, "token"),
+	}
+	if _, err := s.db.GetInvitation(r.Context(), hashToken(vars.Token)); err != nil {
+		if errors.Is(err, ErrInvitationInvalid) {
+			s.prettyError(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.renderAccount(w, r, vars, http.StatusOK)
+}
+
+// AcceptInvitation creates the local account of an invitation and logs the new user in.
+func (s *Server) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	vars := AccountVariables{
+		Action:   "invitation",
+		Token:    chi.URLParam(r, "token"),
+		Username: strings.TrimSpace(r.PostFormValue("username")),
+		Email:    strings.TrimSpace(r.PostFormValue("email")),
+	}
+	invitation, err := s.db.GetInvitation(r.Context(), hashToken(vars.Token))
+	if err != nil {
+		if errors.Is(err, ErrInvitationInvalid) {
+			s.prettyError(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if vars.Username == "" || vars.Username == "guest" || strings.Contains(vars.Username, "/") {
+		vars.Error = "invalid username"
+		s.renderAccount(w, r, vars, http.StatusBadRequest)
+		return
+	}
+	hash, ok := s.hashPassword(w, r, vars)
+	if !ok {
+		return
+	}
+
+	user := User{
+		ID:       s.newID(16),
+		Username: vars.Username,
+		Email:    vars.Email,
+		Home:     s.defaultHome(vars.Username),
+		Role:     &invitation.Role,
+		Password: hash,
+	}
+	if err = s.db.AcceptInvitation(r.Context(), invitation.Hash, user); err != nil {
+		if errors.Is(err, ErrUserAlreadyExists) {
+			vars.Error = ErrUserAlreadyExists.Error()
+			s.renderAccount(w, r, vars, http.StatusConflict)
+			return
+		}
+		if errors.Is(err, ErrInvitationInvalid) {
+			s.prettyError(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.provisionHome(r.Context(), user.ID, user.Home); err != nil {
+		slog.ErrorCtx(r.Context(), "failed to provision home", slog.String("user_id", user.ID), slog.Any("err", err))
+	}
+	s.audit(r.Context(), toUserInfo(user), AuditActionUserCreated, user.Username, "role: "+string(invitation.Role))
+
+	if err = s.startLocalSession(r.Context(), w, user.ID); err != nil {
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (s *Server) GetPasswordReset(w http.ResponseWriter, r *http.Request) {
+	vars := AccountVariables{
+		Action: "reset",
+		Token:  chi.URLParam(r, "token"),
+	}
+	if _, err := s.db.GetPasswordReset(r.Context(), hashToken(vars.Token)); err != nil {
+		if errors.Is(err, ErrResetInvalid) {
+			s.prettyError(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.renderAccount(w, r, vars, http.StatusOK)
+}
+
+// ResetPassword sets the new password of a local user and ends all their sessions.
+func (s *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	vars := AccountVariables{
+		Action: "reset",
+		Token:  chi.URLParam(r, "token"),
+	}
+	reset, err := s.db.GetPasswordReset(r.Context(), hashToken(vars.Token))
+	if err != nil {
+		if errors.Is(err, ErrResetInvalid) {
+			s.prettyError(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	hash, ok := s.hashPassword(w, r, vars)
+	if !ok {
+		return
+	}
+	if err = s.db.ResetPassword(r.Context(), *reset, hash); err != nil {
+		if errors.Is(err, ErrResetInvalid) || errors.Is(err, ErrUserNotFound) {
+			s.prettyError(w, r, ErrResetInvalid, http.StatusNotFound)
+			return
+		}
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.auth.Sessions.DeleteUserSessions(r.Context(), reset.UserID); err != nil {
+		slog.ErrorCtx(r.Context(), "failed to delete user sessions", slog.String("user_id", reset.UserID), slog.Any("err", err))
+	}
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// hashPassword hashes the password of the form and renders the form again if the password is too weak.
+func (s *Server) hashPassword(w http.ResponseWriter, r *http.Request, vars AccountVariables) (string, bool) {
+	password := r.PostFormValue("password")
+	if len(password) < minPasswordLength {
+		vars.Error = ErrWeakPassword.Error()
+		s.renderAccount(w, r, vars, http.StatusBadRequest)
+		return "", false
+	}
+	if password != r.PostFormValue("password_confirm") {
+		vars.Error = "passwords do not match"
+		s.renderAccount(w, r, vars, http.StatusBadRequest)
+		return "", false
+	}
+	// bcrypt only uses the first 72 bytes
+	if len(password) > 72 {
+		vars.Error = "password must be at most 72 bytes long"
+		s.renderAccount(w, r, vars, http.StatusBadRequest)
+		return "", false
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return "", false
+	}
+	return string(hash), true
+}
+
+// startLocalSession logs in a local user. Local sessions last as long as sessions of OIDC users without refresh.
+func (s *Server) startLocalSession(ctx context.Context, w http.ResponseWriter, userID string) error {
+	sessionID, err := newSecret()
+	if err != nil {
+		return err
+	}
+	return s.setSession(ctx, w, Session{
+		ID:        sessionID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(s.sessionLifespan()),
+	})
+}
+
+// localUserInfo returns the user of a local session. It returns nil if the user was deleted or disabled.
+func (s *Server) localUserInfo(ctx context.Context, session Session) (*UserInfo, error) {
+	user, err := s.db.GetUser(ctx, session.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if user.Disabled || user.Password == "" {
+		return nil, nil
+	}
+	return toUserInfo(*user), nil
+}
+
+func (s *Server) renderAccount(w http.ResponseWriter, r *http.Request, vars AccountVariables, status int) {
+	vars.BaseVariables = BaseVariables{
+		Theme: "dark",
+		Auth:  true,
+		User:  s.ToTemplateUser(GetUserInfo(r)),
+	}
+	w.WriteHeader(status)
+	if err := s.tmpl(w, "account.gohtml", vars); err != nil {
+		slog.ErrorCtx(r.Context(), "error executing template", slog.Any("err", err))
+	}
+}
+
+func (s *Server) InvitationRoutes(r chi.Router) {
+	r.Post("/", s.CreateInvitation)
+}
+
+// CreateInvitation lets admins invite someone to create a local account with the role in the request.
+func (s *Server) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	var invitationRq InvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&invitationRq); err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	userInfo := GetUserInfo(r)
+	token, invitation, err := NewInvitation(r.Context(), s.db, invitationRq.Role, userInfo.Subject)
+	if err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	s.audit(r.Context(), userInfo, AuditActionUserInvited, "", "role: "+string(invitation.Role))
+
+	s.json(w, r, AccountLinkResponse{
+		URL:       invitationURL(token),
+		ExpiresAt: invitation.ExpiresAt,
+	}, http.StatusCreated)
+}
+
+// CreatePasswordReset lets admins create a link for a local user to set a new password.
+func (s *Server) CreatePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getUser(w, r)
+	if !ok {
+		return
+	}
+	if user.Password == "" {
+		s.error(w, r, errors.New("the user has no local account"), http.StatusBadRequest)
+		return
+	}
+
+	token, err := newSecret()
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	userInfo := GetUserInfo(r)
+	now := time.Now()
+	reset := PasswordReset{
+		Hash:      hashToken(token),
+		UserID:    user.ID,
+		CreatedBy: userInfo.Subject,
+		ExpiresAt: now.Add(passwordResetLifespan),
+		CreatedAt: now,
+	}
+	if err = s.db.CreatePasswordReset(r.Context(), reset); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.audit(r.Context(), userInfo, AuditActionPasswordReset, user.Username, "")
+
+	s.json(w, r, AccountLinkResponse{
+		URL:       passwordResetURL(token),
+		ExpiresAt: reset.ExpiresAt,
+	}, http.StatusCreated)
+}
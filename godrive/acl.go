@@ -0,0 +1,389 @@
+package godrive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/exp/slices"
+)
+
+var ErrAccessDenied = errors.New("access denied")
+
+func (p ACLPermission) level() int {
+	switch p {
+	case ACLPermissionRead:
+		return 1
+	case ACLPermissionWrite:
+		return 2
+	case ACLPermissionManage:
+		return 3
+	}
+	return 0
+}
+
+// access decides what a user can do with paths based on the ACL entries matching the user or one of their groups.
+// Entries apply to their path and everything below it, the closest path with an entry deciding the permission wins.
+// On the same path entries of the user win over entries of their groups, and deny entries win over allow entries.
+// A deny entry denies its permission and all higher permissions.
+//
+// Without a deciding entry everyone can read and upload, only owners can modify their files and only admins can manage ACLs.
+// If homes are confined, users can only do so in their home folder.
+// ACL entries never grant more than the permissions of the user, except that write allows modifying files of other users.
+// Admins are never restricted.
+type access struct {
+	userID        string
+	home          string
+	permissions   permissionSet
+	acls          map[string][]ACL
+	confineWrites bool
+	confineReads  bool
+}
+
+// getAccess loads the ACL entries which apply to the user. Create it once per request and reuse it for all files of the request.
+func (s *Server) getAccess(ctx context.Context, info *UserInfo) (*access, error) {
+	a := &access{
+		userID:      info.Subject,
+		home:        info.Home,
+		permissions: s.permissions(info),
+	}
+	// without authentication there are no users to grant permissions to
+	if a.permissions.has(PermissionAdmin) || s.cfg.Auth == nil {
+		return a, nil
+	}
+	a.confineReads = s.cfg.Auth.Home.ConfineReads
+	a.confineWrites = s.cfg.Auth.Home.Confine || a.confineReads
+
+	acls, err := s.db.GetACLs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a.acls = make(map[string][]ACL)
+	for _, acl := range acls {
+		if (acl.SubjectType == ACLSubjectUser && acl.Subject == info.Subject) || (acl.SubjectType == ACLSubjectGroup && slices.Contains(info.Groups, acl.Subject)) {
+			a.acls[acl.Path] = append(a.acls[acl.Path], acl)
+		}
+	}
+
+	// users created before homes were provisioned get the entry of their home folder on their next request
+	if a.home != "" && !slices.ContainsFunc(a.acls[a.home], func(acl ACL) bool { return acl.SubjectType == ACLSubjectUser }) {
+		acl := homeACL(info.Subject, a.home)
+		if err = s.db.SetACL(ctx, acl); err != nil {
+			return nil, err
+		}
+		a.acls[acl.Path] = append(a.acls[acl.Path], acl)
+	}
+	return a, nil
+}
+
+// getUserAccess is getAccess for a user who does not make the request, like the owner of a share.
+func (s *Server) getUserAccess(ctx context.Context, userID string) (*access, error) {
+	info := &UserInfo{
+		UserInfo: oidc.UserInfo{Subject: userID},
+	}
+	if s.cfg.Auth != nil {
+		user, err := s.db.GetUser(ctx, userID)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+		// disabled users have no permissions, so their shares stop working
+		if user != nil && !user.Disabled {
+			info.Username = user.Username
+			info.Groups = strings.Split(user.Groups, ",")
+			info.Home = user.Home
+			info.Role = user.Role
+		}
+	}
+	return s.getAccess(ctx, info)
+}
+
+// getRequestAccess is getAccess for the user of the request and writes the error response if the ACL entries can not be loaded.
+func (s *Server) getRequestAccess(w http.ResponseWriter, r *http.Request) (*access, bool) {
+	a, err := s.getAccess(r.Context(), GetUserInfo(r))
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return a, true
+}
+
+// decide walks from filePath up to the root and returns whether the permission is allowed by the closest deciding entry.
+// decided is false if no entry decides the permission.
+func (a *access) decide(filePath string, permission ACLPermission) (allowed bool, decided bool) {
+	for p := path.Clean(filePath); ; p = path.Dir(p) {
+		for _, subjectType := range []ACLSubjectType{ACLSubjectUser, ACLSubjectGroup} {
+			if allowed, decided = decideEntries(a.acls[p], subjectType, permission); decided {
+				return allowed, true
+			}
+		}
+		if p == "/" || p == "." {
+			return false, false
+		}
+	}
+}
+
+func decideEntries(acls []ACL, subjectType ACLSubjectType, permission ACLPermission) (allowed bool, decided bool) {
+	for _, acl := range acls {
+		if acl.SubjectType != subjectType {
+			continue
+		}
+		if acl.Deny && acl.Permission.level() <= permission.level() {
+			return false, true
+		}
+		if !acl.Deny && acl.Permission.level() >= permission.level() {
+			allowed = true
+		}
+	}
+	return allowed, allowed
+}
+
+func (a *access) canRead(filePath string) bool {
+	if a.permissions.has(PermissionAdmin) {
+		return true
+	}
+	if !a.permissions.has(PermissionRead) {
+		return false
+	}
+	if allowed, decided := a.decide(filePath, ACLPermissionRead); decided {
+		return allowed
+	}
+	return !a.confineReads || inHome(a.home, filePath)
+}
+
+// canUpload reports whether new files can be created at filePath.
+func (a *access) canUpload(filePath string) bool {
+	if a.permissions.has(PermissionAdmin) {
+		return true
+	}
+	if !a.permissions.has(PermissionUpload) {
+		return false
+	}
+	if allowed, decided := a.decide(filePath, ACLPermissionWrite); decided {
+		return allowed
+	}
+	return !a.confineWrites || inHome(a.home, filePath)
+}
+
+// canModify reports whether the file can be changed, moved or deleted. Write permission allows modifying files of other users.
+func (a *access) canModify(file File) bool {
+	if a.permissions.has(PermissionAdmin) {
+		return true
+	}
+	if !a.permissions.has(PermissionModifyOwn) {
+		return false
+	}
+	if allowed, decided := a.decide(file.Path, ACLPermissionWrite); decided {
+		return allowed
+	}
+	if a.confineWrites && !inHome(a.home, file.Path) {
+		return false
+	}
+	return file.UserID == a.userID || a.permissions.has(PermissionModifyAny)
+}
+
+// canManage reports whether the ACL entries of dir can be changed.
+func (a *access) canManage(dir string) bool {
+	if a.permissions.has(PermissionAdmin) {
+		return true
+	}
+	allowed, _ := a.decide(dir, ACLPermissionManage)
+	return allowed
+}
+
+// readable returns the files the user can read.
+func (a *access) readable(files []File) []File {
+	if a.permissions.has(PermissionAdmin) {
+		return files
+	}
+	filtered := make([]File, 0, len(files))
+	for _, file := range files {
+		if a.canRead(file.Path) {
+			filtered = append(filtered, file)
+		}
+	}
+	return filtered
+}
+
+// ACLRoutes lets folder managers view and change the ACL entries of a folder.
+func (s *Server) ACLRoutes(r chi.Router) {
+	r.Get("/", s.GetACLs)
+	r.Put("/", s.PutACL)
+	r.Delete("/", s.DeleteACL)
+	r.Get("/*", s.GetACLs)
+	r.Put("/*", s.PutACL)
+	r.Delete("/*", s.DeleteACL)
+}
+
+// GetACLs lists the entries of the folder and the entries it inherits from its parents.
+func (s *Server) GetACLs(w http.ResponseWriter, r *http.Request) {
+	aclPath, ok := s.getManagedPath(w, r)
+	if !ok {
+		return
+	}
+
+	acls, err := s.db.GetACLs(r.Context())
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]ACLResponse, 0)
+	for _, acl := range acls {
+		if acl.Path != aclPath && !isParentPath(acl.Path, aclPath) {
+			continue
+		}
+		response = append(response, toACLResponse(acl, acl.Path != aclPath))
+	}
+	s.ok(w, r, response)
+}
+
+// PutACL creates or replaces the entry of a user or group on the folder.
+func (s *Server) PutACL(w http.ResponseWriter, r *http.Request) {
+	aclPath, ok := s.getManagedPath(w, r)
+	if !ok {
+		return
+	}
+
+	var aclRq ACLRequest
+	if err := json.NewDecoder(r.Body).Decode(&aclRq); err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if aclRq.Permission.level() == 0 {
+		s.error(w, r, errors.New("invalid permission, must be one of: read, write, manage"), http.StatusBadRequest)
+		return
+	}
+	subject, ok := s.getACLSubject(w, r, aclRq.SubjectType, aclRq.Subject)
+	if !ok {
+		return
+	}
+
+	if err := s.db.SetACL(r.Context(), ACL{
+		Path:        aclPath,
+		SubjectType: aclRq.SubjectType,
+		Subject:     subject,
+		Permission:  aclRq.Permission,
+		Deny:        aclRq.Deny,
+		CreatedBy:   GetUserInfo(r).Subject,
+	}); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteACL removes the entry of the user or group in the subject_type and subject query parameters from the folder.
+func (s *Server) DeleteACL(w http.ResponseWriter, r *http.Request) {
+	aclPath, ok := s.getManagedPath(w, r)
+	if !ok {
+		return
+	}
+
+	subjectType := ACLSubjectType(r.URL.Query().Get("subject_type"))
+	subject, ok := s.getACLSubject(w, r, subjectType, r.URL.Query().Get("subject"))
+	if !ok {
+		return
+	}
+
+	if err := s.db.DeleteACL(r.Context(), aclPath, subjectType, subject); err != nil {
+		if errors.Is(err, ErrACLNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getManagedPath returns the folder of the request and writes the error response if the user can not manage its ACL entries.
+func (s *Server) getManagedPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if s.cfg.Auth == nil {
+		s.error(w, r, errors.New("access control lists require authentication"), http.StatusNotFound)
+		return "", false
+	}
+	aclPath := path.Join("/", chi.URLParam(r, "*"))
+	if isInternalPath(aclPath) {
+		s.error(w, r, ErrReservedPath, http.StatusBadRequest)
+		return "", false
+	}
+
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return "", false
+	}
+	if !a.canManage(aclPath) {
+		s.error(w, r, fmt.Errorf("%w: you can not manage the access of %s", ErrAccessDenied, aclPath), http.StatusForbidden)
+		return "", false
+	}
+	return aclPath, true
+}
+
+// getACLSubject resolves usernames to user ids, groups are stored by name.
+func (s *Server) getACLSubject(w http.ResponseWriter, r *http.Request, subjectType ACLSubjectType, subject string) (string, bool) {
+	if subject == "" {
+		s.error(w, r, errors.New("missing subject"), http.StatusBadRequest)
+		return "", false
+	}
+	switch subjectType {
+	case ACLSubjectGroup:
+		return subject, true
+	case ACLSubjectUser:
+		user, err := s.db.GetUserByName(r.Context(), subject)
+		if errors.Is(err, ErrUserNotFound) {
+			// entries of unknown users are listed by their id
+			user, err = s.db.GetUser(r.Context(), subject)
+		}
+		if err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				s.error(w, r, err, http.StatusNotFound)
+				return "", false
+			}
+			s.error(w, r, err, http.StatusInternalServerError)
+			return "", false
+		}
+		return user.ID, true
+	}
+	s.error(w, r, errors.New("invalid subject type, must be one of: user, group"), http.StatusBadRequest)
+	return "", false
+}
+
+func toACLResponse(acl ACL, inherited bool) ACLResponse {
+	subject := acl.Subject
+	if acl.Username != nil {
+		subject = *acl.Username
+	}
+	return ACLResponse{
+		Path:        acl.Path,
+		SubjectType: acl.SubjectType,
+		Subject:     subject,
+		Permission:  acl.Permission,
+		Deny:        acl.Deny,
+		Inherited:   inherited,
+		CreatedAt:   acl.CreatedAt,
+	}
+}
+
+// isParentPath reports whether dir is a parent folder of filePath.
+func isParentPath(dir string, filePath string) bool {
+	if dir == "/" {
+		return filePath != "/"
+	}
+	return strings.HasPrefix(filePath, dir+"/")
+}
+
+func uploadDenied(filePath string) error {
+	return fmt.Errorf("%w: you can not upload to %s", ErrAccessDenied, path.Dir(filePath))
+}
+
+func modifyDenied(filePath string) error {
+	return fmt.Errorf("%w: you can not change %s", ErrAccessDenied, filePath)
+}
@@ -0,0 +1,163 @@
+package godrive
+
+import (
+	_ "embed"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// APIRoutes serves the versioned JSON API. File changes are handled by the same handlers as the UI with the /api/v1/files prefix stripped.
+func (s *Server) APIRoutes(r chi.Router) {
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		s.error(w, r, errors.New("not found"), http.StatusNotFound)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		s.error(w, r, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+	})
+	r.With(s.RequirePermission(PermissionUpload)).Route("/uploads", s.PresignRoutes)
+	r.With(s.RequirePermission(PermissionRead)).Route("/acl", s.ACLRoutes)
+	r.Route("/files", func(r chi.Router) {
+		read := r.With(s.RequirePermission(PermissionRead))
+		upload := r.With(s.RequirePermission(PermissionUpload))
+		modify := r.With(s.RequirePermission(PermissionModifyOwn))
+		read.Get("/", s.APIGetFiles)
+		read.Head("/", s.APIGetFiles)
+		upload.Post("/", apiFiles(s.PostFile))
+		modify.Put("/", apiFiles(s.MoveFiles))
+		upload.Method("COPY", "/", apiFiles(s.CopyFiles))
+		modify.Delete("/", apiFiles(s.DeleteFiles))
+		read.Get("/*", s.APIGetFiles)
+		read.Head("/*", s.APIGetFiles)
+		upload.Post("/*", apiFiles(s.PostFile))
+		modify.Patch("/*", apiFiles(s.PatchFile))
+		modify.Put("/*", apiFiles(s.MoveFiles))
+		upload.Method("COPY", "/*", apiFiles(s.CopyFiles))
+		modify.Delete("/*", apiFiles(s.DeleteFiles))
+	})
+}
+
+func (s *Server) GetOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPI)
+}
+
+// APIGetFiles returns the metadata of a file or the listing of a folder. With the dl query parameter the file or a zip of the folder is downloaded instead.
+// The recursive query parameter lists all files below the folder instead of its direct children.
+func (s *Server) APIGetFiles(w http.ResponseWriter, r *http.Request) {
+	filePath := "/" + chi.URLParam(r, "*")
+	download, filesFilter := parseDownload(r)
+
+	files, err := s.db.FindFiles(r.Context(), filePath)
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	files = a.readable(files)
+	if len(files) == 0 && (download || (filePath != "/" && filePath != a.home)) {
+		s.error(w, r, ErrFileNotFound, http.StatusNotFound)
+		return
+	}
+
+	if len(files) == 1 && files[0].Path == filePath {
+		if download {
+			s.serveFile(w, r, files[0], true)
+			return
+		}
+		s.ok(w, r, toFileResponse(files[0], a))
+		return
+	}
+
+	if download {
+		s.serveZip(w, r, shareName(filePath), files, filePath, path.Dir(filePath), filesFilter)
+		return
+	}
+
+	var response []FileResponse
+	if recursive := r.URL.Query().Get("recursive"); recursive == "1" || strings.ToLower(recursive) == "true" {
+		response = make([]FileResponse, len(files))
+		for i, file := range files {
+			response[i] = toFileResponse(file, a)
+		}
+	} else {
+		response = toFileResponses(files, filePath, a)
+	}
+	s.ok(w, r, FileListResponse{
+		Path:  filePath,
+		Files: response,
+	})
+}
+
+// apiFiles rewrites the request path to the file path below /api/v1/files.
+func apiFiles(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		u.Path = "/" + chi.URLParam(r, "*")
+		u.RawPath = ""
+		r2 := r.WithContext(r.Context())
+		r2.URL = &u
+		handler(w, r2)
+	}
+}
+
+// toFileResponses lists the direct children of dir with synthesized folders.
+func toFileResponses(files []File, dir string, a *access) []FileResponse {
+	byPath := make(map[string]File, len(files))
+	for _, file := range files {
+		byPath[file.Path] = file
+	}
+
+	templateFiles := toTemplateFiles(files, dir, a.canModify)
+	response := make([]FileResponse, len(templateFiles))
+	for i, file := range templateFiles {
+		if !file.IsDir {
+			response[i] = toFileResponse(byPath[file.Path], a)
+			continue
+		}
+		response[i] = FileResponse{
+			Path:      file.Path,
+			Dir:       file.Dir,
+			Name:      file.Name,
+			IsDir:     true,
+			Size:      file.Size,
+			Owner:     file.Owner,
+			IsOwner:   file.IsOwner,
+			UpdatedAt: file.Date,
+		}
+	}
+	return response
+}
+
+func toFileResponse(file File, a *access) FileResponse {
+	owner := "Unknown"
+	if file.Username != nil {
+		owner = *file.Username
+	}
+	updatedAt := file.CreatedAt
+	if file.UpdatedAt.After(updatedAt) {
+		updatedAt = file.UpdatedAt
+	}
+	createdAt := file.CreatedAt
+	return FileResponse{
+		Path:        file.Path,
+		Dir:         path.Dir(file.Path),
+		Name:        path.Base(file.Path),
+		Size:        file.Size,
+		ContentType: file.ContentType,
+		Description: file.Description,
+		Owner:       owner,
+		IsOwner:     a.canModify(file),
+		CreatedAt:   &createdAt,
+		UpdatedAt:   updatedAt,
+	}
+}
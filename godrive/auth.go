@@ -5,15 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"path"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
 	"golang.org/x/oauth2"
@@ -23,24 +22,14 @@ type authKey struct{}
 
 var UserInfoKey = authKey{}
 
+var ErrUserDisabled = errors.New("account disabled")
+
 type Auth struct {
 	Verifier *oidc.IDTokenVerifier
 	Config   *oauth2.Config
 	Provider *oidc.Provider
 
-	// session id <-> id token
-	Sessions   map[string]*Session
-	SessionsMu sync.Mutex
-	// state <-> nonce
-	States   map[string]string
-	StatesMu sync.Mutex
-}
-
-type Session struct {
-	AccessToken  string
-	Expiry       time.Time
-	RefreshToken string
-	IDToken      string
+	Sessions SessionStore
 }
 
 type UserInfo struct {
@@ -49,42 +38,56 @@ type UserInfo struct {
 	Audience []string `json:"aud"`
 	Groups   []string `json:"groups"`
 	Username string   `json:"preferred_username"`
+	// Role overrides the permissions of the groups, see User.Role
+	Role *Role `json:"-"`
+	// Scope limits the permissions of requests authenticated with a personal access token
+	Scope TokenScope `json:"-"`
 }
 
-func (s *Server) ToTemplateUser(info *UserInfo) TemplateUser {
-	return TemplateUser{
-		ID:      info.Subject,
-		Name:    info.Username,
-		Email:   info.Email,
-		Home:    info.Home,
-		IsAdmin: s.isAdmin(info),
-		IsUser:  s.isUser(info),
-		IsGuest: s.isGuest(info),
+// toUserInfo returns the UserInfo of users who are not authenticated with the identity provider.
+func toUserInfo(user User) *UserInfo {
+	var groups []string
+	if user.Groups != "" {
+		groups = strings.Split(user.Groups, ",")
+	}
+	return &UserInfo{
+		UserInfo: oidc.UserInfo{
+			Subject: user.ID,
+			Email:   user.Email,
+		},
+		Home:     user.Home,
+		Audience: []string{"godrive"},
+		Groups:   groups,
+		Username: user.Username,
+		Role:     user.Role,
 	}
 }
 
-func (s *Server) hasFileAccess(info *UserInfo, file File) bool {
-	return info.Subject == file.UserID || s.isAdmin(info)
+func (s *Server) ToTemplateUser(info *UserInfo) TemplateUser {
+	return TemplateUser{
+		ID:        info.Subject,
+		Name:      info.Username,
+		Email:     info.Email,
+		Home:      info.Home,
+		IsAdmin:   s.isAdmin(info),
+		IsGuest:   s.isGuest(info),
+		CanUpload: s.hasPermission(info, PermissionUpload),
+		CanModify: s.hasPermission(info, PermissionModifyOwn),
+	}
 }
 
+// hasAccess reports whether the user has any permission. Admins using a token without admin scope keep their other permissions.
 func (s *Server) hasAccess(info *UserInfo) bool {
-	if !s.cfg.Auth.Groups.Guest && s.isGuest(info) {
-		return false
+	for _, ok := range s.permissions(info) {
+		if ok {
+			return true
+		}
 	}
-
-	return s.isAdmin(info) || s.isUser(info) || s.isViewer(info) || s.isGuest(info)
+	return false
 }
 
 func (s *Server) isAdmin(info *UserInfo) bool {
-	return slices.Contains(info.Groups, s.cfg.Auth.Groups.Admin)
-}
-
-func (s *Server) isUser(info *UserInfo) bool {
-	return slices.Contains(info.Groups, s.cfg.Auth.Groups.User)
-}
-
-func (s *Server) isViewer(info *UserInfo) bool {
-	return slices.Contains(info.Groups, s.cfg.Auth.Groups.Viewer)
+	return s.hasPermission(info, PermissionAdmin)
 }
 
 func (s *Server) isGuest(info *UserInfo) bool {
@@ -93,22 +96,24 @@ func (s *Server) isGuest(info *UserInfo) bool {
 
 const SessionCookieName = "X-Session-ID"
 
-func (s *Server) setSession(w http.ResponseWriter, sessionID string, session *Session) {
+func (s *Server) setSession(ctx context.Context, w http.ResponseWriter, session Session) error {
+	if err := s.auth.Sessions.SetSession(ctx, session); err != nil {
+		return err
+	}
+
 	http.SetCookie(w, &http.Cookie{
 		Name:     SessionCookieName,
-		Value:    sessionID,
+		Value:    session.ID,
 		Path:     "/",
+		Expires:  session.ExpiresAt,
 		Secure:   s.cfg.Auth.Secure,
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 	})
-
-	s.auth.SessionsMu.Lock()
-	defer s.auth.SessionsMu.Unlock()
-	s.auth.Sessions[sessionID] = session
+	return nil
 }
 
-func (s *Server) removeSession(w http.ResponseWriter, sessionID string) {
+func (s *Server) removeSession(ctx context.Context, w http.ResponseWriter, sessionID string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     SessionCookieName,
 		Path:     "/",
@@ -118,15 +123,67 @@ func (s *Server) removeSession(w http.ResponseWriter, sessionID string) {
 		SameSite: http.SameSiteLaxMode,
 	})
 
-	s.auth.SessionsMu.Lock()
-	defer s.auth.SessionsMu.Unlock()
-	delete(s.auth.Sessions, sessionID)
+	if err := s.auth.Sessions.DeleteSession(ctx, sessionID); err != nil {
+		slog.ErrorCtx(ctx, "failed to delete session", slog.Any("err", err))
+	}
+}
+
+// sessionLifespan is how long a session is kept after its tokens were last refreshed. It matches the lifespan of the refresh token.
+func (s *Server) sessionLifespan() time.Duration {
+	if s.cfg.Auth.RefreshTokenLifespan > 0 {
+		return s.cfg.Auth.RefreshTokenLifespan
+	}
+	return defaultSessionLifespan
 }
 
 func (s *Server) Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx, span := s.tracer.Start(r.Context(), "auth middleware")
 
+		var (
+			credentialInfo *UserInfo
+			err            error
+			authenticated  bool
+		)
+		if rawToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			credentialInfo, err = s.authenticateToken(ctx, rawToken)
+			authenticated = true
+		} else if username, password, ok := r.BasicAuth(); ok && s.isWebDAVRequest(r) {
+			credentialInfo, err = s.authenticateBasic(ctx, username, password)
+			authenticated = true
+		}
+		if authenticated {
+			if err != nil {
+				span.RecordError(err)
+				span.End()
+				if errors.Is(err, ErrTokenNotFound) || errors.Is(err, ErrTokenExpired) {
+					s.unauthorized(w, r, ErrInvalidToken)
+					return
+				}
+				if errors.Is(err, ErrInvalidLogin) {
+					s.unauthorized(w, r, err)
+					return
+				}
+				if errors.Is(err, ErrUserDisabled) {
+					s.error(w, r, err, http.StatusForbidden)
+					return
+				}
+				s.error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+			span.AddEvent("credentials verified", trace.WithAttributes(
+				attribute.String("subject", credentialInfo.Subject),
+				attribute.String("scope", string(credentialInfo.Scope)),
+			))
+			span.End()
+			if credentialInfo.Scope == TokenScopeRead && !isReadOnlyMethod(r.Method) {
+				s.error(w, r, errors.New("token scope does not allow changes"), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, UserInfoKey, credentialInfo)))
+			return
+		}
+
 		var sessionID string
 		if cookie, err := r.Cookie(SessionCookieName); err == nil {
 			sessionID = cookie.Value
@@ -139,15 +196,18 @@ func (s *Server) Auth(next http.Handler) http.Handler {
 			return
 		}
 
-		span.AddEvent("locking sessions map")
-		s.auth.SessionsMu.Lock()
-		span.AddEvent("locked sessions map")
-		session, ok := s.auth.Sessions[sessionID]
-		s.auth.SessionsMu.Unlock()
-		if !ok {
+		session, err := s.auth.Sessions.GetSession(ctx, sessionID)
+		if err != nil {
+			if !errors.Is(err, ErrSessionNotFound) {
+				span.RecordError(err)
+				slog.Error("failed to get session", slog.Any("err", err))
+				span.End()
+				s.error(w, r, err, http.StatusInternalServerError)
+				return
+			}
 			span.AddEvent("session not found", trace.WithAttributes(attribute.String("sessionID", sessionID)))
 			slog.Debug("session not found", slog.Any("sessionID", sessionID))
-			s.removeSession(w, sessionID)
+			s.removeSession(ctx, w, sessionID)
 			span.End()
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
@@ -160,6 +220,27 @@ func (s *Server) Auth(next http.Handler) http.Handler {
 			attribute.String("idToken", session.IDToken),
 		))
 
+		// local sessions have no tokens to refresh, the user is loaded on every request so changes apply immediately
+		if s.cfg.Auth.Mode == AuthModeLocal {
+			info, err := s.localUserInfo(ctx, *session)
+			if err != nil {
+				span.RecordError(err)
+				span.End()
+				s.error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+			if info == nil {
+				span.AddEvent("user deleted or disabled", trace.WithAttributes(attribute.String("userID", session.UserID)))
+				s.removeSession(ctx, w, sessionID)
+				span.End()
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			span.End()
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, UserInfoKey, info)))
+			return
+		}
+
 		tokenSource := s.auth.Config.TokenSource(ctx, &oauth2.Token{
 			AccessToken:  session.AccessToken,
 			TokenType:    "bearer",
@@ -171,7 +252,7 @@ func (s *Server) Auth(next http.Handler) http.Handler {
 		if err != nil {
 			span.RecordError(err)
 			slog.Error("failed to get token", slog.Any("err", err))
-			s.removeSession(w, sessionID)
+			s.removeSession(ctx, w, sessionID)
 			span.End()
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
@@ -188,13 +269,19 @@ func (s *Server) Auth(next http.Handler) http.Handler {
 				attribute.String("refreshToken", session.RefreshToken),
 				attribute.String("idToken", session.IDToken),
 			))
+			// the refresh token was renewed, so the session lives on
+			session.ExpiresAt = time.Now().Add(s.sessionLifespan())
+			if err = s.setSession(ctx, w, *session); err != nil {
+				span.RecordError(err)
+				slog.Error("failed to update session", slog.Any("err", err))
+			}
 		}
 
 		idToken, err := s.auth.Verifier.Verify(ctx, session.IDToken)
 		if err != nil {
 			span.RecordError(err)
 			slog.Error("failed to verify ID Token: %w", slog.Any("err", err), slog.Any("rawIDToken", session.IDToken))
-			s.removeSession(w, sessionID)
+			s.removeSession(ctx, w, sessionID)
 			span.End()
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
@@ -205,7 +292,7 @@ func (s *Server) Auth(next http.Handler) http.Handler {
 		if err = idToken.Claims(&info); err != nil {
 			span.RecordError(err)
 			slog.Error("failed to parse claims: %w", slog.Any("err", err))
-			s.removeSession(w, sessionID)
+			s.removeSession(ctx, w, sessionID)
 			span.End()
 			s.error(w, r, err, http.StatusInternalServerError)
 			return
@@ -221,26 +308,82 @@ func (s *Server) Auth(next http.Handler) http.Handler {
 		))
 
 		user, err := s.db.GetUserByName(ctx, info.Username)
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
 			span.RecordError(err)
 			slog.Error("failed to get user by name: %w", slog.Any("err", err))
 			span.End()
 			s.error(w, r, err, http.StatusInternalServerError)
 			return
 		}
+		// deleted and disabled users are logged out
+		if user == nil || user.Disabled {
+			span.AddEvent("user deleted or disabled", trace.WithAttributes(attribute.String("username", info.Username)))
+			s.removeSession(ctx, w, sessionID)
+			span.End()
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
 		info.Home = user.Home
+		info.Role = user.Role
 
 		span.End()
 		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, UserInfoKey, &info)))
 	})
 }
 
+// authenticateBasic authenticates WebDAV clients which only support basic auth, like file managers mounting godrive as a network drive.
+// The password is a personal access token of the user or, with local accounts, their password.
+func (s *Server) authenticateBasic(ctx context.Context, username string, password string) (*UserInfo, error) {
+	if strings.HasPrefix(password, TokenPrefix) {
+		info, err := s.authenticateToken(ctx, password)
+		if err != nil {
+			return nil, err
+		}
+		if info.Username != username {
+			return nil, ErrInvalidLogin
+		}
+		return info, nil
+	}
+	if s.cfg.Auth.Mode != AuthModeLocal {
+		return nil, ErrInvalidLogin
+	}
+
+	user, err := s.db.GetUserByName(ctx, username)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidLogin
+		}
+		return nil, err
+	}
+	if user.Password == "" || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return nil, ErrInvalidLogin
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+	return toUserInfo(*user), nil
+}
+
+func (s *Server) isWebDAVRequest(r *http.Request) bool {
+	return s.cfg.WebDAV != nil && (r.URL.Path == s.cfg.WebDAV.Prefix || strings.HasPrefix(r.URL.Path, strings.TrimSuffix(s.cfg.WebDAV.Prefix, "/")+"/"))
+}
+
+// unauthorized asks WebDAV clients for basic auth credentials, browsers and API clients are expected to log in or send a token.
+func (s *Server) unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	if s.isWebDAVRequest(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="godrive", charset="UTF-8"`)
+	}
+	s.error(w, r, err, http.StatusUnauthorized)
+}
+
 type AuthAction string
 
 const (
 	AuthActionDeny  AuthAction = "deny"
 	AuthActionAllow AuthAction = "allow"
 	AuthActionLogin AuthAction = "login"
+	// AuthActionUnauthorized asks for credentials, WebDAV clients only send them after being asked
+	AuthActionUnauthorized AuthAction = "unauthorized"
 )
 
 func (s *Server) CheckAuth(allowedFunc func(r *http.Request, info *UserInfo) AuthAction) func(next http.Handler) http.Handler {
@@ -253,14 +396,28 @@ func (s *Server) CheckAuth(allowedFunc func(r *http.Request, info *UserInfo) Aut
 
 			case AuthActionLogin:
 				http.Redirect(w, r, "/login", http.StatusFound)
+				return
+
+			case AuthActionUnauthorized:
+				s.unauthorized(w, r, errors.New("not authenticated"))
+				return
 			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// isAuthenticated reports whether the request was made by a user instead of the guest.
+func isAuthenticated(r *http.Request) bool {
+	return r.Context().Value(UserInfoKey) != nil
+}
+
 func GetUserInfo(r *http.Request) *UserInfo {
-	userInfo := r.Context().Value(UserInfoKey)
+	return GetUserInfoFromContext(r.Context())
+}
+
+func GetUserInfoFromContext(ctx context.Context) *UserInfo {
+	userInfo := ctx.Value(UserInfoKey)
 	if userInfo == nil {
 		return &UserInfo{
 			UserInfo: oidc.UserInfo{
@@ -276,16 +433,27 @@ func GetUserInfo(r *http.Request) *UserInfo {
 }
 
 func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.Auth.Mode == AuthModeLocal {
+		if isAuthenticated(r) {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		s.renderAccount(w, r, AccountVariables{Action: "login"}, http.StatusOK)
+		return
+	}
 	state := s.newID(16)
 	nonce := s.newID(16)
-	s.auth.States[state] = nonce
+	if err := s.auth.Sessions.SetState(r.Context(), state, nonce, time.Now().Add(stateLifespan)); err != nil {
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
 	http.Redirect(w, r, s.auth.Config.AuthCodeURL(state, oidc.Nonce(nonce)), http.StatusFound)
 }
 
 func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
-	sessionID, err := r.Cookie("X-Session-ID")
+	sessionID, err := r.Cookie(SessionCookieName)
 	if err == nil {
-		s.removeSession(w, sessionID.Value)
+		s.removeSession(r.Context(), w, sessionID.Value)
 	}
 	http.Redirect(w, r, "/", http.StatusFound)
 }
@@ -295,8 +463,14 @@ func (s *Server) Callback(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 
 	state := r.URL.Query().Get("state")
-	nonce, ok := s.auth.States[state]
-	if !ok {
+	nonce, err := s.auth.Sessions.PopState(ctx, state)
+	if err != nil {
+		if !errors.Is(err, ErrStateNotFound) {
+			span.SetStatus(codes.Error, "failed to get state")
+			span.RecordError(err)
+			s.prettyError(w, r, err, http.StatusInternalServerError)
+			return
+		}
 		span.SetStatus(codes.Error, "invalid state")
 		span.AddEvent("invalid state", trace.WithAttributes(attribute.String("state", state)))
 		s.error(w, r, errors.New("invalid state"), http.StatusBadRequest)
@@ -347,25 +521,65 @@ func (s *Server) Callback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := s.db.GetUser(ctx, idToken.Subject)
+	firstLogin := errors.Is(err, ErrUserNotFound)
+	if err != nil && !firstLogin {
+		span.SetStatus(codes.Error, "failed to get user")
+		span.RecordError(err)
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if user != nil {
+		if user.Disabled {
+			s.prettyError(w, r, ErrUserDisabled, http.StatusForbidden)
+			return
+		}
+		userInfo.Role = user.Role
+	}
+
 	if !s.hasAccess(&userInfo) {
 		s.prettyError(w, r, errors.New("not authorized"), http.StatusForbidden)
 		return
 	}
 
-	if err = s.db.UpsertUser(ctx, idToken.Subject, userInfo.Username, userInfo.Email, path.Join(s.cfg.Auth.DefaultHome, userInfo.Username)); err != nil {
+	// the home is only set for new users, so homes changed by admins are kept
+	home := s.defaultHome(userInfo.Username)
+	if err = s.db.UpsertUser(ctx, idToken.Subject, userInfo.Username, userInfo.Email, home, userInfo.Groups); err != nil {
 		span.SetStatus(codes.Error, "failed to upsert user")
 		span.RecordError(err)
 		s.prettyError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	sessionID := s.newID(32)
-	s.setSession(w, sessionID, &Session{
+	if firstLogin {
+		if err = s.provisionHome(ctx, idToken.Subject, home); err != nil {
+			span.SetStatus(codes.Error, "failed to provision home")
+			span.RecordError(err)
+			s.prettyError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	sessionID, err := newSecret()
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to generate session id")
+		span.RecordError(err)
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.setSession(ctx, w, Session{
+		ID:           sessionID,
 		AccessToken:  token.AccessToken,
 		RefreshToken: token.RefreshToken,
 		Expiry:       token.Expiry,
 		IDToken:      rawIDToken,
-	})
+		ExpiresAt:    time.Now().Add(s.sessionLifespan()),
+	}); err != nil {
+		span.SetStatus(codes.Error, "failed to set session")
+		span.RecordError(err)
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
 
 	http.Redirect(w, r, "/", http.StatusFound)
 }
@@ -0,0 +1,75 @@
+package godrive
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// uploadExpiry is how long unfinished uploads are kept after their last chunk.
+const uploadExpiry = 24 * time.Hour
+
+// cleanup periodically purges expired trash, file versions, shares, sessions and unfinished uploads until the server is closed.
+func (s *Server) cleanup() {
+	if s.cfg.Database.CleanupInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.cfg.Database.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.doCleanup(s.ctx)
+		}
+	}
+}
+
+func (s *Server) doCleanup(ctx context.Context) {
+	now := time.Now()
+	if retainFor := s.cfg.Trash.retainFor(); retainFor > 0 {
+		trash, err := s.db.GetExpiredTrash(ctx, now.Add(-retainFor))
+		if err != nil {
+			slog.ErrorCtx(ctx, "failed to get expired trash", slog.Any("err", err))
+		}
+		for _, trashed := range trash {
+			if err = s.purgeTrashedFile(ctx, trashed); err != nil {
+				slog.ErrorCtx(ctx, "failed to purge trashed file", slog.String("id", trashed.ID), slog.String("path", trashed.Path), slog.Any("err", err))
+			}
+		}
+	}
+
+	if s.cfg.Versions.KeepFor > 0 {
+		versions, err := s.db.GetFileVersionsArchivedBefore(ctx, now.Add(-s.cfg.Versions.KeepFor))
+		if err != nil {
+			slog.ErrorCtx(ctx, "failed to get expired file versions", slog.Any("err", err))
+		}
+		for _, version := range versions {
+			s.deleteFileVersion(ctx, version)
+		}
+	}
+
+	s.deleteExpiredShares(ctx, now)
+
+	if s.auth != nil {
+		if err := s.auth.Sessions.DeleteExpiredSessions(ctx, now); err != nil {
+			slog.ErrorCtx(ctx, "failed to delete expired sessions", slog.Any("err", err))
+		}
+		if err := s.db.DeleteExpiredAccountTokens(ctx, now); err != nil {
+			slog.ErrorCtx(ctx, "failed to delete expired invitations and password resets", slog.Any("err", err))
+		}
+	}
+
+	uploads, err := s.db.GetExpiredUploads(ctx, now.Add(-uploadExpiry))
+	if err != nil {
+		slog.ErrorCtx(ctx, "failed to get expired uploads", slog.Any("err", err))
+	}
+	for _, upload := range uploads {
+		if err = s.deleteUpload(ctx, upload); err != nil {
+			slog.ErrorCtx(ctx, "failed to delete expired upload", slog.String("id", upload.ID), slog.Any("err", err))
+		}
+	}
+}
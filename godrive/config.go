@@ -2,6 +2,7 @@ package godrive
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -15,19 +16,27 @@ type Config struct {
 	ListenAddr string         `cfg:"listen_addr"`
 	Database   DatabaseConfig `cfg:"database"`
 	Storage    StorageConfig  `cfg:"storage"`
+	Versions   VersionsConfig `cfg:"versions"`
+	Trash      TrashConfig    `cfg:"trash"`
+	Upload     UploadConfig   `cfg:"upload"`
 	Auth       *AuthConfig    `cfg:"auth"`
+	WebDAV     *WebDAVConfig  `cfg:"webdav"`
 	Otel       *OtelConfig    `cfg:"otel"`
 }
 
 func (c Config) String() string {
-	return fmt.Sprintf("\n Log: %s\n DevMode: %t\n Debug: %t\n ListenAddr: %s\n Database: %s\n Storage: %s\n Auth: %s\n Otel: %s\n",
+	return fmt.Sprintf("\n Log: %s\n DevMode: %t\n Debug: %t\n ListenAddr: %s\n Database: %s\n Storage: %s\n Versions: %s\n Trash: %s\n Upload: %s\n Auth: %s\n WebDAV: %s\n Otel: %s\n",
 		c.Log,
 		c.DevMode,
 		c.Debug,
 		c.ListenAddr,
 		c.Database,
 		c.Storage,
+		c.Versions,
+		c.Trash,
+		c.Upload,
 		c.Auth,
+		c.WebDAV,
 		c.Otel,
 	)
 }
@@ -54,8 +63,9 @@ const (
 )
 
 type DatabaseConfig struct {
-	Type  DatabaseType `cfg:"type"`
-	Debug bool         `cfg:"debug"`
+	Type            DatabaseType  `cfg:"type"`
+	Debug           bool          `cfg:"debug"`
+	CleanupInterval time.Duration `cfg:"cleanup_interval"`
 
 	// SQLite
 	Path string `cfg:"path"`
@@ -70,9 +80,10 @@ type DatabaseConfig struct {
 }
 
 func (c DatabaseConfig) String() string {
-	str := fmt.Sprintf("\n  Type: %s\n  Debug: %t\n  ",
+	str := fmt.Sprintf("\n  Type: %s\n  Debug: %t\n  CleanupInterval: %s\n  ",
 		c.Type,
 		c.Debug,
+		c.CleanupInterval,
 	)
 	switch c.Type {
 	case "postgres":
@@ -113,6 +124,10 @@ const (
 type StorageConfig struct {
 	Type  StorageType `cfg:"type"`
 	Debug bool        `cfg:"debug"`
+	// Deduplicate stores identical content only once, see NewDedupStorage.
+	Deduplicate bool `cfg:"deduplicate"`
+	// Encryption encrypts all objects at rest, see NewEncryptStorage.
+	Encryption *EncryptionConfig `cfg:"encryption"`
 
 	// Local
 	Path  string `cfg:"path"`
@@ -125,21 +140,38 @@ type StorageConfig struct {
 	Bucket          string `cfg:"bucket"`
 	Region          string `cfg:"region"`
 	Secure          bool   `cfg:"secure"`
+	// Presign lets clients download and upload directly from and to S3, see Presigner.
+	Presign *PresignConfig `cfg:"presign"`
+}
+
+// Location identifies where the storage keeps its objects, independent of other settings.
+func (c StorageConfig) Location() string {
+	switch c.Type {
+	case StorageTypeLocal:
+		if path, err := filepath.Abs(c.Path); err == nil {
+			return "local:" + path
+		}
+		return "local:" + c.Path
+	case StorageTypeS3:
+		return "s3:" + c.Endpoint + "/" + c.Bucket
+	}
+	return string(c.Type)
 }
 
 func (c StorageConfig) String() string {
-	str := fmt.Sprintf("\n  Type: %s\n  Debug: %t\n  ", c.Type, c.Debug)
+	str := fmt.Sprintf("\n  Type: %s\n  Debug: %t\n  Deduplicate: %t\n  Encryption: %s\n  ", c.Type, c.Debug, c.Deduplicate, c.Encryption)
 	switch c.Type {
 	case "local":
 		str += fmt.Sprintf("Path: %s\n  Umask: %d", c.Path, c.Umask)
 	case "s3":
-		str += fmt.Sprintf("Endpoint: %s\n  AccessKeyID: %s\n  SecretAccessKey: %s\n  Bucket: %s\n  Region: %s\n  Secure: %t",
+		str += fmt.Sprintf("Endpoint: %s\n  AccessKeyID: %s\n  SecretAccessKey: %s\n  Bucket: %s\n  Region: %s\n  Secure: %t\n  Presign: %s",
 			c.Endpoint,
 			c.AccessKeyID,
 			strings.Repeat("*", len(c.SecretAccessKey)),
 			c.Bucket,
 			c.Region,
 			c.Secure,
+			c.Presign,
 		)
 	default:
 		str += "Invalid storage type!"
@@ -147,19 +179,162 @@ func (c StorageConfig) String() string {
 	return str
 }
 
+// EncryptionConfig configures the master keys of the encrypting storage. Keys are 32 bytes encoded as base64, key files contain the raw or base64 encoded key.
+// New objects are encrypted with Key or KeyFile, the old keys are only used to read objects which were not rekeyed yet.
+// AllowPlaintext reads objects stored before encryption was enabled unencrypted, it is meant for the migration until they are rekeyed.
+type EncryptionConfig struct {
+	Key            string   `cfg:"key"`
+	KeyFile        string   `cfg:"key_file"`
+	OldKeys        []string `cfg:"old_keys"`
+	OldKeyFiles    []string `cfg:"old_key_files"`
+	AllowPlaintext bool     `cfg:"allow_plaintext"`
+}
+
+func (c EncryptionConfig) String() string {
+	return fmt.Sprintf("\n   Key: %s\n   KeyFile: %s\n   OldKeys: %d\n   OldKeyFiles: %s\n   AllowPlaintext: %t",
+		strings.Repeat("*", len(c.Key)),
+		c.KeyFile,
+		len(c.OldKeys),
+		c.OldKeyFiles,
+		c.AllowPlaintext,
+	)
+}
+
+const (
+	defaultPresignExpiry   = 15 * time.Minute
+	defaultPresignPartSize = 64 << 20
+	// minPresignPartSize is the smallest part S3 accepts for all but the last part of a multipart upload.
+	minPresignPartSize = 5 << 20
+)
+
+type PresignConfig struct {
+	// Downloads redirects file downloads to a presigned GET URL.
+	Downloads bool `cfg:"downloads"`
+	// Uploads enables the /uploads endpoints which hand out presigned PUT URLs.
+	Uploads bool `cfg:"uploads"`
+	// Expiry is how long presigned URLs are valid, defaults to 15 minutes.
+	Expiry time.Duration `cfg:"expiry"`
+	// PartSize is the size of the parts of multipart uploads. Smaller uploads use a single PUT URL. Defaults to 64 MiB.
+	PartSize uint64 `cfg:"part_size"`
+	// PublicURL is the URL clients reach S3 at if it differs from the endpoint, for example "https://s3.example.com".
+	PublicURL string `cfg:"public_url"`
+}
+
+func (c PresignConfig) String() string {
+	return fmt.Sprintf("\n   Downloads: %t\n   Uploads: %t\n   Expiry: %s\n   PartSize: %d\n   PublicURL: %s",
+		c.Downloads,
+		c.Uploads,
+		c.Expiry,
+		c.PartSize,
+		c.PublicURL,
+	)
+}
+
+func (c PresignConfig) expiry() time.Duration {
+	if c.Expiry <= 0 {
+		return defaultPresignExpiry
+	}
+	return c.Expiry
+}
+
+func (c PresignConfig) partSize() uint64 {
+	if c.PartSize == 0 {
+		return defaultPresignPartSize
+	}
+	if c.PartSize < minPresignPartSize {
+		return minPresignPartSize
+	}
+	return c.PartSize
+}
+
+// VersionsConfig configures how many previous versions of a file are kept.
+// Keep limits the number of versions per file and KeepFor the age of versions, zero values disable the limit.
+type VersionsConfig struct {
+	Keep    int           `cfg:"keep"`
+	KeepFor time.Duration `cfg:"keep_for"`
+}
+
+func (c VersionsConfig) String() string {
+	return fmt.Sprintf("\n  Keep: %d\n  KeepFor: %s",
+		c.Keep,
+		c.KeepFor,
+	)
+}
+
+// defaultTrashRetention is how long deleted files stay in the trash if no retention is configured.
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+type TrashConfig struct {
+	RetainFor time.Duration `cfg:"retain_for"`
+}
+
+func (c TrashConfig) String() string {
+	return fmt.Sprintf("\n  RetainFor: %s",
+		c.retainFor(),
+	)
+}
+
+// retainFor returns how long deleted files stay in the trash, a negative duration keeps them until the trash is emptied.
+func (c TrashConfig) retainFor() time.Duration {
+	if c.RetainFor == 0 {
+		return defaultTrashRetention
+	}
+	return c.RetainFor
+}
+
+// UploadConfig limits which files can be uploaded. Content types and extensions are checked against the deny list first,
+// an empty allow list allows everything which is not denied.
+type UploadConfig struct {
+	MaxSize           uint64   `cfg:"max_size"`
+	AllowedTypes      []string `cfg:"allowed_types"`
+	DeniedTypes       []string `cfg:"denied_types"`
+	AllowedExtensions []string `cfg:"allowed_extensions"`
+	DeniedExtensions  []string `cfg:"denied_extensions"`
+}
+
+func (c UploadConfig) String() string {
+	return fmt.Sprintf("\n  MaxSize: %d\n  AllowedTypes: %v\n  DeniedTypes: %v\n  AllowedExtensions: %v\n  DeniedExtensions: %v",
+		c.MaxSize,
+		c.AllowedTypes,
+		c.DeniedTypes,
+		c.AllowedExtensions,
+		c.DeniedExtensions,
+	)
+}
+
+type SessionStoreType string
+
+const (
+	SessionStoreTypeDatabase SessionStoreType = "database"
+	SessionStoreTypeMemory   SessionStoreType = "memory"
+)
+
+type AuthMode string
+
+const (
+	// AuthModeOIDC logs users in with an OpenID Connect provider.
+	AuthModeOIDC AuthMode = "oidc"
+	// AuthModeLocal logs users in with a username and password stored by godrive. Users are invited by admins.
+	AuthModeLocal AuthMode = "local"
+)
+
 type AuthConfig struct {
-	Secure               bool          `cfg:"secure"`
-	Issuer               string        `cfg:"issuer"`
-	ClientID             string        `cfg:"client_id"`
-	ClientSecret         string        `cfg:"client_secret"`
-	RedirectURL          string        `cfg:"redirect_url"`
-	RefreshTokenLifespan time.Duration `cfg:"refresh_token_lifespan"`
-	DefaultHome          string        `cfg:"default_home"`
-	Groups               AuthGroups    `cfg:"groups"`
+	Mode                 AuthMode         `cfg:"mode"`
+	Secure               bool             `cfg:"secure"`
+	Issuer               string           `cfg:"issuer"`
+	ClientID             string           `cfg:"client_id"`
+	ClientSecret         string           `cfg:"client_secret"`
+	RedirectURL          string           `cfg:"redirect_url"`
+	RefreshTokenLifespan time.Duration    `cfg:"refresh_token_lifespan"`
+	DefaultHome          string           `cfg:"default_home"`
+	Home                 HomeConfig       `cfg:"home"`
+	SessionStore         SessionStoreType `cfg:"session_store"`
+	Groups               AuthGroups       `cfg:"groups"`
 }
 
 func (c AuthConfig) String() string {
-	return fmt.Sprintf("\n  Secure: %t\n  Issuer: %s\n  ClientID: %s\n  ClientSecret: %s\n  RedirectURL: %s\n  RefreshTokenLifespan: %s\n  DefaultHome: %s\n  Groups: %s",
+	return fmt.Sprintf("\n  Mode: %s\n  Secure: %t\n  Issuer: %s\n  ClientID: %s\n  ClientSecret: %s\n  RedirectURL: %s\n  RefreshTokenLifespan: %s\n  DefaultHome: %s\n  Home: %s\n  SessionStore: %s\n  Groups: %s",
+		c.Mode,
 		c.Secure,
 		c.Issuer,
 		c.ClientID,
@@ -167,23 +342,74 @@ func (c AuthConfig) String() string {
 		c.RedirectURL,
 		c.RefreshTokenLifespan,
 		c.DefaultHome,
+		c.Home,
+		c.SessionStore,
 		c.Groups,
 	)
 }
 
+// HomeConfig confines users who are not admins to their home folder. ACL entries still grant access to other folders.
+type HomeConfig struct {
+	// Confine limits uploading and changing files to the home folder.
+	Confine bool `cfg:"confine"`
+	// ConfineReads also limits reading files to the home folder and implies Confine.
+	ConfineReads bool `cfg:"confine_reads"`
+}
+
+func (c HomeConfig) String() string {
+	return fmt.Sprintf("\n    Confine: %t\n    ConfineReads: %t",
+		c.Confine,
+		c.ConfineReads,
+	)
+}
+
 type AuthGroups struct {
-	Admin  string `cfg:"admin"`
-	User   string `cfg:"user"`
-	Viewer string `cfg:"viewer"`
-	Guest  bool   `cfg:"guest"`
+	Admin  string       `cfg:"admin"`
+	User   string       `cfg:"user"`
+	Viewer string       `cfg:"viewer"`
+	Guest  bool         `cfg:"guest"`
+	Quotas []GroupQuota `cfg:"quotas"`
+	// Permissions replaces the permissions of groups, the admin, user and viewer groups default to the permissions of their role.
+	Permissions []GroupPermissions `cfg:"permissions"`
 }
 
 func (c AuthGroups) String() string {
-	return fmt.Sprintf("\n    Admin: %s\n    User: %s\n    Viewer: %s\n    Guest: %t",
+	return fmt.Sprintf("\n    Admin: %s\n    User: %s\n    Viewer: %s\n    Guest: %t\n    Quotas: %v\n    Permissions: %v",
 		c.Admin,
 		c.User,
 		c.Viewer,
 		c.Guest,
+		c.Quotas,
+		c.Permissions,
+	)
+}
+
+type GroupPermissions struct {
+	Group       string       `cfg:"group"`
+	Permissions []Permission `cfg:"permissions"`
+}
+
+func (c GroupPermissions) String() string {
+	return fmt.Sprintf("%s: %v", c.Group, c.Permissions)
+}
+
+// GroupQuota limits how many bytes members of a group can store. Members of multiple groups get the largest quota, 0 means unlimited.
+type GroupQuota struct {
+	Group string `cfg:"group"`
+	Quota uint64 `cfg:"quota"`
+}
+
+func (c GroupQuota) String() string {
+	return fmt.Sprintf("%s: %d", c.Group, c.Quota)
+}
+
+type WebDAVConfig struct {
+	Prefix string `cfg:"prefix"`
+}
+
+func (c WebDAVConfig) String() string {
+	return fmt.Sprintf("\n  Prefix: %s",
+		c.Prefix,
 	)
 }
 
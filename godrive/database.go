@@ -3,9 +3,9 @@ package godrive
 import (
 	"context"
 	"database/sql"
-	_ "embed"
 	"errors"
 	"fmt"
+	"io/fs"
 	"strings"
 	"time"
 
@@ -27,6 +27,18 @@ var (
 	ErrFileNotFound      = errors.New("file not found")
 	ErrFileAlreadyExists = errors.New("file already exists")
 	ErrUserNotFound      = errors.New("user not found")
+	ErrUploadNotFound    = errors.New("upload not found")
+	ErrUploadConflict    = errors.New("upload offset conflict")
+	ErrVersionNotFound   = errors.New("version not found")
+	ErrTrashNotFound     = errors.New("trashed file not found")
+	ErrShareNotFound     = errors.New("share not found")
+	ErrShareExhausted    = errors.New("share download limit reached")
+	ErrTokenNotFound     = errors.New("token not found")
+	ErrBlobRefNotFound   = errors.New("blob ref not found")
+	ErrACLNotFound       = errors.New("acl entry not found")
+	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrInvitationInvalid = errors.New("invitation is invalid or expired")
+	ErrResetInvalid      = errors.New("password reset is invalid or expired")
 )
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -57,9 +69,184 @@ type User struct {
 	Groups   string `db:"groups"`
 	Email    string `db:"email"`
 	Home     string `db:"home"`
+	// Quota overrides the quota of the groups of the user, 0 means unlimited and nil uses the group quota
+	Quota *uint64 `db:"quota"`
+	// Role overrides the permissions of the groups of the user, nil uses the groups
+	Role *Role `db:"role"`
+	// Disabled users can not log in and their tokens and shares stop working
+	Disabled bool `db:"disabled"`
+	// Password is the bcrypt hash of the password of local users, it is empty for users of the OIDC provider
+	Password string `db:"password"`
 }
 
-func NewDB(ctx context.Context, cfg DatabaseConfig, schema string) (*DB, error) {
+// Invitation lets someone create a local account with the role. Only the hash of the invitation token is stored.
+type Invitation struct {
+	Hash      string    `db:"hash"`
+	Role      Role      `db:"role"`
+	CreatedBy string    `db:"created_by"`
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// PasswordReset lets a local user set a new password. Only the hash of the reset token is stored.
+type PasswordReset struct {
+	Hash      string    `db:"hash"`
+	UserID    string    `db:"user_id"`
+	CreatedBy string    `db:"created_by"`
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleUser   Role = "user"
+	RoleViewer Role = "viewer"
+)
+
+type TokenScope string
+
+const (
+	TokenScopeRead  TokenScope = "read"
+	TokenScopeWrite TokenScope = "write"
+	TokenScopeAdmin TokenScope = "admin"
+)
+
+type Token struct {
+	ID         string     `db:"id"`
+	UserID     string     `db:"user_id"`
+	Name       string     `db:"name"`
+	Hash       string     `db:"hash"`
+	Scope      TokenScope `db:"scope"`
+	ExpiresAt  *time.Time `db:"expires_at"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	CreatedAt  time.Time  `db:"created_at"`
+}
+
+type Upload struct {
+	ID          string    `db:"id"`
+	Path        string    `db:"path"`
+	Size        uint64    `db:"size"`
+	Offset      uint64    `db:"upload_offset"`
+	Chunks      int       `db:"chunks"`
+	ContentType string    `db:"content_type"`
+	Description string    `db:"description"`
+	UserID      string    `db:"user_id"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+	// Presigned uploads are uploaded directly to the storage as their first chunk, see PresignRoutes.
+	Presigned   bool   `db:"presigned"`
+	MultipartID string `db:"multipart_id"`
+}
+
+type FileVersion struct {
+	ID          string    `db:"id"`
+	Path        string    `db:"path"`
+	Version     int       `db:"version"`
+	Size        uint64    `db:"size"`
+	ContentType string    `db:"content_type"`
+	UserID      string    `db:"user_id"`
+	Username    *string   `db:"username"`
+	CreatedAt   time.Time `db:"created_at"`
+	ArchivedAt  time.Time `db:"archived_at"`
+}
+
+type TrashedFile struct {
+	ID          string    `db:"id"`
+	Path        string    `db:"path"`
+	Size        uint64    `db:"size"`
+	ContentType string    `db:"content_type"`
+	Description string    `db:"description"`
+	UserID      string    `db:"user_id"`
+	Username    *string   `db:"username"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+	DeletedBy   string    `db:"deleted_by"`
+	DeletedAt   time.Time `db:"deleted_at"`
+}
+
+type SharePermission string
+
+const (
+	SharePermissionRead   SharePermission = "read"
+	SharePermissionUpload SharePermission = "upload"
+)
+
+type Share struct {
+	Token        string          `db:"token"`
+	Path         string          `db:"path"`
+	UserID       string          `db:"user_id"`
+	Username     *string         `db:"username"`
+	Password     string          `db:"password"`
+	Permission   SharePermission `db:"permission"`
+	MaxDownloads uint64          `db:"max_downloads"`
+	Downloads    uint64          `db:"downloads"`
+	ExpiresAt    *time.Time      `db:"expires_at"`
+	CreatedAt    time.Time       `db:"created_at"`
+}
+
+type ACLSubjectType string
+
+const (
+	ACLSubjectUser  ACLSubjectType = "user"
+	ACLSubjectGroup ACLSubjectType = "group"
+)
+
+// ACLPermission is ordered, every permission includes the permissions before it.
+type ACLPermission string
+
+const (
+	ACLPermissionRead   ACLPermission = "read"
+	ACLPermissionWrite  ACLPermission = "write"
+	ACLPermissionManage ACLPermission = "manage"
+)
+
+// ACL grants or denies a user or group a permission on a folder and everything below it.
+// Subject is the user id or the group name, Username is only set for users.
+type ACL struct {
+	Path        string         `db:"path"`
+	SubjectType ACLSubjectType `db:"subject_type"`
+	Subject     string         `db:"subject"`
+	Username    *string        `db:"username"`
+	Permission  ACLPermission  `db:"permission"`
+	Deny        bool           `db:"deny"`
+	CreatedBy   string         `db:"created_by"`
+	CreatedAt   time.Time      `db:"created_at"`
+}
+
+// Folder is an empty folder created over WebDAV. Folders otherwise only exist through the files in them, so it is deleted once a file is put into it.
+type Folder struct {
+	Path      string    `db:"path"`
+	UserID    string    `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type AuditAction string
+
+const (
+	AuditActionUserUpdated   AuditAction = "user_updated"
+	AuditActionUserDisabled  AuditAction = "user_disabled"
+	AuditActionUserEnabled   AuditAction = "user_enabled"
+	AuditActionUserDeleted   AuditAction = "user_deleted"
+	AuditActionUserInvited   AuditAction = "user_invited"
+	AuditActionUserCreated   AuditAction = "user_created"
+	AuditActionPasswordReset AuditAction = "password_reset"
+)
+
+// AuditEntry records a change an admin made. UserID and Username are the admin, Target is the name of what was changed.
+type AuditEntry struct {
+	ID        string      `db:"id"`
+	UserID    string      `db:"user_id"`
+	Username  string      `db:"username"`
+	Action    AuditAction `db:"action"`
+	Target    string      `db:"target"`
+	Details   string      `db:"details"`
+	CreatedAt time.Time   `db:"created_at"`
+}
+
+// NewDB connects to the configured database. Call DB.MigrateUp to bring the schema up to date.
+func NewDB(ctx context.Context, cfg DatabaseConfig, migrations fs.FS) (*DB, error) {
 	var (
 		driverName     string
 		dataSourceName string
@@ -89,7 +276,12 @@ func NewDB(ctx context.Context, cfg DatabaseConfig, schema string) (*DB, error)
 		dbSystem = semconv.DBSystemPostgreSQL
 	case DatabaseTypeSQLite:
 		driverName = "sqlite"
-		dataSourceName = cfg.Path
+		// wait for concurrent writers instead of failing with SQLITE_BUSY
+		sep := "?"
+		if strings.Contains(cfg.Path, "?") {
+			sep = "&"
+		}
+		dataSourceName = cfg.Path + sep + "_pragma=busy_timeout(5000)"
 		dbSystem = semconv.DBSystemSqlite
 	default:
 		return nil, errors.New("invalid database type, must be one of: postgres, sqlite")
@@ -107,24 +299,51 @@ func NewDB(ctx context.Context, cfg DatabaseConfig, schema string) (*DB, error)
 		return nil, err
 	}
 
-	dbx := sqlx.NewDb(sqlDB, driverName)
-	if err = dbx.PingContext(ctx); err != nil {
+	dbMigrations, err := LoadMigrations(migrations, cfg.Type)
+	if err != nil {
 		return nil, err
 	}
-	// execute schema
-	if _, err = dbx.ExecContext(ctx, schema); err != nil {
+
+	dbx := sqlx.NewDb(sqlDB, driverName)
+	if err = dbx.PingContext(ctx); err != nil {
 		return nil, err
 	}
 
 	db := &DB{
-		dbx: dbx,
+		dbx:        dbx,
+		migrations: dbMigrations,
 	}
 
 	return db, nil
 }
 
+type BlobRef struct {
+	Path string `db:"path"`
+	Hash string `db:"hash"`
+}
+
+// MigratedObject is an object copied to another storage by MigrateStorage. Target identifies the storage it was copied to.
+type MigratedObject struct {
+	Target     string    `db:"target"`
+	Path       string    `db:"path"`
+	Size       uint64    `db:"size"`
+	SourceETag string    `db:"source_etag"`
+	Checksum   string    `db:"checksum"`
+	MigratedAt time.Time `db:"migrated_at"`
+}
+
+// ExpectedObject is an object the database expects in the storage. Key is the primary key of the row the object belongs to.
+type ExpectedObject struct {
+	Path string
+	Kind ObjectKind
+	Key  string
+	// Size is nil for objects without known size
+	Size *uint64
+}
+
 type DB struct {
-	dbx *sqlx.DB
+	dbx        *sqlx.DB
+	migrations []Migration
 }
 
 func (d *DB) Close() error {
@@ -152,6 +371,14 @@ func (d *DB) FindFiles(ctx context.Context, path string) ([]File, error) {
 	return files, nil
 }
 
+func (d *DB) GetUserFiles(ctx context.Context, userID string) ([]File, error) {
+	var files []File
+	if err := d.dbx.SelectContext(ctx, &files, "SELECT files.*, users.username FROM files LEFT JOIN users ON files.user_id = users.id WHERE files.user_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("error getting user files: %w", err)
+	}
+	return files, nil
+}
+
 func (d *DB) GetFile(ctx context.Context, path string) (*File, error) {
 	file := new(File)
 	err := d.dbx.GetContext(ctx, file, "SELECT files.*, users.username FROM files LEFT JOIN users ON files.user_id = users.id WHERE files.path = $1", path)
@@ -176,14 +403,8 @@ func (d *DB) CreateFile(ctx context.Context, path string, size uint64, contentTy
 	}
 	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO files (path, size, content_type, description, user_id, created_at, updated_at) VALUES (:path, :size, :content_type, :description, :user_id, :created_at, :updated_at)", file)
 	if err != nil {
-		var (
-			sqliteErr *sqlite.Error
-			pgErr     *pgconn.PgError
-		)
-		if errors.As(err, &sqliteErr) || errors.As(err, &pgErr) {
-			if (sqliteErr != nil && sqliteErr.Code() == 1555) || (pgErr != nil && pgErr.Code == "23505") {
-				err = ErrFileAlreadyExists
-			}
+		if isUniqueViolation(err) {
+			err = ErrFileAlreadyExists
 		}
 		return nil, fmt.Errorf("error creating file: %w", err)
 	}
@@ -212,6 +433,12 @@ func (d *DB) UpdateFile(ctx context.Context, path string, newPath string, size u
 	if rows, _ := res.RowsAffected(); rows == 0 {
 		return ErrFileNotFound
 	}
+
+	if path != newPath {
+		if _, err = d.dbx.ExecContext(ctx, "UPDATE file_versions SET path = $1 WHERE path = $2", newPath, path); err != nil {
+			return fmt.Errorf("error updating file versions: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -227,14 +454,15 @@ func (d *DB) DeleteFile(ctx context.Context, path string) error {
 	return nil
 }
 
-func (d *DB) UpsertUser(ctx context.Context, id string, username string, email string, home string) error {
+func (d *DB) UpsertUser(ctx context.Context, id string, username string, email string, home string, groups []string) error {
 	user := &User{
 		ID:       id,
 		Username: username,
+		Groups:   strings.Join(groups, ","),
 		Email:    email,
 		Home:     home,
 	}
-	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO users (id, username, email, home) VALUES (:id, :username, :email, :home) ON CONFLICT (id) DO UPDATE SET username = :username, email = :email", user)
+	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO users (id, username, groups, email, home) VALUES (:id, :username, :groups, :email, :home) ON CONFLICT (id) DO UPDATE SET username = :username, groups = :groups, email = :email", user)
 	if err != nil {
 		return fmt.Errorf("error upserting user: %w", err)
 	}
@@ -290,3 +518,997 @@ func (d *DB) GetAllUsers(ctx context.Context) ([]User, error) {
 
 	return users, nil
 }
+
+// UpdateUser saves the home, quota, role and disabled state of the user. The ACL entries are set in the same transaction, like the entry of a new home folder.
+func (d *DB) UpdateUser(ctx context.Context, user User, acls ...ACL) error {
+	return d.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE users SET home = $1, quota = $2, role = $3, disabled = $4 WHERE id = $5", user.Home, user.Quota, user.Role, user.Disabled, user.ID)
+		if err != nil {
+			return fmt.Errorf("error updating user: %w", err)
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return ErrUserNotFound
+		}
+		for _, acl := range acls {
+			if err = setACL(ctx, tx, acl); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *DB) SetUserPassword(ctx context.Context, id string, password string) error {
+	res, err := d.dbx.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2", password, id)
+	if err != nil {
+		return fmt.Errorf("error setting user password: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (d *DB) CreateInvitation(ctx context.Context, invitation Invitation) error {
+	if _, err := d.dbx.NamedExecContext(ctx, "INSERT INTO invitations (hash, role, created_by, expires_at, created_at) VALUES (:hash, :role, :created_by, :expires_at, :created_at)", invitation); err != nil {
+		return fmt.Errorf("error creating invitation: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) GetInvitation(ctx context.Context, hash string) (*Invitation, error) {
+	invitation := new(Invitation)
+	if err := d.dbx.GetContext(ctx, invitation, "SELECT * FROM invitations WHERE hash = $1 AND expires_at > $2", hash, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrInvitationInvalid
+		}
+		return nil, fmt.Errorf("error getting invitation: %w", err)
+	}
+	return invitation, nil
+}
+
+// AcceptInvitation creates the local user and deletes the invitation, so every invitation creates only one user.
+// It fails with ErrUserAlreadyExists if the username is taken.
+func (d *DB) AcceptInvitation(ctx context.Context, hash string, user User) error {
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM invitations WHERE hash = $1 AND expires_at > $2", hash, time.Now())
+		if err != nil {
+			return err
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return ErrInvitationInvalid
+		}
+		var exists bool
+		if err = tx.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)", user.Username); err != nil {
+			return err
+		}
+		if exists {
+			return ErrUserAlreadyExists
+		}
+		_, err = tx.NamedExecContext(ctx, "INSERT INTO users (id, username, groups, email, home, role, password) VALUES (:id, :username, :groups, :email, :home, :role, :password)", user)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error accepting invitation: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) CreatePasswordReset(ctx context.Context, reset PasswordReset) error {
+	if _, err := d.dbx.NamedExecContext(ctx, "INSERT INTO password_resets (hash, user_id, created_by, expires_at, created_at) VALUES (:hash, :user_id, :created_by, :expires_at, :created_at)", reset); err != nil {
+		return fmt.Errorf("error creating password reset: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) GetPasswordReset(ctx context.Context, hash string) (*PasswordReset, error) {
+	reset := new(PasswordReset)
+	if err := d.dbx.GetContext(ctx, reset, "SELECT * FROM password_resets WHERE hash = $1 AND expires_at > $2", hash, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrResetInvalid
+		}
+		return nil, fmt.Errorf("error getting password reset: %w", err)
+	}
+	return reset, nil
+}
+
+// ResetPassword sets the password of the user of the reset and deletes all resets of the user.
+func (d *DB) ResetPassword(ctx context.Context, reset PasswordReset, password string) error {
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM password_resets WHERE hash = $1 AND expires_at > $2", reset.Hash, time.Now())
+		if err != nil {
+			return err
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return ErrResetInvalid
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM password_resets WHERE user_id = $1", reset.UserID); err != nil {
+			return err
+		}
+		res, err = tx.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2", password, reset.UserID)
+		if err != nil {
+			return err
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error resetting password: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredAccountTokens deletes invitations and password resets which expired before the given time.
+func (d *DB) DeleteExpiredAccountTokens(ctx context.Context, before time.Time) error {
+	if _, err := d.dbx.ExecContext(ctx, "DELETE FROM invitations WHERE expires_at < $1", before); err != nil {
+		return fmt.Errorf("error deleting expired invitations: %w", err)
+	}
+	if _, err := d.dbx.ExecContext(ctx, "DELETE FROM password_resets WHERE expires_at < $1", before); err != nil {
+		return fmt.Errorf("error deleting expired password resets: %w", err)
+	}
+	return nil
+}
+
+// DeleteUser deletes the user with their tokens and ACL entries.
+// Everything the user stores is transferred to the user transferTo. Without transferTo the shares of the user are deleted
+// and their files, versions, trashed files and uploads are kept without owner, so they must be trashed before.
+func (d *DB) DeleteUser(ctx context.Context, id string, transferTo string) error {
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+		if err != nil {
+			return err
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return ErrUserNotFound
+		}
+		if transferTo != "" {
+			for _, table := range []string{"files", "file_versions", "trash", "uploads", "shares"} {
+				if _, err = tx.ExecContext(ctx, "UPDATE "+table+" SET user_id = $1 WHERE user_id = $2", transferTo, id); err != nil {
+					return err
+				}
+			}
+		} else if _, err = tx.ExecContext(ctx, "DELETE FROM shares WHERE user_id = $1", id); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM tokens WHERE user_id = $1", id); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM password_resets WHERE user_id = $1", id); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM acls WHERE subject_type = $1 AND subject = $2", ACLSubjectUser, id)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+	return nil
+}
+
+// usageQuery selects the size of everything stored for a user: files, versions, trashed files and reserved space of unfinished uploads.
+const usageQuery = `SELECT user_id, size FROM files
+UNION ALL SELECT user_id, size FROM file_versions
+UNION ALL SELECT user_id, size FROM trash
+UNION ALL SELECT user_id, size FROM uploads`
+
+// GetUsage returns how many bytes are stored for the user.
+func (d *DB) GetUsage(ctx context.Context, userID string) (uint64, error) {
+	var usage uint64
+	if err := d.dbx.GetContext(ctx, &usage, "SELECT CAST(COALESCE(SUM(size), 0) AS BIGINT) FROM ("+usageQuery+") AS stored WHERE user_id = $1", userID); err != nil {
+		return 0, fmt.Errorf("error getting usage: %w", err)
+	}
+	return usage, nil
+}
+
+// GetUsages returns how many bytes are stored for each user with stored files.
+func (d *DB) GetUsages(ctx context.Context) (map[string]uint64, error) {
+	var rows []struct {
+		UserID string `db:"user_id"`
+		Usage  uint64 `db:"usage"`
+	}
+	if err := d.dbx.SelectContext(ctx, &rows, "SELECT user_id, CAST(COALESCE(SUM(size), 0) AS BIGINT) AS usage FROM ("+usageQuery+") AS stored GROUP BY user_id"); err != nil {
+		return nil, fmt.Errorf("error getting usages: %w", err)
+	}
+	usages := make(map[string]uint64, len(rows))
+	for _, row := range rows {
+		usages[row.UserID] = row.Usage
+	}
+	return usages, nil
+}
+
+func (d *DB) CreateUpload(ctx context.Context, upload Upload) error {
+	upload.CreatedAt = time.Now()
+	upload.UpdatedAt = upload.CreatedAt
+	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO uploads (id, path, size, upload_offset, chunks, content_type, description, user_id, created_at, updated_at, presigned, multipart_id) VALUES (:id, :path, :size, :upload_offset, :chunks, :content_type, :description, :user_id, :created_at, :updated_at, :presigned, :multipart_id)", upload)
+	if err != nil {
+		return fmt.Errorf("error creating upload: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) GetUpload(ctx context.Context, id string) (*Upload, error) {
+	upload := new(Upload)
+	if err := d.dbx.GetContext(ctx, upload, "SELECT * FROM uploads WHERE id = $1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("error getting upload: %w", err)
+	}
+
+	return upload, nil
+}
+
+// UpdateUploadOffset advances the offset of an upload. It fails with ErrUploadConflict if the offset was changed concurrently.
+func (d *DB) UpdateUploadOffset(ctx context.Context, id string, oldOffset uint64, newOffset uint64, chunks int) error {
+	res, err := d.dbx.ExecContext(ctx, "UPDATE uploads SET upload_offset = $1, chunks = $2, updated_at = $3 WHERE id = $4 AND upload_offset = $5", newOffset, chunks, time.Now(), id, oldOffset)
+	if err != nil {
+		return fmt.Errorf("error updating upload: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrUploadConflict
+	}
+	return nil
+}
+
+func (d *DB) DeleteUpload(ctx context.Context, id string) error {
+	res, err := d.dbx.ExecContext(ctx, "DELETE FROM uploads WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting upload: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrUploadNotFound
+	}
+	return nil
+}
+
+// CreateFileVersion stores a new version for the file at the given path, numbered after the latest existing version.
+func (d *DB) CreateFileVersion(ctx context.Context, id string, file File) (*FileVersion, error) {
+	var latest int
+	if err := d.dbx.GetContext(ctx, &latest, "SELECT COALESCE(MAX(version), 0) FROM file_versions WHERE path = $1", file.Path); err != nil {
+		return nil, fmt.Errorf("error getting latest file version: %w", err)
+	}
+
+	createdAt := file.CreatedAt
+	if file.UpdatedAt.After(createdAt) {
+		createdAt = file.UpdatedAt
+	}
+	version := &FileVersion{
+		ID:          id,
+		Path:        file.Path,
+		Version:     latest + 1,
+		Size:        file.Size,
+		ContentType: file.ContentType,
+		UserID:      file.UserID,
+		CreatedAt:   createdAt,
+		ArchivedAt:  time.Now(),
+	}
+	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO file_versions (id, path, version, size, content_type, user_id, created_at, archived_at) VALUES (:id, :path, :version, :size, :content_type, :user_id, :created_at, :archived_at)", version)
+	if err != nil {
+		return nil, fmt.Errorf("error creating file version: %w", err)
+	}
+	return version, nil
+}
+
+func (d *DB) GetFileVersions(ctx context.Context, path string) ([]FileVersion, error) {
+	var versions []FileVersion
+	if err := d.dbx.SelectContext(ctx, &versions, "SELECT file_versions.*, users.username FROM file_versions LEFT JOIN users ON file_versions.user_id = users.id WHERE file_versions.path = $1 ORDER BY file_versions.version DESC", path); err != nil {
+		return nil, fmt.Errorf("error getting file versions: %w", err)
+	}
+	return versions, nil
+}
+
+func (d *DB) GetFileVersion(ctx context.Context, path string, version int) (*FileVersion, error) {
+	fileVersion := new(FileVersion)
+	if err := d.dbx.GetContext(ctx, fileVersion, "SELECT file_versions.*, users.username FROM file_versions LEFT JOIN users ON file_versions.user_id = users.id WHERE file_versions.path = $1 AND file_versions.version = $2", path, version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrVersionNotFound
+		}
+		return nil, fmt.Errorf("error getting file version: %w", err)
+	}
+	return fileVersion, nil
+}
+
+// GetExpiredFileVersions returns the versions of the file at the given path which are beyond the newest keep versions or were archived before the given time.
+// A keep value <= 0 and a zero time disable the respective limit.
+func (d *DB) GetExpiredFileVersions(ctx context.Context, path string, keep int, before time.Time) ([]FileVersion, error) {
+	versions, err := d.GetFileVersions(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []FileVersion
+	for i, version := range versions {
+		if (keep > 0 && i >= keep) || (!before.IsZero() && version.ArchivedAt.Before(before)) {
+			expired = append(expired, version)
+		}
+	}
+	return expired, nil
+}
+
+func (d *DB) DeleteFileVersion(ctx context.Context, id string) error {
+	res, err := d.dbx.ExecContext(ctx, "DELETE FROM file_versions WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting file version: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrVersionNotFound
+	}
+	return nil
+}
+
+// GetFileVersionsArchivedBefore returns the versions of all files which were archived before the given time.
+func (d *DB) GetFileVersionsArchivedBefore(ctx context.Context, before time.Time) ([]FileVersion, error) {
+	var versions []FileVersion
+	if err := d.dbx.SelectContext(ctx, &versions, "SELECT file_versions.*, users.username FROM file_versions LEFT JOIN users ON file_versions.user_id = users.id WHERE file_versions.archived_at < $1", before); err != nil {
+		return nil, fmt.Errorf("error getting archived file versions: %w", err)
+	}
+	return versions, nil
+}
+
+// TrashFile moves the file row into the trash. The versions of the file are moved to the given versions path so they can be restored together with the file.
+func (d *DB) TrashFile(ctx context.Context, id string, file File, deletedBy string, versionsPath string) (*TrashedFile, error) {
+	trashed := &TrashedFile{
+		ID:          id,
+		Path:        file.Path,
+		Size:        file.Size,
+		ContentType: file.ContentType,
+		Description: file.Description,
+		UserID:      file.UserID,
+		CreatedAt:   file.CreatedAt,
+		UpdatedAt:   file.UpdatedAt,
+		DeletedBy:   deletedBy,
+		DeletedAt:   time.Now(),
+	}
+
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM files WHERE path = $1", file.Path)
+		if err != nil {
+			return err
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return ErrFileNotFound
+		}
+		if _, err = tx.NamedExecContext(ctx, "INSERT INTO trash (id, path, size, content_type, description, user_id, created_at, updated_at, deleted_by, deleted_at) VALUES (:id, :path, :size, :content_type, :description, :user_id, :created_at, :updated_at, :deleted_by, :deleted_at)", trashed); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE file_versions SET path = $1 WHERE path = $2", versionsPath, file.Path)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error trashing file: %w", err)
+	}
+	return trashed, nil
+}
+
+// RestoreTrashedFile moves a trashed file back to its original path. It fails with ErrFileAlreadyExists if the path is taken by now.
+func (d *DB) RestoreTrashedFile(ctx context.Context, trashed TrashedFile, versionsPath string) error {
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM trash WHERE id = $1", trashed.ID)
+		if err != nil {
+			return err
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return ErrTrashNotFound
+		}
+		file := File{
+			Path:        trashed.Path,
+			Size:        trashed.Size,
+			ContentType: trashed.ContentType,
+			Description: trashed.Description,
+			UserID:      trashed.UserID,
+			CreatedAt:   trashed.CreatedAt,
+			UpdatedAt:   trashed.UpdatedAt,
+		}
+		if _, err = tx.NamedExecContext(ctx, "INSERT INTO files (path, size, content_type, description, user_id, created_at, updated_at) VALUES (:path, :size, :content_type, :description, :user_id, :created_at, :updated_at)", file); err != nil {
+			if isUniqueViolation(err) {
+				return ErrFileAlreadyExists
+			}
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE file_versions SET path = $1 WHERE path = $2", trashed.Path, versionsPath)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error restoring trashed file: %w", err)
+	}
+	return nil
+}
+
+// GetTrash returns the trashed files which were deleted by or belong to the given user. An empty user id returns all trashed files.
+func (d *DB) GetTrash(ctx context.Context, userID string) ([]TrashedFile, error) {
+	var (
+		trash []TrashedFile
+		err   error
+	)
+	if userID == "" {
+		err = d.dbx.SelectContext(ctx, &trash, "SELECT trash.*, users.username FROM trash LEFT JOIN users ON trash.user_id = users.id ORDER BY trash.deleted_at DESC")
+	} else {
+		err = d.dbx.SelectContext(ctx, &trash, "SELECT trash.*, users.username FROM trash LEFT JOIN users ON trash.user_id = users.id WHERE trash.deleted_by = $1 OR trash.user_id = $1 ORDER BY trash.deleted_at DESC", userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting trash: %w", err)
+	}
+	return trash, nil
+}
+
+func (d *DB) GetTrashedFile(ctx context.Context, id string) (*TrashedFile, error) {
+	trashed := new(TrashedFile)
+	if err := d.dbx.GetContext(ctx, trashed, "SELECT trash.*, users.username FROM trash LEFT JOIN users ON trash.user_id = users.id WHERE trash.id = $1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrTrashNotFound
+		}
+		return nil, fmt.Errorf("error getting trashed file: %w", err)
+	}
+	return trashed, nil
+}
+
+// GetExpiredTrash returns all trashed files which were deleted before the given time.
+func (d *DB) GetExpiredTrash(ctx context.Context, before time.Time) ([]TrashedFile, error) {
+	var trash []TrashedFile
+	if err := d.dbx.SelectContext(ctx, &trash, "SELECT trash.*, users.username FROM trash LEFT JOIN users ON trash.user_id = users.id WHERE trash.deleted_at < $1", before); err != nil {
+		return nil, fmt.Errorf("error getting expired trash: %w", err)
+	}
+	return trash, nil
+}
+
+func (d *DB) DeleteTrashedFile(ctx context.Context, id string) error {
+	res, err := d.dbx.ExecContext(ctx, "DELETE FROM trash WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting trashed file: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrTrashNotFound
+	}
+	return nil
+}
+
+// GetExpiredUploads returns all uploads which were not updated since the given time.
+func (d *DB) GetExpiredUploads(ctx context.Context, before time.Time) ([]Upload, error) {
+	var uploads []Upload
+	if err := d.dbx.SelectContext(ctx, &uploads, "SELECT * FROM uploads WHERE updated_at < $1", before); err != nil {
+		return nil, fmt.Errorf("error getting expired uploads: %w", err)
+	}
+	return uploads, nil
+}
+
+func (d *DB) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) (err error) {
+	tx, err := d.dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func isUniqueViolation(err error) bool {
+	var (
+		sqliteErr *sqlite.Error
+		pgErr     *pgconn.PgError
+	)
+	return (errors.As(err, &sqliteErr) && sqliteErr.Code() == 1555) || (errors.As(err, &pgErr) && pgErr.Code == "23505")
+}
+
+func (d *DB) CreateShare(ctx context.Context, share Share) (*Share, error) {
+	share.CreatedAt = time.Now()
+	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO shares (token, path, user_id, password, permission, max_downloads, downloads, expires_at, created_at) VALUES (:token, :path, :user_id, :password, :permission, :max_downloads, :downloads, :expires_at, :created_at)", share)
+	if err != nil {
+		return nil, fmt.Errorf("error creating share: %w", err)
+	}
+	return &share, nil
+}
+
+func (d *DB) GetShare(ctx context.Context, token string) (*Share, error) {
+	share := new(Share)
+	if err := d.dbx.GetContext(ctx, share, "SELECT shares.*, users.username FROM shares LEFT JOIN users ON shares.user_id = users.id WHERE shares.token = $1", token); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrShareNotFound
+		}
+		return nil, fmt.Errorf("error getting share: %w", err)
+	}
+	return share, nil
+}
+
+// GetShares returns all shares created by the given user. An empty user id returns all shares.
+func (d *DB) GetShares(ctx context.Context, userID string) ([]Share, error) {
+	var (
+		shares []Share
+		err    error
+	)
+	if userID == "" {
+		err = d.dbx.SelectContext(ctx, &shares, "SELECT shares.*, users.username FROM shares LEFT JOIN users ON shares.user_id = users.id ORDER BY shares.created_at DESC")
+	} else {
+		err = d.dbx.SelectContext(ctx, &shares, "SELECT shares.*, users.username FROM shares LEFT JOIN users ON shares.user_id = users.id WHERE shares.user_id = $1 ORDER BY shares.created_at DESC", userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting shares: %w", err)
+	}
+	return shares, nil
+}
+
+// IncrementShareDownloads counts a download of the share. It fails with ErrShareExhausted if the download limit is reached.
+func (d *DB) IncrementShareDownloads(ctx context.Context, token string) error {
+	res, err := d.dbx.ExecContext(ctx, "UPDATE shares SET downloads = downloads + 1 WHERE token = $1 AND (max_downloads = 0 OR downloads < max_downloads)", token)
+	if err != nil {
+		return fmt.Errorf("error updating share downloads: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrShareExhausted
+	}
+	return nil
+}
+
+func (d *DB) DeleteShare(ctx context.Context, token string) error {
+	res, err := d.dbx.ExecContext(ctx, "DELETE FROM shares WHERE token = $1", token)
+	if err != nil {
+		return fmt.Errorf("error deleting share: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+func (d *DB) DeleteExpiredShares(ctx context.Context, before time.Time) (int64, error) {
+	res, err := d.dbx.ExecContext(ctx, "DELETE FROM shares WHERE expires_at IS NOT NULL AND expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired shares: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (d *DB) GetACLs(ctx context.Context) ([]ACL, error) {
+	var acls []ACL
+	if err := d.dbx.SelectContext(ctx, &acls, "SELECT acls.*, users.username FROM acls LEFT JOIN users ON acls.subject_type = 'user' AND acls.subject = users.id ORDER BY acls.path, acls.subject_type, acls.subject"); err != nil {
+		return nil, fmt.Errorf("error getting acls: %w", err)
+	}
+	return acls, nil
+}
+
+// SetACL creates the entry or replaces the entry of the same subject on the same path.
+func (d *DB) SetACL(ctx context.Context, acl ACL) error {
+	return setACL(ctx, d.dbx, acl)
+}
+
+func setACL(ctx context.Context, e sqlx.ExtContext, acl ACL) error {
+	acl.CreatedAt = time.Now()
+	_, err := sqlx.NamedExecContext(ctx, e, "INSERT INTO acls (path, subject_type, subject, permission, deny, created_by, created_at) VALUES (:path, :subject_type, :subject, :permission, :deny, :created_by, :created_at) ON CONFLICT (path, subject_type, subject) DO UPDATE SET permission = :permission, deny = :deny, created_by = :created_by, created_at = :created_at", acl)
+	if err != nil {
+		return fmt.Errorf("error setting acl: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) DeleteACL(ctx context.Context, path string, subjectType ACLSubjectType, subject string) error {
+	res, err := d.dbx.ExecContext(ctx, "DELETE FROM acls WHERE path = $1 AND subject_type = $2 AND subject = $3", path, subjectType, subject)
+	if err != nil {
+		return fmt.Errorf("error deleting acl: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrACLNotFound
+	}
+	return nil
+}
+
+func (d *DB) CreateFolder(ctx context.Context, folder Folder) error {
+	folder.CreatedAt = time.Now()
+	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO folders (path, user_id, created_at) VALUES (:path, :user_id, :created_at) ON CONFLICT (path) DO NOTHING", folder)
+	if err != nil {
+		return fmt.Errorf("error creating folder: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) HasFolder(ctx context.Context, path string) (bool, error) {
+	var count int
+	if err := d.dbx.GetContext(ctx, &count, "SELECT COUNT(*) FROM folders WHERE path = $1", path); err != nil {
+		return false, fmt.Errorf("error getting folder: %w", err)
+	}
+	return count > 0, nil
+}
+
+// GetFolders returns the folders below path.
+func (d *DB) GetFolders(ctx context.Context, path string) ([]Folder, error) {
+	var folders []Folder
+	if err := d.dbx.SelectContext(ctx, &folders, "SELECT * FROM folders WHERE path LIKE $1 ORDER BY path", strings.TrimSuffix(path, "/")+"/%"); err != nil {
+		return nil, fmt.Errorf("error getting folders: %w", err)
+	}
+	return folders, nil
+}
+
+// MoveFolders moves the folder at path and the folders below it to newPath.
+func (d *DB) MoveFolders(ctx context.Context, path string, newPath string) error {
+	_, err := d.dbx.ExecContext(ctx, "UPDATE folders SET path = $2 || SUBSTR(path, LENGTH($1) + 1) WHERE path = $1 OR path LIKE $1 || '/%'", path, newPath)
+	if err != nil {
+		return fmt.Errorf("error moving folders: %w", err)
+	}
+	return nil
+}
+
+// DeleteFolders deletes the folder at path, the folders below it and the folders above it, which are no longer empty.
+func (d *DB) DeleteFolders(ctx context.Context, path string) error {
+	_, err := d.dbx.ExecContext(ctx, "DELETE FROM folders WHERE path = $1 OR path LIKE $1 || '/%' OR $1 LIKE path || '/%'", path)
+	if err != nil {
+		return fmt.Errorf("error deleting folders: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) CreateAuditEntry(ctx context.Context, entry AuditEntry) error {
+	if _, err := d.dbx.NamedExecContext(ctx, "INSERT INTO audit_log (id, user_id, username, action, target, details, created_at) VALUES (:id, :user_id, :username, :action, :target, :details, :created_at)", entry); err != nil {
+		return fmt.Errorf("error creating audit entry: %w", err)
+	}
+	return nil
+}
+
+// GetAuditEntries returns the latest limit audit entries, newest first.
+func (d *DB) GetAuditEntries(ctx context.Context, limit int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	if err := d.dbx.SelectContext(ctx, &entries, "SELECT * FROM audit_log ORDER BY created_at DESC LIMIT $1", limit); err != nil {
+		return nil, fmt.Errorf("error getting audit entries: %w", err)
+	}
+	return entries, nil
+}
+
+func (d *DB) GetSession(ctx context.Context, id string) (*Session, error) {
+	session := new(Session)
+	if err := d.dbx.GetContext(ctx, session, "SELECT * FROM sessions WHERE id = $1 AND expires_at > $2", id, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("error getting session: %w", err)
+	}
+	return session, nil
+}
+
+func (d *DB) SetSession(ctx context.Context, session Session) error {
+	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO sessions (id, user_id, access_token, expiry, refresh_token, id_token, expires_at) VALUES (:id, :user_id, :access_token, :expiry, :refresh_token, :id_token, :expires_at) ON CONFLICT (id) DO UPDATE SET user_id = :user_id, access_token = :access_token, expiry = :expiry, refresh_token = :refresh_token, id_token = :id_token, expires_at = :expires_at", session)
+	if err != nil {
+		return fmt.Errorf("error setting session: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) DeleteSession(ctx context.Context, id string) error {
+	if _, err := d.dbx.ExecContext(ctx, "DELETE FROM sessions WHERE id = $1", id); err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) DeleteUserSessions(ctx context.Context, userID string) error {
+	if _, err := d.dbx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("error deleting user sessions: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) SetState(ctx context.Context, state string, nonce string, expiresAt time.Time) error {
+	if _, err := d.dbx.ExecContext(ctx, "INSERT INTO oidc_states (state, nonce, expires_at) VALUES ($1, $2, $3)", state, nonce, expiresAt); err != nil {
+		return fmt.Errorf("error setting state: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) PopState(ctx context.Context, state string) (string, error) {
+	var nonce string
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, &nonce, "SELECT nonce FROM oidc_states WHERE state = $1 AND expires_at > $2", state, time.Now()); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrStateNotFound
+			}
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM oidc_states WHERE state = $1", state)
+		if err != nil {
+			return err
+		}
+		// another instance consumed the state concurrently
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return ErrStateNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error popping state: %w", err)
+	}
+	return nonce, nil
+}
+
+func (d *DB) DeleteExpiredSessions(ctx context.Context, before time.Time) error {
+	if _, err := d.dbx.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at < $1", before); err != nil {
+		return fmt.Errorf("error deleting expired sessions: %w", err)
+	}
+	if _, err := d.dbx.ExecContext(ctx, "DELETE FROM oidc_states WHERE expires_at < $1", before); err != nil {
+		return fmt.Errorf("error deleting expired states: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) CreateToken(ctx context.Context, token Token) (*Token, error) {
+	token.CreatedAt = time.Now()
+	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO tokens (id, user_id, name, hash, scope, expires_at, last_used_at, created_at) VALUES (:id, :user_id, :name, :hash, :scope, :expires_at, :last_used_at, :created_at)", token)
+	if err != nil {
+		return nil, fmt.Errorf("error creating token: %w", err)
+	}
+	return &token, nil
+}
+
+func (d *DB) GetTokenByHash(ctx context.Context, hash string) (*Token, error) {
+	token := new(Token)
+	if err := d.dbx.GetContext(ctx, token, "SELECT * FROM tokens WHERE hash = $1", hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("error getting token: %w", err)
+	}
+	return token, nil
+}
+
+func (d *DB) GetTokens(ctx context.Context, userID string) ([]Token, error) {
+	var tokens []Token
+	if err := d.dbx.SelectContext(ctx, &tokens, "SELECT * FROM tokens WHERE user_id = $1 ORDER BY created_at DESC", userID); err != nil {
+		return nil, fmt.Errorf("error getting tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (d *DB) UpdateTokenLastUsed(ctx context.Context, id string, lastUsedAt time.Time) error {
+	if _, err := d.dbx.ExecContext(ctx, "UPDATE tokens SET last_used_at = $1 WHERE id = $2", lastUsedAt, id); err != nil {
+		return fmt.Errorf("error updating token: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) DeleteToken(ctx context.Context, id string, userID string) error {
+	res, err := d.dbx.ExecContext(ctx, "DELETE FROM tokens WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting token: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// GetBlobRef returns the hash of the blob stored for a path.
+func (d *DB) GetBlobRef(ctx context.Context, filePath string) (string, error) {
+	var hash string
+	if err := d.dbx.GetContext(ctx, &hash, "SELECT hash FROM blob_refs WHERE path = $1", filePath); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrBlobRefNotFound
+		}
+		return "", fmt.Errorf("error getting blob ref: %w", err)
+	}
+	return hash, nil
+}
+
+func (d *DB) HasBlob(ctx context.Context, hash string) (bool, error) {
+	var count int
+	if err := d.dbx.GetContext(ctx, &count, "SELECT COUNT(*) FROM blobs WHERE hash = $1", hash); err != nil {
+		return false, fmt.Errorf("error getting blob: %w", err)
+	}
+	return count > 0, nil
+}
+
+// SetBlobRef points a path to a blob and increments its reference count.
+// If the path pointed to another blob which is no longer referenced, its hash is returned so the blob can be deleted.
+func (d *DB) SetBlobRef(ctx context.Context, filePath string, hash string, size uint64) (string, error) {
+	var released string
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO blobs (hash, size, ref_count, created_at) VALUES ($1, $2, 1, $3) ON CONFLICT (hash) DO UPDATE SET ref_count = blobs.ref_count + 1", hash, size, time.Now()); err != nil {
+			return err
+		}
+		var err error
+		if released, err = releaseBlobRef(ctx, tx, filePath); err != nil && !errors.Is(err, ErrBlobRefNotFound) {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO blob_refs (path, hash) VALUES ($1, $2)", filePath, hash)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error setting blob ref: %w", err)
+	}
+	return released, nil
+}
+
+// MoveBlobRef moves the reference of a path to another path. A blob no longer referenced by the destination is returned like in SetBlobRef.
+func (d *DB) MoveBlobRef(ctx context.Context, from string, to string) (string, error) {
+	var released string
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		var hash string
+		if err := tx.GetContext(ctx, &hash, "SELECT hash FROM blob_refs WHERE path = $1", from); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrBlobRefNotFound
+			}
+			return err
+		}
+		var err error
+		if released, err = releaseBlobRef(ctx, tx, to); err != nil && !errors.Is(err, ErrBlobRefNotFound) {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE blob_refs SET path = $1 WHERE path = $2", to, from)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error moving blob ref: %w", err)
+	}
+	return released, nil
+}
+
+// CopyBlobRef points another path to the blob of a path. A blob no longer referenced by the destination is returned like in SetBlobRef.
+func (d *DB) CopyBlobRef(ctx context.Context, from string, to string) (string, error) {
+	var released string
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		var hash string
+		if err := tx.GetContext(ctx, &hash, "SELECT hash FROM blob_refs WHERE path = $1", from); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrBlobRefNotFound
+			}
+			return err
+		}
+		// increment first, so the blob is not released if the destination already references it
+		if _, err := tx.ExecContext(ctx, "UPDATE blobs SET ref_count = ref_count + 1 WHERE hash = $1", hash); err != nil {
+			return err
+		}
+		var err error
+		if released, err = releaseBlobRef(ctx, tx, to); err != nil && !errors.Is(err, ErrBlobRefNotFound) {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO blob_refs (path, hash) VALUES ($1, $2)", to, hash)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error copying blob ref: %w", err)
+	}
+	return released, nil
+}
+
+// DeleteBlobRef removes the reference of a path. A blob no longer referenced is returned like in SetBlobRef.
+func (d *DB) DeleteBlobRef(ctx context.Context, filePath string) (string, error) {
+	var released string
+	err := d.inTx(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		released, err = releaseBlobRef(ctx, tx, filePath)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error deleting blob ref: %w", err)
+	}
+	return released, nil
+}
+
+// releaseBlobRef deletes the reference of a path if there is one and decrements the reference count of its blob.
+// The hash of the blob is returned if it is no longer referenced and was deleted.
+func releaseBlobRef(ctx context.Context, tx *sqlx.Tx, filePath string) (string, error) {
+	var hash string
+	if err := tx.GetContext(ctx, &hash, "DELETE FROM blob_refs WHERE path = $1 RETURNING hash", filePath); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrBlobRefNotFound
+		}
+		return "", err
+	}
+
+	var refCount int64
+	if err := tx.GetContext(ctx, &refCount, "UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = $1 RETURNING ref_count", hash); err != nil {
+		return "", err
+	}
+	if refCount > 0 {
+		return "", nil
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM blobs WHERE hash = $1", hash); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// GetBlobRefs returns all paths referencing a blob.
+func (d *DB) GetBlobRefs(ctx context.Context) ([]BlobRef, error) {
+	var refs []BlobRef
+	if err := d.dbx.SelectContext(ctx, &refs, "SELECT * FROM blob_refs"); err != nil {
+		return nil, fmt.Errorf("error getting blob refs: %w", err)
+	}
+	return refs, nil
+}
+
+// GetMigratedObjects returns all objects copied to target.
+func (d *DB) GetMigratedObjects(ctx context.Context, target string) ([]MigratedObject, error) {
+	var objects []MigratedObject
+	if err := d.dbx.SelectContext(ctx, &objects, "SELECT * FROM storage_migrations WHERE target = $1", target); err != nil {
+		return nil, fmt.Errorf("error getting migrated objects: %w", err)
+	}
+	return objects, nil
+}
+
+func (d *DB) SetMigratedObject(ctx context.Context, object MigratedObject) error {
+	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO storage_migrations (target, path, size, source_etag, checksum, migrated_at) VALUES (:target, :path, :size, :source_etag, :checksum, :migrated_at) ON CONFLICT (target, path) DO UPDATE SET size = :size, source_etag = :source_etag, checksum = :checksum, migrated_at = :migrated_at", object)
+	if err != nil {
+		return fmt.Errorf("error setting migrated object: %w", err)
+	}
+	return nil
+}
+
+// GetExpectedObjects returns all objects godrive may have stored: files, versions, trashed files, chunks of unfinished uploads and blobs.
+// Depending on the storage configuration not all of them exist, files for example are only blob refs if deduplication is enabled.
+func (d *DB) GetExpectedObjects(ctx context.Context) ([]ExpectedObject, error) {
+	var objects []ExpectedObject
+	var files []File
+	if err := d.dbx.SelectContext(ctx, &files, "SELECT path, size FROM files"); err != nil {
+		return nil, fmt.Errorf("error getting files: %w", err)
+	}
+	for _, file := range files {
+		size := file.Size
+		objects = append(objects, ExpectedObject{Path: file.Path, Kind: ObjectKindFile, Key: file.Path, Size: &size})
+	}
+
+	var versions []FileVersion
+	if err := d.dbx.SelectContext(ctx, &versions, "SELECT id, size FROM file_versions"); err != nil {
+		return nil, fmt.Errorf("error getting file versions: %w", err)
+	}
+	for _, version := range versions {
+		size := version.Size
+		objects = append(objects, ExpectedObject{Path: fileVersionPath(version.ID), Kind: ObjectKindVersion, Key: version.ID, Size: &size})
+	}
+
+	var trash []TrashedFile
+	if err := d.dbx.SelectContext(ctx, &trash, "SELECT id, size FROM trash"); err != nil {
+		return nil, fmt.Errorf("error getting trash: %w", err)
+	}
+	for _, trashed := range trash {
+		size := trashed.Size
+		objects = append(objects, ExpectedObject{Path: trashPath(trashed.ID), Kind: ObjectKindTrash, Key: trashed.ID, Size: &size})
+	}
+
+	var uploads []Upload
+	if err := d.dbx.SelectContext(ctx, &uploads, "SELECT * FROM uploads"); err != nil {
+		return nil, fmt.Errorf("error getting uploads: %w", err)
+	}
+	for _, upload := range uploads {
+		for chunk := 0; chunk < upload.Chunks; chunk++ {
+			objects = append(objects, ExpectedObject{Path: uploadChunkPath(upload.ID, chunk), Kind: ObjectKindUpload, Key: upload.ID})
+		}
+	}
+
+	var blobs []struct {
+		Hash string `db:"hash"`
+		Size uint64 `db:"size"`
+	}
+	if err := d.dbx.SelectContext(ctx, &blobs, "SELECT hash, size FROM blobs"); err != nil {
+		return nil, fmt.Errorf("error getting blobs: %w", err)
+	}
+	for _, blob := range blobs {
+		size := blob.Size
+		objects = append(objects, ExpectedObject{Path: blobPath(blob.Hash), Kind: ObjectKindBlob, Key: blob.Hash, Size: &size})
+	}
+	return objects, nil
+}
+
+// UpdateObjectSize corrects the size of the file, version or trashed file of an object.
+func (d *DB) UpdateObjectSize(ctx context.Context, object ExpectedObject, size uint64) error {
+	var query string
+	switch object.Kind {
+	case ObjectKindFile:
+		query = "UPDATE files SET size = $1 WHERE path = $2"
+	case ObjectKindVersion:
+		query = "UPDATE file_versions SET size = $1 WHERE id = $2"
+	case ObjectKindTrash:
+		query = "UPDATE trash SET size = $1 WHERE id = $2"
+	case ObjectKindBlob:
+		query = "UPDATE blobs SET size = $1 WHERE hash = $2"
+	default:
+		return fmt.Errorf("objects of kind %s have no size", object.Kind)
+	}
+	if _, err := d.dbx.ExecContext(ctx, query, size, object.Key); err != nil {
+		return fmt.Errorf("error updating object size: %w", err)
+	}
+	return nil
+}
@@ -17,15 +17,15 @@ import (
 )
 
 func (s *Server) GetFiles(w http.ResponseWriter, r *http.Request) {
-	var (
-		download    bool
-		filesFilter []string
-	)
-	if dl := r.URL.Query().Get("dl"); dl != "" && dl != "0" && strings.ToLower(dl) != "false" {
-		download = true
-		if dl != "1" && strings.ToLower(dl) != "true" {
-			filesFilter = strings.Split(dl, ",")
-		}
+	download, filesFilter := parseDownload(r)
+
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Path == "/" && !download && a.confineWrites && a.home != "" {
+		http.Redirect(w, r, a.home, http.StatusFound)
+		return
 	}
 
 	files, err := s.db.FindFiles(r.Context(), r.URL.Path)
@@ -33,41 +33,20 @@ func (s *Server) GetFiles(w http.ResponseWriter, r *http.Request) {
 		s.error(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	files = a.readable(files)
 
 	if download && len(files) == 0 {
 		s.notFound(w, r)
 		return
 	}
-	if r.URL.Path != "/" && len(files) == 0 {
+	// the home folder exists even while it is empty
+	if r.URL.Path != "/" && r.URL.Path != a.home && len(files) == 0 {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
-	userInfo := GetUserInfo(r)
 	if len(files) == 1 && files[0].Path == r.URL.Path {
-		start, end, err := parseRange(r.Header.Get("Range"))
-		if err != nil {
-			s.error(w, r, err, http.StatusRequestedRangeNotSatisfiable)
-			return
-		}
-		file := files[0]
-		if download {
-			w.Header().Set("Content-Disposition", "attachment; filename="+path.Base(file.Path))
-		}
-		w.Header().Set("Content-Type", file.ContentType)
-		w.Header().Set("Content-Length", strconv.FormatUint(file.Size, 10))
-		w.Header().Set("Accept-Ranges", "bytes")
-		if start != nil || end != nil {
-			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.Size))
-			w.WriteHeader(http.StatusPartialContent)
-		}
-		if r.Method == http.MethodHead {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		if err = s.writeFile(r.Context(), w, file.Path, start, end); err != nil {
-			slog.ErrorCtx(r.Context(), "Failed to write file", slog.Any("err", err))
-		}
+		s.serveFile(w, r, files[0], download)
 		return
 	}
 
@@ -76,63 +55,126 @@ func (s *Server) GetFiles(w http.ResponseWriter, r *http.Request) {
 		if zipName == "/" || zipName == "." {
 			zipName = "godrive"
 		}
-		w.Header().Set("Content-Disposition", "attachment; filename="+zipName+".zip")
+		s.serveZip(w, r, zipName, files, r.URL.Path, "/", filesFilter)
+		return
+	}
 
-		zw := zip.NewWriter(w)
-		defer zw.Close()
+	userInfo := GetUserInfo(r)
+	templateFiles := toTemplateFiles(files, r.URL.Path, a.canModify)
 
-		rPath := r.URL.Path
-		if !strings.HasSuffix(rPath, "/") {
-			rPath += "/"
-		}
+	vars := IndexVariables{
+		BaseVariables: BaseVariables{
+			Theme: "dark",
+			Auth:  s.cfg.Auth != nil,
+			User:  s.ToTemplateUser(userInfo),
+			Quota: s.templateQuota(r.Context(), userInfo),
+		},
+		Path:           r.URL.Path,
+		PathParts:      strings.FieldsFunc(r.URL.Path, func(r rune) bool { return r == '/' }),
+		Files:          templateFiles,
+		PresignUploads: s.cfg.Storage.Presign != nil && s.cfg.Storage.Presign.Uploads,
+		CanUpload:      a.canUpload(r.URL.Path),
+		CanManage:      a.canManage(r.URL.Path),
+	}
+	if err = s.tmpl(w, "index.gohtml", vars); err != nil {
+		slog.ErrorCtx(r.Context(), "error executing template", slog.Any("err", err))
+	}
+}
 
-		var addedFiles int
-		for _, file := range files {
-			if len(filesFilter) > 0 && !slices.Contains(filesFilter, strings.SplitN(strings.TrimPrefix(file.Path, rPath), "/", 2)[0]) {
-				continue
-			}
-			fw, err := zw.CreateHeader(&zip.FileHeader{
-				Name:               strings.TrimPrefix(file.Path, "/"),
-				UncompressedSize64: file.Size,
-				Modified:           file.UpdatedAt,
-				Comment:            file.Description,
-				Method:             zip.Deflate,
-			})
-			addedFiles++
-			if err != nil {
-				s.error(w, r, err, http.StatusInternalServerError)
-				return
-			}
-			if err = s.writeFile(r.Context(), fw, file.Path, nil, nil); err != nil {
-				s.error(w, r, err, http.StatusInternalServerError)
-				return
-			}
+// serveFile writes the content of a single file, honoring range requests. With presigned downloads the client is redirected to the storage instead.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, file File, download bool) {
+	if presigner, ok := s.presigner(); ok && s.cfg.Storage.Presign.Downloads && r.Method != http.MethodHead {
+		s.redirectPresigned(w, r, presigner, file, download)
+		return
+	}
+	start, end, err := parseRange(r.Header.Get("Range"))
+	if err != nil {
+		s.error(w, r, err, http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if download {
+		w.Header().Set("Content-Disposition", "attachment; filename="+path.Base(file.Path))
+	}
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatUint(file.Size, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if start != nil || end != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err = s.writeFile(r.Context(), w, file.Path, start, end); err != nil {
+		slog.ErrorCtx(r.Context(), "Failed to write file", slog.Any("err", err))
+	}
+}
+
+// serveZip writes all files below dir as zip archive. Entry names are relative to root and filter limits the archive to the given top level names below dir.
+func (s *Server) serveZip(w http.ResponseWriter, r *http.Request, name string, files []File, dir string, root string, filter []string) {
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+
+	var filtered []File
+	for _, file := range files {
+		if len(filter) > 0 && !slices.Contains(filter, strings.SplitN(strings.TrimPrefix(file.Path, dir), "/", 2)[0]) {
+			continue
 		}
-		if addedFiles == 0 {
-			s.notFound(w, r)
+		filtered = append(filtered, file)
+	}
+	if len(filtered) == 0 {
+		s.notFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename="+name+".zip")
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	for _, file := range filtered {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:               strings.TrimPrefix(file.Path, root),
+			UncompressedSize64: file.Size,
+			Modified:           file.UpdatedAt,
+			Comment:            file.Description,
+			Method:             zip.Deflate,
+		})
+		if err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
 			return
 		}
-		if err = zw.SetComment("Generated by godrive"); err != nil {
+		if err = s.writeFile(r.Context(), fw, file.Path, nil, nil); err != nil {
 			s.error(w, r, err, http.StatusInternalServerError)
 			return
 		}
-		return
 	}
+	if err := zw.SetComment("Generated by godrive"); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+	}
+}
 
+// toTemplateFiles groups the files below dir into the direct children of dir, summarizing subdirectories.
+func toTemplateFiles(files []File, dir string, isOwnerFunc func(file File) bool) []TemplateFile {
 	var templateFiles []TemplateFile
 	for _, file := range files {
 		owner := "Unknown"
 		if file.Username != nil {
 			owner = *file.Username
 		}
-		isOwner := file.UserID == userInfo.Subject || s.isAdmin(userInfo)
+		isOwner := isOwnerFunc(file)
 		date := file.CreatedAt
 		if file.UpdatedAt.After(date) {
 			date = file.UpdatedAt
 		}
 
-		if dir := strings.TrimPrefix(path.Dir(file.Path), r.URL.Path); dir != "" {
-			baseDir := strings.TrimPrefix(dir, "/")
+		if subDir := strings.TrimPrefix(path.Dir(file.Path), dir); subDir != "" {
+			baseDir := strings.TrimPrefix(subDir, "/")
 			if strings.Count(baseDir, "/") > 0 {
 				baseDir = strings.SplitN(baseDir, "/", 2)[0]
 			}
@@ -142,8 +184,8 @@ func (s *Server) GetFiles(w http.ResponseWriter, r *http.Request) {
 			if index == -1 {
 				templateFiles = append(templateFiles, TemplateFile{
 					IsDir:   true,
-					Path:    path.Join(r.URL.Path, baseDir),
-					Dir:     r.URL.Path,
+					Path:    path.Join(dir, baseDir),
+					Dir:     dir,
 					Name:    baseDir,
 					Size:    file.Size,
 					Date:    date,
@@ -174,42 +216,47 @@ func (s *Server) GetFiles(w http.ResponseWriter, r *http.Request) {
 			Description: file.Description,
 			Date:        date,
 			Owner:       owner,
-			IsOwner:     file.UserID == userInfo.Subject || s.isAdmin(userInfo),
+			IsOwner:     isOwner,
 		})
 	}
-
-	vars := IndexVariables{
-		BaseVariables: BaseVariables{
-			Theme: "dark",
-			Auth:  s.cfg.Auth != nil,
-			User:  s.ToTemplateUser(userInfo),
-		},
-		Path:      r.URL.Path,
-		PathParts: strings.FieldsFunc(r.URL.Path, func(r rune) bool { return r == '/' }),
-		Files:     templateFiles,
-	}
-	if err = s.tmpl(w, "index.gohtml", vars); err != nil {
-		slog.ErrorCtx(r.Context(), "error executing template", slog.Any("err", err))
-	}
+	return templateFiles
 }
 
 func (s *Server) PostFile(w http.ResponseWriter, r *http.Request) {
-	file, err := s.parseMultipartBody(r)
+	file, err := s.parseMultipartBody(r, r.URL.Path)
 	if err != nil {
-		s.error(w, r, err, http.StatusInternalServerError)
+		s.uploadError(w, r, err)
 		return
 	}
 
 	userInfo := GetUserInfo(r)
 
 	defer file.Content.Close()
-	if err = s.storage.PutObject(r.Context(), file.Path, file.Size, file.Content, file.ContentType); err != nil {
-		s.error(w, r, err, http.StatusInternalServerError)
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
 		return
 	}
-
+	if !a.canUpload(file.Path) {
+		s.error(w, r, uploadDenied(file.Path), http.StatusForbidden)
+		return
+	}
+	if err = s.cfg.Upload.check(file.Path, file.Size, file.ContentType); err != nil {
+		s.uploadError(w, r, err)
+		return
+	}
+	if !s.hasQuota(w, r, userInfo.Subject, file.Size) {
+		return
+	}
+	// create the file first, so an existing file is never overwritten
 	if _, err = s.db.CreateFile(r.Context(), file.Path, file.Size, file.ContentType, file.Description, userInfo.Subject); err != nil {
-		s.error(w, r, err, http.StatusInternalServerError)
+		s.uploadError(w, r, err)
+		return
+	}
+	if err = s.storage.PutObject(r.Context(), file.Path, file.Size, file.Content, file.ContentType); err != nil {
+		if dbErr := s.db.DeleteFile(r.Context(), file.Path); dbErr != nil {
+			slog.ErrorCtx(r.Context(), "failed to delete file after failed upload", slog.String("path", file.Path), slog.Any("err", dbErr))
+		}
+		s.uploadError(w, r, err)
 		return
 	}
 
@@ -217,40 +264,75 @@ func (s *Server) PostFile(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) PatchFile(w http.ResponseWriter, r *http.Request) {
-	file, err := s.parseMultipartBody(r)
+	file, err := s.parseMultipartBody(r, r.URL.Path)
 	if err != nil {
-		s.error(w, r, err, http.StatusInternalServerError)
+		s.uploadError(w, r, err)
 		return
 	}
 
+	defer file.Content.Close()
 	dbFile, err := s.db.GetFile(r.Context(), r.URL.Path)
 	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return
+		}
 		s.error(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	userInfo := GetUserInfo(r)
-	if !s.hasFileAccess(userInfo, *dbFile) {
-		s.error(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	if !a.canModify(*dbFile) {
+		s.error(w, r, modifyDenied(dbFile.Path), http.StatusForbidden)
+		return
+	}
+	if file.Path != dbFile.Path && !a.canUpload(file.Path) {
+		s.error(w, r, uploadDenied(file.Path), http.StatusForbidden)
 		return
 	}
 
-	defer file.Content.Close()
-	if err = s.db.UpdateFile(r.Context(), r.URL.Path, file.Path, file.Size, file.ContentType, file.Description); err != nil {
-		s.error(w, r, err, http.StatusInternalServerError)
+	if file.Size > 0 {
+		err = s.cfg.Upload.check(file.Path, file.Size, file.ContentType)
+	} else {
+		err = s.cfg.Upload.checkName(file.Path)
+	}
+	if err != nil {
+		s.uploadError(w, r, err)
 		return
 	}
+	var version *FileVersion
 	if file.Size > 0 {
-		if err = s.storage.PutObject(r.Context(), file.Path, file.Size, file.Content, file.ContentType); err != nil {
+		// the old content is kept as version and counts towards the quota of the owner like the new content
+		if !s.hasQuota(w, r, dbFile.UserID, file.Size) {
+			return
+		}
+		if version, err = s.archiveFile(r.Context(), *dbFile); err != nil {
 			s.error(w, r, err, http.StatusInternalServerError)
 			return
 		}
-		if r.URL.Path != file.Path {
-			if err = s.storage.DeleteObject(r.Context(), r.URL.Path); err != nil {
-				s.error(w, r, err, http.StatusInternalServerError)
-				return
+	}
+	if err = s.db.UpdateFile(r.Context(), r.URL.Path, file.Path, file.Size, file.ContentType, file.Description); err != nil {
+		if version != nil {
+			s.unarchiveFile(r.Context(), *version)
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if file.Size > 0 {
+		if err = s.storage.PutObject(r.Context(), file.Path, file.Size, file.Content, file.ContentType); err != nil {
+			// restore the row first, which also moves the versions back to the old path
+			if dbErr := s.db.UpdateFile(r.Context(), file.Path, dbFile.Path, dbFile.Size, dbFile.ContentType, dbFile.Description); dbErr != nil {
+				slog.ErrorCtx(r.Context(), "failed to restore file after failed upload", slog.String("path", dbFile.Path), slog.Any("err", dbErr))
+			} else {
+				s.unarchiveFile(r.Context(), *version)
 			}
+			s.uploadError(w, r, err)
+			return
 		}
+		s.pruneFileVersions(r.Context(), file.Path)
 	} else if r.URL.Path != file.Path {
 		if err = s.storage.MoveObject(r.Context(), r.URL.Path, file.Path); err != nil {
 			s.error(w, r, err, http.StatusInternalServerError)
@@ -271,6 +353,10 @@ func (s *Server) MoveFiles(w http.ResponseWriter, r *http.Request) {
 		s.error(w, r, errors.New("source and destination path can not be the same"), http.StatusBadRequest)
 		return
 	}
+	if isReservedPath(destination) {
+		s.error(w, r, ErrReservedPath, http.StatusBadRequest)
+		return
+	}
 
 	// which files/folders in r.URL.Path should be moved
 	var fileNames []string
@@ -289,11 +375,22 @@ func (s *Server) MoveFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userInfo := GetUserInfo(r)
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
 	// move specific file
 	if len(files) == 1 && files[0].Path == r.URL.Path {
-		if !s.hasFileAccess(userInfo, files[0]) {
-			s.error(w, r, fmt.Errorf("unauthorized to move file: %s", files[0].Path), http.StatusUnauthorized)
+		if !a.canModify(files[0]) {
+			s.error(w, r, modifyDenied(files[0].Path), http.StatusForbidden)
+			return
+		}
+		if !a.canUpload(destination) {
+			s.error(w, r, uploadDenied(destination), http.StatusForbidden)
+			return
+		}
+		if err = s.cfg.Upload.checkName(destination); err != nil {
+			s.uploadError(w, r, err)
 			return
 		}
 		if err = s.db.UpdateFile(r.Context(), files[0].Path, destination, 0, "", files[0].Description); err != nil {
@@ -323,11 +420,15 @@ func (s *Server) MoveFiles(w http.ResponseWriter, r *http.Request) {
 		if len(fileNames) > 0 && !slices.Contains(fileNames, strings.SplitN(rFilePath, "/", 2)[0]) {
 			continue
 		}
-		if !s.hasFileAccess(userInfo, file) {
+		if !a.canModify(file) {
 			warns = append(warns, fmt.Sprintf("unauthorized to move file: %s", file.Path))
 			continue
 		}
 		newPath := path.Join(destination, rFilePath)
+		if !a.canUpload(newPath) {
+			warns = append(warns, uploadDenied(newPath).Error())
+			continue
+		}
 		if err = s.db.UpdateFile(r.Context(), file.Path, newPath, 0, "", file.Description); err != nil {
 			errs = errors.Join(errs, err)
 			continue
@@ -349,7 +450,24 @@ func (s *Server) MoveFiles(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *Server) DeleteFiles(w http.ResponseWriter, r *http.Request) {
+// CopyFiles copies a file or the contents of a folder to the folder in the Destination header like MoveFiles.
+// Everyone who can see a file can copy it, the copies are owned by the user copying them.
+func (s *Server) CopyFiles(w http.ResponseWriter, r *http.Request) {
+	destination := r.Header.Get("Destination")
+	if destination == "" {
+		s.error(w, r, errors.New("missing destination header"), http.StatusBadRequest)
+		return
+	}
+	if destination == r.URL.Path {
+		s.error(w, r, errors.New("source and destination path can not be the same"), http.StatusBadRequest)
+		return
+	}
+	if isReservedPath(destination) {
+		s.error(w, r, ErrReservedPath, http.StatusBadRequest)
+		return
+	}
+
+	// which files/folders in r.URL.Path should be copied
 	var fileNames []string
 	if err := json.NewDecoder(r.Body).Decode(&fileNames); err != nil && err != io.EOF {
 		s.error(w, r, err, http.StatusBadRequest)
@@ -358,26 +476,41 @@ func (s *Server) DeleteFiles(w http.ResponseWriter, r *http.Request) {
 
 	files, err := s.db.FindFiles(r.Context(), r.URL.Path)
 	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	files = a.readable(files)
+
 	if len(files) == 0 {
 		s.error(w, r, errors.New("file not found"), http.StatusNotFound)
 		return
 	}
 
 	userInfo := GetUserInfo(r)
-	// delete specific file
+	// copy specific file
 	if len(files) == 1 && files[0].Path == r.URL.Path {
-		if !s.hasFileAccess(userInfo, files[0]) {
-			s.error(w, r, fmt.Errorf("unauthorized to delete file: %s", files[0].Path), http.StatusUnauthorized)
+		if !a.canUpload(destination) {
+			s.error(w, r, uploadDenied(destination), http.StatusForbidden)
 			return
 		}
-		if err = s.db.DeleteFile(r.Context(), files[0].Path); err != nil {
-			s.error(w, r, err, http.StatusInternalServerError)
+		if err = s.cfg.Upload.checkName(destination); err != nil {
+			s.uploadError(w, r, err)
 			return
 		}
-		if err = s.storage.DeleteObject(r.Context(), files[0].Path); err != nil {
+		if err = s.copyFile(r.Context(), files[0], destination, userInfo); err != nil {
+			if errors.Is(err, ErrFileAlreadyExists) {
+				s.error(w, r, err, http.StatusConflict)
+				return
+			}
+			if errors.Is(err, ErrQuotaExceeded) {
+				s.error(w, r, err, http.StatusInsufficientStorage)
+				return
+			}
 			s.error(w, r, err, http.StatusInternalServerError)
 			return
 		}
@@ -385,27 +518,120 @@ func (s *Server) DeleteFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// copy multiple files or folders
 	rPath := r.URL.Path
 	if !strings.HasSuffix(rPath, "/") {
 		rPath += "/"
 	}
+
 	var (
 		errs  error
 		warns []string
 	)
 	for _, file := range files {
-		if len(fileNames) > 0 && !slices.Contains(fileNames, strings.SplitN(strings.TrimPrefix(file.Path, rPath), "/", 2)[0]) {
+		rFilePath := strings.TrimPrefix(file.Path, rPath)
+		if len(fileNames) > 0 && !slices.Contains(fileNames, strings.SplitN(rFilePath, "/", 2)[0]) {
 			continue
 		}
-		if !s.hasFileAccess(userInfo, file) {
-			warns = append(warns, fmt.Sprintf("unauthorized to delete file: %s", file.Path))
+		newPath := path.Join(destination, rFilePath)
+		if !a.canUpload(newPath) {
+			warns = append(warns, uploadDenied(newPath).Error())
 			continue
 		}
-		if err = s.db.DeleteFile(r.Context(), file.Path); err != nil {
+		if err = s.copyFile(r.Context(), file, newPath, userInfo); err != nil {
+			if errors.Is(err, ErrFileAlreadyExists) {
+				warns = append(warns, fmt.Sprintf("file already exists: %s", newPath))
+				continue
+			}
+			if errors.Is(err, ErrQuotaExceeded) {
+				warns = append(warns, fmt.Sprintf("%s: %s", ErrQuotaExceeded, newPath))
+				continue
+			}
 			errs = errors.Join(errs, err)
+		}
+	}
+	if errs != nil {
+		s.error(w, r, errs, http.StatusInternalServerError)
+		return
+	}
+	if len(warns) > 0 {
+		s.warn(w, r, strings.Join(warns, ", "), http.StatusMultiStatus)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// copyFile creates the database row first, so an existing file at newPath is never overwritten.
+func (s *Server) copyFile(ctx context.Context, file File, newPath string, userInfo *UserInfo) error {
+	if err := s.checkQuota(ctx, userInfo.Subject, file.Size); err != nil {
+		return err
+	}
+	if _, err := s.db.CreateFile(ctx, newPath, file.Size, file.ContentType, file.Description, userInfo.Subject); err != nil {
+		return err
+	}
+	if err := s.storage.CopyObject(ctx, file.Path, newPath); err != nil {
+		if dbErr := s.db.DeleteFile(ctx, newPath); dbErr != nil {
+			slog.ErrorCtx(ctx, "failed to delete file after failed copy", slog.String("path", newPath), slog.Any("err", dbErr))
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Server) DeleteFiles(w http.ResponseWriter, r *http.Request) {
+	var fileNames []string
+	if err := json.NewDecoder(r.Body).Decode(&fileNames); err != nil && err != io.EOF {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	files, err := s.db.FindFiles(r.Context(), r.URL.Path)
+	if err != nil {
+		return
+	}
+
+	if len(files) == 0 {
+		s.error(w, r, errors.New("file not found"), http.StatusNotFound)
+		return
+	}
+
+	userInfo := GetUserInfo(r)
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	// delete specific file
+	if len(files) == 1 && files[0].Path == r.URL.Path {
+		if !a.canModify(files[0]) {
+			s.error(w, r, modifyDenied(files[0].Path), http.StatusForbidden)
+			return
+		}
+		if err = s.trashFile(r.Context(), files[0], userInfo); err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rPath := r.URL.Path
+	if !strings.HasSuffix(rPath, "/") {
+		rPath += "/"
+	}
+	var (
+		errs  error
+		warns []string
+	)
+	for _, file := range files {
+		if len(fileNames) > 0 && !slices.Contains(fileNames, strings.SplitN(strings.TrimPrefix(file.Path, rPath), "/", 2)[0]) {
 			continue
 		}
-		if err = s.storage.DeleteObject(r.Context(), file.Path); err != nil {
+		if !a.canModify(file) {
+			warns = append(warns, fmt.Sprintf("unauthorized to delete file: %s", file.Path))
+			continue
+		}
+		if err = s.trashFile(r.Context(), file, userInfo); err != nil {
 			errs = errors.Join(errs, err)
 			continue
 		}
@@ -441,54 +667,81 @@ type parsedFile struct {
 	Content     io.ReadCloser
 }
 
-func (s *Server) parseMultipartBody(r *http.Request) (*parsedFile, error) {
+// parseMultipartBody reads the json and file parts of an upload into dir. PATCH requests use the directory from the json part instead.
+func (s *Server) parseMultipartBody(r *http.Request, dir string) (*parsedFile, error) {
 	mr, err := r.MultipartReader()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidUpload, err)
 	}
 
 	part, err := mr.NextPart()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidUpload, err)
 	}
 
 	if part.FormName() != "json" {
-		return nil, errors.New("json field not found")
+		return nil, fmt.Errorf("%w: json field not found", ErrInvalidUpload)
 	}
 
 	var file FileRequest
 	if err = json.NewDecoder(part).Decode(&file); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: invalid json field: %w", ErrInvalidUpload, err)
 	}
 
 	part, err = mr.NextPart()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidUpload, err)
 	}
 
 	if part.FormName() != "file" {
-		return nil, errors.New("file field not found")
-	}
-
-	contentType := part.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream"
+		return nil, fmt.Errorf("%w: file field not found", ErrInvalidUpload)
 	}
 
-	dir := r.URL.Path
 	if r.Method == http.MethodPatch {
 		dir = file.Dir
 	}
 
+	filePath := path.Join(dir, part.FileName())
+	if isReservedPath(filePath) {
+		return nil, ErrReservedPath
+	}
+
+	// the content type of the part is chosen by the client, so it is detected from the content instead
+	contentType, content, err := sniffContentType(part, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidUpload, err)
+	}
+
 	return &parsedFile{
-		Path:        path.Join(dir, part.FileName()),
+		Path:        filePath,
 		Description: file.Description,
 		Size:        file.Size,
 		ContentType: contentType,
-		Content:     part,
+		Content: &partReader{
+			Reader: newSizeReader(content, file.Size),
+			Closer: part,
+		},
 	}, nil
 }
 
+// partReader reads the content of a multipart part through the readers checking it.
+type partReader struct {
+	io.Reader
+	io.Closer
+}
+
+// parseDownload reads the dl query parameter. It is either a boolean or a comma separated list of file names to download.
+func parseDownload(r *http.Request) (bool, []string) {
+	dl := r.URL.Query().Get("dl")
+	if dl == "" || dl == "0" || strings.ToLower(dl) == "false" {
+		return false, nil
+	}
+	if dl == "1" || strings.ToLower(dl) == "true" {
+		return true, nil
+	}
+	return true, strings.Split(dl, ",")
+}
+
 func parseRange(rangeHeader string) (*int64, *int64, error) {
 	if rangeHeader == "" {
 		return nil, nil, nil
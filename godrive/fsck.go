@@ -0,0 +1,249 @@
+package godrive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// fsckGracePeriod skips objects modified recently, since files are written to the storage before their database row is created.
+const fsckGracePeriod = 10 * time.Minute
+
+var ErrMissingImportOwner = errors.New("importing files requires an owner")
+
+type ObjectKind string
+
+const (
+	ObjectKindFile     ObjectKind = "file"
+	ObjectKindVersion  ObjectKind = "version"
+	ObjectKindTrash    ObjectKind = "trash"
+	ObjectKindUpload   ObjectKind = "upload"
+	ObjectKindBlob     ObjectKind = "blob"
+	ObjectKindInternal ObjectKind = "internal"
+)
+
+func objectKind(filePath string) ObjectKind {
+	switch {
+	case !isInternalPath(filePath):
+		return ObjectKindFile
+	case strings.HasPrefix(filePath, internalPathPrefix+"/versions/"):
+		return ObjectKindVersion
+	case strings.HasPrefix(filePath, internalPathPrefix+"/trash/"):
+		return ObjectKindTrash
+	case strings.HasPrefix(filePath, internalPathPrefix+"/uploads/"):
+		return ObjectKindUpload
+	case strings.HasPrefix(filePath, blobsPath+"/"):
+		return ObjectKindBlob
+	}
+	return ObjectKindInternal
+}
+
+type FsckProblemType string
+
+const (
+	// FsckProblemOrphan is an object without database row.
+	FsckProblemOrphan FsckProblemType = "orphan"
+	// FsckProblemMissing is a database row without object.
+	FsckProblemMissing FsckProblemType = "missing"
+	// FsckProblemSizeMismatch is an object whose size differs from the size in the database.
+	FsckProblemSizeMismatch FsckProblemType = "size_mismatch"
+)
+
+type FsckOptions struct {
+	// DeleteOrphans deletes objects without database row.
+	DeleteOrphans bool `json:"delete_orphans"`
+	// Import creates a file for every orphaned object outside the internal prefix instead of deleting it.
+	Import bool `json:"import"`
+	// ImportOwner is the id of the user owning imported files.
+	ImportOwner string `json:"import_owner"`
+	// FixSizes updates sizes in the database to the size of the objects.
+	FixSizes bool `json:"fix_sizes"`
+}
+
+type FsckProblem struct {
+	Type         FsckProblemType `json:"type"`
+	Kind         ObjectKind      `json:"kind"`
+	Path         string          `json:"path"`
+	Size         uint64          `json:"size"`
+	ExpectedSize uint64          `json:"expected_size"`
+	Repaired     bool            `json:"repaired"`
+	Error        string          `json:"error,omitempty"`
+}
+
+type FsckReport struct {
+	Objects  int           `json:"objects"`
+	Rows     int           `json:"rows"`
+	Problems []FsckProblem `json:"problems"`
+}
+
+// Unrepaired returns the number of problems which were not repaired.
+func (r FsckReport) Unrepaired() int {
+	var count int
+	for _, problem := range r.Problems {
+		if !problem.Repaired {
+			count++
+		}
+	}
+	return count
+}
+
+// CheckFsckOptions validates the owner of imported files. Without authentication there are no users, so the owner is not looked up.
+func CheckFsckOptions(ctx context.Context, db *DB, opts FsckOptions, auth bool) error {
+	if !opts.Import {
+		return nil
+	}
+	if opts.ImportOwner == "" {
+		return ErrMissingImportOwner
+	}
+	if !auth {
+		return nil
+	}
+	if _, err := db.GetUser(ctx, opts.ImportOwner); err != nil {
+		return fmt.Errorf("error checking owner of imported files: %w", err)
+	}
+	return nil
+}
+
+// Fsck compares the objects in the storage with the database and optionally repairs the differences.
+// Missing objects are only reported, since their content can not be recovered.
+func Fsck(ctx context.Context, db *DB, storage Storage, opts FsckOptions) (*FsckReport, error) {
+	expected, err := db.GetExpectedObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, dedup := storage.(*dedupStorage)
+	byPath := make(map[string]ExpectedObject, len(expected))
+	for _, object := range expected {
+		// referenced blobs are not listed by the deduplicating storage, so every listed blob is an orphan
+		if object.Kind == ObjectKindBlob {
+			if !dedup {
+				return nil, errors.New("the database references deduplicated blobs but deduplication is disabled")
+			}
+			continue
+		}
+		byPath[object.Path] = object
+	}
+
+	report := &FsckReport{
+		Rows:     len(byPath),
+		Problems: []FsckProblem{},
+	}
+	found := make(map[string]struct{}, len(byPath))
+	recent := time.Now().Add(-fsckGracePeriod)
+	if err = storage.ListObjects(ctx, "/", func(info ObjectInfo) error {
+		report.Objects++
+		object, ok := byPath[info.Path]
+		if ok {
+			found[info.Path] = struct{}{}
+			if object.Size == nil || *object.Size == info.Size {
+				return nil
+			}
+			problem := FsckProblem{
+				Type:         FsckProblemSizeMismatch,
+				Kind:         object.Kind,
+				Path:         info.Path,
+				Size:         info.Size,
+				ExpectedSize: *object.Size,
+			}
+			if opts.FixSizes {
+				problem.setRepaired(db.UpdateObjectSize(ctx, object, info.Size))
+			}
+			report.Problems = append(report.Problems, problem)
+			return nil
+		}
+
+		if info.LastModified.After(recent) {
+			return nil
+		}
+		problem := FsckProblem{
+			Type: FsckProblemOrphan,
+			Kind: objectKind(info.Path),
+			Path: info.Path,
+			Size: info.Size,
+		}
+		if opts.Import && problem.Kind == ObjectKindFile {
+			_, err = db.CreateFile(ctx, info.Path, info.Size, info.ContentType, "", opts.ImportOwner)
+			problem.setRepaired(err)
+		} else if opts.DeleteOrphans {
+			problem.setRepaired(storage.DeleteObject(ctx, info.Path))
+		}
+		report.Problems = append(report.Problems, problem)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	for _, object := range byPath {
+		if _, ok := found[object.Path]; ok {
+			continue
+		}
+		problem := FsckProblem{
+			Type: FsckProblemMissing,
+			Kind: object.Kind,
+			Path: object.Path,
+		}
+		if object.Size != nil {
+			problem.ExpectedSize = *object.Size
+		}
+		report.Problems = append(report.Problems, problem)
+	}
+
+	sort.Slice(report.Problems, func(i, j int) bool {
+		return report.Problems[i].Path < report.Problems[j].Path
+	})
+	return report, nil
+}
+
+func (p *FsckProblem) setRepaired(err error) {
+	if err != nil {
+		p.Error = err.Error()
+		return
+	}
+	p.Repaired = true
+}
+
+func (s *Server) FsckRoutes(r chi.Router) {
+	r.Get("/", s.GetFsck)
+	r.Post("/", s.PostFsck)
+}
+
+// GetFsck checks the storage against the database without repairing anything.
+func (s *Server) GetFsck(w http.ResponseWriter, r *http.Request) {
+	s.fsck(w, r, FsckOptions{})
+}
+
+// PostFsck checks the storage against the database and repairs the differences selected in the request body.
+func (s *Server) PostFsck(w http.ResponseWriter, r *http.Request) {
+	var opts FsckOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && err != io.EOF {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	s.fsck(w, r, opts)
+}
+
+func (s *Server) fsck(w http.ResponseWriter, r *http.Request, opts FsckOptions) {
+	if err := CheckFsckOptions(r.Context(), s.db, opts, s.cfg.Auth != nil); err != nil {
+		if errors.Is(err, ErrMissingImportOwner) || errors.Is(err, ErrUserNotFound) {
+			s.error(w, r, err, http.StatusBadRequest)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	report, err := Fsck(r.Context(), s.db, s.storage, opts)
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.ok(w, r, report)
+}
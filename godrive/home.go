@@ -0,0 +1,69 @@
+package godrive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var ErrInvalidHome = errors.New("invalid home folder")
+
+// defaultHome returns the home folder of new users. Users have no home folder if no default_home is configured.
+func (s *Server) defaultHome(username string) string {
+	if s.cfg.Auth.DefaultHome == "" {
+		return ""
+	}
+	return path.Join("/", s.cfg.Auth.DefaultHome, username)
+}
+
+// cleanHome validates a home folder set by an admin. An empty home removes the home folder of the user.
+func cleanHome(home string) (string, error) {
+	if strings.TrimSpace(home) == "" {
+		return "", nil
+	}
+	home = path.Clean("/" + home)
+	if home == "/" || isReservedPath(home) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidHome, home)
+	}
+	return home, nil
+}
+
+// provisionHome lets the user manage the access of their home folder.
+// Folders only exist through the files in them, empty home folders are shown to their users nevertheless.
+func (s *Server) provisionHome(ctx context.Context, userID string, home string) error {
+	if home == "" {
+		return nil
+	}
+	return s.db.SetACL(ctx, homeACL(userID, home))
+}
+
+// homeACL is the ACL entry which lets the user manage the access of their home folder.
+func homeACL(userID string, home string) ACL {
+	return ACL{
+		Path:        home,
+		SubjectType: ACLSubjectUser,
+		Subject:     userID,
+		Permission:  ACLPermissionManage,
+		CreatedBy:   userID,
+	}
+}
+
+// isHomeDir reports whether dir is the home folder or one of its parents, which exist for the user even while the home folder is empty.
+func isHomeDir(home string, dir string) bool {
+	return home != "" && (dir == home || isParentPath(dir, home))
+}
+
+// homeChild returns the name of the folder below dir which leads to the home folder.
+func homeChild(home string, dir string) (string, bool) {
+	if home == "" || !isParentPath(dir, home) {
+		return "", false
+	}
+	return strings.SplitN(strings.TrimPrefix(home, strings.TrimSuffix(dir, "/")+"/"), "/", 2)[0], true
+}
+
+// inHome reports whether filePath is the home folder or below it.
+func inHome(home string, filePath string) bool {
+	return home != "" && (filePath == home || isParentPath(home, filePath))
+}
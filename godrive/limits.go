@@ -0,0 +1,193 @@
+package godrive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+var (
+	ErrFileTooLarge       = errors.New("file too large")
+	ErrSizeMismatch       = errors.New("content length does not match the declared size")
+	ErrFileTypeNotAllowed = errors.New("file type not allowed")
+	ErrInvalidUpload      = errors.New("invalid upload")
+)
+
+// sniffLen is how many bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// checkSize returns ErrFileTooLarge if the size exceeds the max file size.
+func (c UploadConfig) checkSize(size uint64) error {
+	if c.MaxSize > 0 && size > c.MaxSize {
+		return fmt.Errorf("%w: %s exceeds the limit of %s", ErrFileTooLarge, humanize.IBytes(size), humanize.IBytes(c.MaxSize))
+	}
+	return nil
+}
+
+// checkName returns ErrFileTypeNotAllowed if the extension of the file name is denied or not allowed.
+func (c UploadConfig) checkName(name string) error {
+	ext := strings.ToLower(path.Ext(name))
+	if !isAllowed(ext, c.AllowedExtensions, c.DeniedExtensions, matchExtension) {
+		if ext == "" {
+			return fmt.Errorf("%w: files without extension", ErrFileTypeNotAllowed)
+		}
+		return fmt.Errorf("%w: %s", ErrFileTypeNotAllowed, ext)
+	}
+	return nil
+}
+
+// checkContentType returns ErrFileTypeNotAllowed if the content type is denied or not allowed. Parameters like the charset are ignored.
+func (c UploadConfig) checkContentType(contentType string) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	if !isAllowed(strings.ToLower(mediaType), c.AllowedTypes, c.DeniedTypes, matchContentType) {
+		return fmt.Errorf("%w: %s", ErrFileTypeNotAllowed, mediaType)
+	}
+	return nil
+}
+
+// check returns an error if a file with the name, size and content type can not be uploaded.
+func (c UploadConfig) check(name string, size uint64, contentType string) error {
+	if err := c.checkSize(size); err != nil {
+		return err
+	}
+	if err := c.checkName(name); err != nil {
+		return err
+	}
+	return c.checkContentType(contentType)
+}
+
+// isAllowed checks the deny list first, an empty allow list allows everything which is not denied.
+func isAllowed(value string, allowed []string, denied []string, match func(pattern string, value string) bool) bool {
+	for _, pattern := range denied {
+		if match(pattern, value) {
+			return false
+		}
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, pattern := range allowed {
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchExtension matches extensions with or without leading dot. An empty pattern matches files without extension.
+func matchExtension(pattern string, ext string) bool {
+	pattern = strings.ToLower(pattern)
+	if pattern != "" && !strings.HasPrefix(pattern, ".") {
+		pattern = "." + pattern
+	}
+	return pattern == ext
+}
+
+// matchContentType matches media types exactly or all subtypes of a type with patterns like image/*.
+func matchContentType(pattern string, mediaType string) bool {
+	pattern = strings.ToLower(pattern)
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return pattern == mediaType
+}
+
+// detectContentType sniffs the content type from the first bytes of the content instead of trusting the client.
+// The type of the extension is only used if the content is not recognized, or for plain text which is a text type by extension like CSS or CSV.
+func detectContentType(head []byte, name string) string {
+	contentType := http.DetectContentType(head)
+	byExtension := mime.TypeByExtension(path.Ext(name))
+	if byExtension == "" {
+		return contentType
+	}
+	if contentType == "application/octet-stream" || (strings.HasPrefix(contentType, "text/plain") && strings.HasPrefix(byExtension, "text/")) {
+		return byExtension
+	}
+	return contentType
+}
+
+// sniffContentType detects the content type of r and returns a reader which still yields all of r.
+func sniffContentType(r io.Reader, name string) (string, io.Reader, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	head = head[:n]
+	return detectContentType(head, name), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// sizeReader fails with ErrSizeMismatch if the content is shorter or longer than size.
+// Longer content is detected as soon as size bytes were read, so storages which stop reading after the expected size still fail.
+type sizeReader struct {
+	r    io.Reader
+	size uint64
+	n    uint64
+}
+
+func newSizeReader(r io.Reader, size uint64) *sizeReader {
+	return &sizeReader{r: r, size: size}
+}
+
+func (r *sizeReader) Read(p []byte) (int, error) {
+	if r.n == r.size {
+		return 0, r.expectEOF()
+	}
+	if remaining := r.size - r.n; uint64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.r.Read(p)
+	r.n += uint64(n)
+	if err == io.EOF && r.n < r.size {
+		return n, fmt.Errorf("%w: expected %d bytes but got %d", ErrSizeMismatch, r.size, r.n)
+	}
+	if err == nil && r.n == r.size {
+		if err = r.expectEOF(); err == io.EOF {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+func (r *sizeReader) expectEOF() error {
+	var b [1]byte
+	for {
+		n, err := r.r.Read(b[:])
+		if n > 0 {
+			return fmt.Errorf("%w: got more than %d bytes", ErrSizeMismatch, r.size)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// uploadError writes the response for errors of uploaded content.
+func (s *Server) uploadError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrFileTooLarge):
+		s.error(w, r, err, http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		s.error(w, r, err, http.StatusUnsupportedMediaType)
+	case errors.Is(err, ErrSizeMismatch), errors.Is(err, ErrReservedPath), errors.Is(err, ErrInvalidUpload):
+		s.error(w, r, err, http.StatusBadRequest)
+	case errors.Is(err, ErrQuotaExceeded):
+		s.error(w, r, err, http.StatusInsufficientStorage)
+	case errors.Is(err, ErrFileAlreadyExists):
+		s.error(w, r, err, http.StatusConflict)
+	case errors.Is(err, ErrAccessDenied):
+		s.error(w, r, err, http.StatusForbidden)
+	default:
+		s.error(w, r, err, http.StatusInternalServerError)
+	}
+}
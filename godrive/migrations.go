@@ -0,0 +1,286 @@
+package godrive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+)
+
+const schemaMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version    BIGINT    NOT NULL,
+    name       VARCHAR   NOT NULL,
+    applied_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (version)
+)`
+
+// Migration is a single schema change read from <dialect>/<version>_<name>.up.sql and the matching .down.sql file.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	Version   int       `db:"version"`
+	Name      string    `db:"name"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// LoadMigrations reads all migrations for the given database type from the migrations filesystem ordered by version.
+func LoadMigrations(migrations fs.FS, dbType DatabaseType) ([]Migration, error) {
+	dir := string(dbType)
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	var result []Migration
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+		versionStr, migrationName, ok := strings.Cut(strings.TrimSuffix(name, ".up.sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name: %s", name)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", name, err)
+		}
+
+		up, err := fs.ReadFile(migrations, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", name, err)
+		}
+		down, err := fs.ReadFile(migrations, path.Join(dir, strings.TrimSuffix(name, ".up.sql")+".down.sql"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("error reading migration %s: %w", name, err)
+		}
+
+		result = append(result, Migration{
+			Version: version,
+			Name:    migrationName,
+			Up:      string(up),
+			Down:    string(down),
+		})
+	}
+
+	slices.SortFunc(result, func(a, b Migration) bool {
+		return a.Version < b.Version
+	})
+	for i := 1; i < len(result); i++ {
+		if result[i].Version == result[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version: %d", result[i].Version)
+		}
+	}
+	return result, nil
+}
+
+// migrationLockID identifies the advisory lock migrators hold on postgres.
+const migrationLockID = 7243016411
+
+// migrationQuerier reads and records migrations, it is implemented by *sqlx.DB and *sqlx.Conn.
+type migrationQuerier interface {
+	sqlx.ExecerContext
+	sqlx.QueryerContext
+}
+
+// migrationConn is the connection migrations are applied on while the migration lock is held.
+type migrationConn struct {
+	*sqlx.Conn
+	// inTx is true if the connection is in the transaction holding the lock, migrations then run in it instead of in transactions of their own
+	inTx bool
+}
+
+// withMigrationLock runs fn while no other process can migrate, so replicas starting at the same time do not apply the same migrations twice.
+// Postgres holds an advisory lock while every migration runs in its own transaction.
+// SQLite has no such locks, so all migrations run in one exclusive transaction which blocks other writers until it is done.
+func (d *DB) withMigrationLock(ctx context.Context, fn func(conn *migrationConn) error) (err error) {
+	conn, err := d.dbx.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	if d.dbx.DriverName() == "pgx" {
+		if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return fmt.Errorf("error acquiring migration lock: %w", err)
+		}
+		defer func() {
+			// the lock must be released even if the context was canceled, the connection is reused by the pool
+			if _, unlockErr := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); unlockErr != nil {
+				err = errors.Join(err, fmt.Errorf("error releasing migration lock: %w", unlockErr))
+			}
+		}()
+		return fn(&migrationConn{Conn: conn})
+	}
+
+	if _, err = conn.ExecContext(ctx, "BEGIN EXCLUSIVE"); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	if err = fn(&migrationConn{Conn: conn, inTx: true}); err != nil {
+		_, _ = conn.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+	if _, err = conn.ExecContext(ctx, "COMMIT"); err != nil {
+		_, _ = conn.ExecContext(context.Background(), "ROLLBACK")
+		return fmt.Errorf("error committing migrations: %w", err)
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, q migrationQuerier) ([]appliedMigration, error) {
+	if _, err := q.ExecContext(ctx, schemaMigrationsTable); err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	var applied []appliedMigration
+	if err := sqlx.SelectContext(ctx, q, &applied, "SELECT * FROM schema_migrations ORDER BY version"); err != nil {
+		return nil, fmt.Errorf("error getting applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// MigrationStatus returns all known migrations and when they were applied.
+func (d *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return d.migrationStatus(ctx, d.dbx)
+}
+
+func (d *DB) migrationStatus(ctx context.Context, q migrationQuerier) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(d.migrations))
+	for i, migration := range d.migrations {
+		status[i] = MigrationStatus{Migration: migration}
+		if index := slices.IndexFunc(applied, func(a appliedMigration) bool { return a.Version == migration.Version }); index != -1 {
+			status[i].AppliedAt = &applied[index].AppliedAt
+		}
+	}
+	return status, nil
+}
+
+// MigrateUp applies up to steps pending migrations in order, each in its own transaction on postgres. A steps value <= 0 applies all pending migrations.
+// On sqlite all migrations are applied in one transaction, so either all or none of them are applied.
+func (d *DB) MigrateUp(ctx context.Context, steps int) (migrated []Migration, err error) {
+	err = d.withMigrationLock(ctx, func(conn *migrationConn) error {
+		// another process may have migrated while this one waited for the lock
+		status, err := d.migrationStatus(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range status {
+			if migration.AppliedAt != nil {
+				continue
+			}
+			if steps > 0 && len(migrated) >= steps {
+				break
+			}
+			slog.InfoCtx(ctx, "applying migration", slog.Int("version", migration.Version), slog.String("name", migration.Name))
+			if err = applyMigration(ctx, conn, migration.Migration, true); err != nil {
+				return err
+			}
+			migrated = append(migrated, migration.Migration)
+		}
+		return nil
+	})
+	if err != nil && d.dbx.DriverName() != "pgx" {
+		// the transaction of all migrations was rolled back
+		migrated = nil
+	}
+	return migrated, err
+}
+
+// MigrateDown reverts the last steps applied migrations in reverse order. A steps value <= 0 reverts one migration.
+func (d *DB) MigrateDown(ctx context.Context, steps int) (migrated []Migration, err error) {
+	if steps <= 0 {
+		steps = 1
+	}
+	err = d.withMigrationLock(ctx, func(conn *migrationConn) error {
+		status, err := d.migrationStatus(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(status) - 1; i >= 0 && len(migrated) < steps; i-- {
+			migration := status[i]
+			if migration.AppliedAt == nil {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s can not be reverted", migration.Version, migration.Name)
+			}
+			slog.InfoCtx(ctx, "reverting migration", slog.Int("version", migration.Version), slog.String("name", migration.Name))
+			if err = applyMigration(ctx, conn, migration.Migration, false); err != nil {
+				return err
+			}
+			migrated = append(migrated, migration.Migration)
+		}
+		return nil
+	})
+	if err != nil && d.dbx.DriverName() != "pgx" {
+		migrated = nil
+	}
+	return migrated, err
+}
+
+func applyMigration(ctx context.Context, conn *migrationConn, migration Migration, up bool) (err error) {
+	var (
+		exec sqlx.ExecerContext = conn
+		tx   *sqlx.Tx
+	)
+	if !conn.inTx {
+		if tx, err = conn.BeginTxx(ctx, nil); err != nil {
+			return fmt.Errorf("error starting migration transaction: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				_ = tx.Rollback()
+			}
+		}()
+		exec = tx
+	}
+
+	if up {
+		if _, err = exec.ExecContext(ctx, migration.Up); err != nil {
+			return fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if _, err = exec.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)", migration.Version, migration.Name, time.Now()); err != nil {
+			return fmt.Errorf("error recording migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	} else {
+		if _, err = exec.ExecContext(ctx, migration.Down); err != nil {
+			return fmt.Errorf("error reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if _, err = exec.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version); err != nil {
+			return fmt.Errorf("error removing migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("error committing migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
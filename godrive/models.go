@@ -9,27 +9,98 @@ type (
 		Theme string
 		Auth  bool
 		User  TemplateUser
+		Quota *TemplateQuota
 	}
 	IndexVariables struct {
 		BaseVariables
 		Path      string
 		PathParts []string
 		Files     []TemplateFile
+		// PresignUploads makes the browser upload directly to the storage.
+		PresignUploads bool
+		CanUpload      bool
+		// CanManage shows the access control list of the folder.
+		CanManage bool
+	}
+
+	TrashVariables struct {
+		BaseVariables
+		Files []TemplateTrashedFile
+	}
+
+	ShareVariables struct {
+		BaseVariables
+		Token            string
+		Name             string
+		Path             string
+		PathParts        []string
+		Files            []TemplateFile
+		CanUpload        bool
+		ExpiresAt        *time.Time
+		PasswordRequired bool
+		Error            string
 	}
 
 	SettingsVariables struct {
 		BaseVariables
-		Users []TemplateUser
+		Users  []TemplateUser
+		Tokens []TemplateToken
+		Audit  []TemplateAuditEntry
+		// LocalAccounts is true if users log in with a password instead of the identity provider
+		LocalAccounts bool
+	}
+
+	AccountVariables struct {
+		BaseVariables
+		// Action is the form to show, one of login, invitation and reset
+		Action   string
+		Token    string
+		Username string
+		Email    string
+		Error    string
 	}
 
 	TemplateUser struct {
-		ID      string
-		Name    string
-		Email   string
-		Home    string
-		IsAdmin bool
-		IsUser  bool
-		IsGuest bool
+		ID        string
+		Name      string
+		Email     string
+		Home      string
+		IsAdmin   bool
+		IsGuest   bool
+		CanUpload bool
+		CanModify bool
+		Quota     *TemplateQuota
+		// UserQuota and Role override the quota and role of the groups of the user, they are empty if the groups decide
+		UserQuota string
+		Role      Role
+		Disabled  bool
+		// HasPassword is true for local accounts
+		HasPassword bool
+	}
+
+	TemplateQuota struct {
+		Usage uint64
+		// Limit is 0 for unlimited quotas
+		Limit   uint64
+		Percent int
+	}
+
+	TemplateToken struct {
+		ID         string
+		Name       string
+		Scope      TokenScope
+		ExpiresAt  *time.Time
+		LastUsedAt *time.Time
+		CreatedAt  time.Time
+		Expired    bool
+	}
+
+	TemplateAuditEntry struct {
+		Username  string
+		Action    AuditAction
+		Target    string
+		Details   string
+		CreatedAt time.Time
 	}
 
 	TemplateFile struct {
@@ -44,12 +115,150 @@ type (
 		IsOwner     bool
 	}
 
+	TemplateTrashedFile struct {
+		ID        string
+		Path      string
+		Name      string
+		Size      uint64
+		Owner     string
+		DeletedAt time.Time
+	}
+
 	FileRequest struct {
 		Size        uint64 `json:"size"`
 		Description string `json:"description"`
 		Dir         string `json:"dir"`
 	}
 
+	PresignedUploadRequest struct {
+		Path        string `json:"path"`
+		Size        uint64 `json:"size"`
+		ContentType string `json:"content_type"`
+		Description string `json:"description"`
+	}
+
+	PresignedUploadResponse struct {
+		ID string `json:"id"`
+		// URL is set for uploads with a single PUT request, Parts for multipart uploads.
+		URL       string                `json:"url,omitempty"`
+		PartSize  uint64                `json:"part_size,omitempty"`
+		Parts     []PresignedUploadPart `json:"parts,omitempty"`
+		ExpiresAt time.Time             `json:"expires_at"`
+	}
+
+	PresignedUploadPart struct {
+		Number int    `json:"number"`
+		URL    string `json:"url"`
+	}
+
+	CompleteUploadRequest struct {
+		Parts []UploadPart `json:"parts"`
+	}
+
+	FileVersionResponse struct {
+		Version     int       `json:"version"`
+		Size        uint64    `json:"size"`
+		ContentType string    `json:"content_type"`
+		Owner       string    `json:"owner"`
+		CreatedAt   time.Time `json:"created_at"`
+		ArchivedAt  time.Time `json:"archived_at"`
+	}
+
+	ShareRequest struct {
+		Path         string          `json:"path"`
+		Password     string          `json:"password"`
+		Permission   SharePermission `json:"permission"`
+		MaxDownloads uint64          `json:"max_downloads"`
+		ExpiresAt    *time.Time      `json:"expires_at"`
+	}
+
+	ShareResponse struct {
+		Token        string          `json:"token"`
+		URL          string          `json:"url"`
+		Path         string          `json:"path"`
+		Owner        string          `json:"owner"`
+		Password     bool            `json:"password"`
+		Permission   SharePermission `json:"permission"`
+		MaxDownloads uint64          `json:"max_downloads"`
+		Downloads    uint64          `json:"downloads"`
+		ExpiresAt    *time.Time      `json:"expires_at"`
+		CreatedAt    time.Time       `json:"created_at"`
+	}
+
+	ACLRequest struct {
+		SubjectType ACLSubjectType `json:"subject_type"`
+		// Subject is the username for users and the group name for groups.
+		Subject    string        `json:"subject"`
+		Permission ACLPermission `json:"permission"`
+		Deny       bool          `json:"deny"`
+	}
+
+	ACLResponse struct {
+		Path        string         `json:"path"`
+		SubjectType ACLSubjectType `json:"subject_type"`
+		Subject     string         `json:"subject"`
+		Permission  ACLPermission  `json:"permission"`
+		Deny        bool           `json:"deny"`
+		// Inherited entries belong to a parent folder.
+		Inherited bool      `json:"inherited"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	UpdateUserRequest struct {
+		// Quota is a size like "10 GiB", "0" for unlimited and "" for the quota of the groups of the user.
+		Quota *string `json:"quota"`
+		// Home is the home folder of the user, "" removes it.
+		Home *string `json:"home"`
+		// Role is one of admin, user and viewer, "" uses the groups of the user again.
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+	}
+
+	InvitationRequest struct {
+		Role Role `json:"role"`
+	}
+
+	// AccountLinkResponse is a link to accept an invitation or reset a password, it is only shown once.
+	AccountLinkResponse struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	TokenRequest struct {
+		Name      string     `json:"name"`
+		Scope     TokenScope `json:"scope"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	TokenResponse struct {
+		ID         string     `json:"id"`
+		Name       string     `json:"name"`
+		Scope      TokenScope `json:"scope"`
+		Token      string     `json:"token,omitempty"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		CreatedAt  time.Time  `json:"created_at"`
+	}
+
+	FileResponse struct {
+		Path        string     `json:"path"`
+		Dir         string     `json:"dir"`
+		Name        string     `json:"name"`
+		IsDir       bool       `json:"is_dir"`
+		Size        uint64     `json:"size"`
+		ContentType string     `json:"content_type,omitempty"`
+		Description string     `json:"description"`
+		Owner       string     `json:"owner"`
+		IsOwner     bool       `json:"is_owner"`
+		CreatedAt   *time.Time `json:"created_at,omitempty"`
+		UpdatedAt   time.Time  `json:"updated_at"`
+	}
+
+	FileListResponse struct {
+		Path  string         `json:"path"`
+		Files []FileResponse `json:"files"`
+	}
+
 	ErrorResponse struct {
 		Message   string `json:"message"`
 		Status    int    `json:"status"`
@@ -0,0 +1,166 @@
+package godrive
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Permission is what a role allows. Which groups have which permissions is configured in AuthGroups.
+type Permission string
+
+const (
+	PermissionRead   Permission = "read"
+	PermissionUpload Permission = "upload"
+	// PermissionModifyOwn allows changing, moving and deleting own files and managing shares and the trash.
+	PermissionModifyOwn Permission = "modify_own"
+	// PermissionModifyAny allows changing, moving and deleting files of all users and implies PermissionModifyOwn.
+	PermissionModifyAny Permission = "modify_any"
+	// PermissionAdmin implies all other permissions and bypasses ACLs.
+	PermissionAdmin Permission = "admin"
+)
+
+var allPermissions = []Permission{PermissionRead, PermissionUpload, PermissionModifyOwn, PermissionModifyAny, PermissionAdmin}
+
+func (p Permission) description() string {
+	switch p {
+	case PermissionRead:
+		return "read files"
+	case PermissionUpload:
+		return "upload files"
+	case PermissionModifyOwn:
+		return "change files"
+	case PermissionModifyAny:
+		return "change files of other users"
+	case PermissionAdmin:
+		return "administrate godrive"
+	}
+	return string(p)
+}
+
+type permissionSet map[Permission]bool
+
+func (p permissionSet) has(permission Permission) bool {
+	if permission == PermissionModifyOwn && p[PermissionModifyAny] {
+		return true
+	}
+	return p[permission]
+}
+
+// permissions returns the permissions of all groups of the user, limited by the scope of the token the user authenticated with.
+// Without authentication everyone can do everything except administration, like before there were roles.
+func (s *Server) permissions(info *UserInfo) permissionSet {
+	if s.cfg.Auth == nil {
+		return permissionSet{
+			PermissionRead:      true,
+			PermissionUpload:    true,
+			PermissionModifyAny: true,
+		}
+	}
+
+	permissions := permissionSet{}
+	for _, permission := range s.userPermissions(info) {
+		if permission == PermissionAdmin {
+			for _, p := range allPermissions {
+				permissions[p] = true
+			}
+			continue
+		}
+		permissions[permission] = true
+	}
+
+	switch info.Scope {
+	case TokenScopeRead:
+		return permissionSet{PermissionRead: permissions[PermissionRead]}
+	case TokenScopeWrite:
+		delete(permissions, PermissionAdmin)
+	}
+	return permissions
+}
+
+// userPermissions returns the permissions of the role the user was given by an admin or else the permissions of all groups of the user.
+func (s *Server) userPermissions(info *UserInfo) []Permission {
+	if info.Role != nil {
+		return s.rolePermissions(*info.Role)
+	}
+	var permissions []Permission
+	for _, group := range info.Groups {
+		permissions = append(permissions, s.groupPermissions(group)...)
+	}
+	return permissions
+}
+
+// rolePermissions returns the permissions of the group of the role, so permissions configured for the group also apply to the role.
+func (s *Server) rolePermissions(role Role) []Permission {
+	var group string
+	switch role {
+	case RoleAdmin:
+		group = s.cfg.Auth.Groups.Admin
+	case RoleUser:
+		group = s.cfg.Auth.Groups.User
+	case RoleViewer:
+		group = s.cfg.Auth.Groups.Viewer
+	}
+	if group == "" {
+		return role.permissions()
+	}
+	return s.groupPermissions(group)
+}
+
+func (r Role) permissions() []Permission {
+	switch r {
+	case RoleAdmin:
+		return []Permission{PermissionAdmin}
+	case RoleUser:
+		return []Permission{PermissionRead, PermissionUpload, PermissionModifyOwn}
+	case RoleViewer:
+		return []Permission{PermissionRead}
+	}
+	return nil
+}
+
+// groupPermissions returns the configured permissions of the group. Groups which are not configured get the permissions of their role:
+// admins everything, users everything for their own files, viewers and guests read only.
+func (s *Server) groupPermissions(group string) []Permission {
+	if group == "" || (group == "guest" && !s.cfg.Auth.Groups.Guest) {
+		return nil
+	}
+	for _, groupPermissions := range s.cfg.Auth.Groups.Permissions {
+		if groupPermissions.Group == group {
+			return groupPermissions.Permissions
+		}
+	}
+
+	switch group {
+	case s.cfg.Auth.Groups.Admin:
+		return RoleAdmin.permissions()
+	case s.cfg.Auth.Groups.User:
+		return RoleUser.permissions()
+	case s.cfg.Auth.Groups.Viewer, "guest":
+		return RoleViewer.permissions()
+	}
+	return nil
+}
+
+func (s *Server) hasPermission(info *UserInfo, permission Permission) bool {
+	return s.permissions(info).has(permission)
+}
+
+// RequirePermission responds with 403 Forbidden to users without the permission.
+func (s *Server) RequirePermission(permission Permission) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.hasPermission(GetUserInfo(r), permission) {
+				s.permissionDenied(w, r, permission)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (s *Server) permissionDenied(w http.ResponseWriter, r *http.Request, permission Permission) {
+	s.error(w, r, fmt.Errorf("%w: your account can not %s", ErrPermissionDenied, permission.description()), http.StatusForbidden)
+}
@@ -0,0 +1,435 @@
+package godrive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var testRoles = []string{"admin", "user", "viewer", "guest"}
+
+var testScopes = []TokenScope{TokenScopeRead, TokenScopeWrite, TokenScopeAdmin}
+
+// expectedPermissions are the permissions of the roles with the default group configuration, limited by the token scope.
+func expectedPermissions(role string, scope TokenScope) []Permission {
+	var permissions []Permission
+	switch role {
+	case "admin":
+		permissions = allPermissions
+	case "user":
+		permissions = []Permission{PermissionRead, PermissionUpload, PermissionModifyOwn}
+	case "viewer", "guest":
+		permissions = []Permission{PermissionRead}
+	}
+	switch scope {
+	case TokenScopeRead:
+		return []Permission{PermissionRead}
+	case TokenScopeWrite:
+		var limited []Permission
+		for _, permission := range permissions {
+			if permission != PermissionAdmin {
+				limited = append(limited, permission)
+			}
+		}
+		return limited
+	}
+	return permissions
+}
+
+func hasExpectedPermission(role string, scope TokenScope, permission Permission) bool {
+	for _, p := range expectedPermissions(role, scope) {
+		if p == permission || (p == PermissionModifyAny && permission == PermissionModifyOwn) {
+			return true
+		}
+	}
+	return false
+}
+
+func testAuthConfig() *AuthConfig {
+	return &AuthConfig{
+		Mode: AuthModeLocal,
+		Groups: AuthGroups{
+			Admin:  "admin",
+			User:   "user",
+			Viewer: "viewer",
+			Guest:  true,
+		},
+	}
+}
+
+func testUserInfo(role string, scope TokenScope) *UserInfo {
+	if role == "guest" {
+		return GetUserInfoFromContext(context.Background())
+	}
+	r := Role(role)
+	return &UserInfo{
+		UserInfo: oidc.UserInfo{Subject: role},
+		Username: role,
+		Role:     &r,
+		Scope:    scope,
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	s := &Server{cfg: Config{Auth: testAuthConfig()}}
+
+	scopes := append([]TokenScope{""}, testScopes...)
+	for _, role := range testRoles {
+		for _, scope := range scopes {
+			if role == "guest" && scope != "" {
+				continue
+			}
+			t.Run(role+"/"+string(scope), func(t *testing.T) {
+				permissions := s.permissions(testUserInfo(role, scope))
+				for _, permission := range allPermissions {
+					if got, want := permissions.has(permission), hasExpectedPermission(role, scope, permission); got != want {
+						t.Errorf("has(%s) = %t, want %t", permission, got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPermissionsGroups(t *testing.T) {
+	cfg := testAuthConfig()
+	cfg.Groups.Guest = false
+	cfg.Groups.Permissions = []GroupPermissions{
+		{Group: "editors", Permissions: []Permission{PermissionRead, PermissionUpload, PermissionModifyAny}},
+	}
+	s := &Server{cfg: Config{Auth: cfg}}
+
+	tests := []struct {
+		name   string
+		groups []string
+		want   []Permission
+	}{
+		{name: "admin group", groups: []string{"admin"}, want: allPermissions},
+		{name: "user group", groups: []string{"user"}, want: []Permission{PermissionRead, PermissionUpload, PermissionModifyOwn}},
+		{name: "viewer group", groups: []string{"viewer"}, want: []Permission{PermissionRead}},
+		{name: "disabled guest group", groups: []string{"guest"}, want: nil},
+		{name: "configured group", groups: []string{"editors"}, want: []Permission{PermissionRead, PermissionUpload, PermissionModifyOwn, PermissionModifyAny}},
+		{name: "combined groups", groups: []string{"viewer", "user"}, want: []Permission{PermissionRead, PermissionUpload, PermissionModifyOwn}},
+		{name: "unknown group", groups: []string{"unknown"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permissions := s.permissions(&UserInfo{Groups: tt.groups})
+			for _, permission := range allPermissions {
+				want := false
+				for _, p := range tt.want {
+					want = want || p == permission
+				}
+				if got := permissions.has(permission); got != want {
+					t.Errorf("has(%s) = %t, want %t", permission, got, want)
+				}
+			}
+		})
+	}
+}
+
+type permissionTestServer struct {
+	s      *Server
+	ts     *httptest.Server
+	tokens map[string]string
+}
+
+// newPermissionTestServer starts a server with local accounts on a temporary SQLite database and local storage.
+// Every role except guest gets a user with a token for every scope, the user "other" has the user role as well.
+func newPermissionTestServer(t *testing.T) *permissionTestServer {
+	t.Helper()
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cfg := Config{
+		Database: DatabaseConfig{Type: DatabaseTypeSQLite, Path: filepath.Join(dir, "godrive.db")},
+		Storage:  StorageConfig{Type: StorageTypeLocal, Path: filepath.Join(dir, "storage")},
+		Auth:     testAuthConfig(),
+	}
+	db, err := NewDB(ctx, cfg.Database, os.DirFS("../sql/migrations"))
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if _, err = db.MigrateUp(ctx, 0); err != nil {
+		t.Fatalf("failed to migrate database: %s", err)
+	}
+	tracer := trace.NewNoopTracerProvider().Tracer("godrive")
+	storage, err := NewStorage(ctx, cfg.Storage, tracer)
+	if err != nil {
+		t.Fatalf("failed to create storage: %s", err)
+	}
+
+	tmpl := func(w io.Writer, name string, data any) error {
+		return nil
+	}
+	writer := func(w io.Writer) error {
+		return nil
+	}
+	s := NewServer("test", cfg, db, &Auth{Sessions: db}, storage, tracer, nil, http.Dir(dir), tmpl, writer, writer)
+	ts := httptest.NewServer(s.Routes())
+	t.Cleanup(ts.Close)
+
+	p := &permissionTestServer{
+		s:      s,
+		ts:     ts,
+		tokens: map[string]string{},
+	}
+	for _, id := range []string{"admin", "user", "viewer", "other"} {
+		role := Role(id)
+		if id == "other" {
+			role = RoleUser
+		}
+		if err = db.UpsertUser(ctx, id, id, id+"@localhost", "", nil); err != nil {
+			t.Fatalf("failed to create user: %s", err)
+		}
+		if err = db.UpdateUser(ctx, User{ID: id, Role: &role}); err != nil {
+			t.Fatalf("failed to set role: %s", err)
+		}
+		for _, scope := range testScopes {
+			rawToken := TokenPrefix + id + "_" + string(scope)
+			if _, err = db.CreateToken(ctx, Token{
+				ID:     s.newID(16),
+				UserID: id,
+				Name:   string(scope),
+				Hash:   hashToken(rawToken),
+				Scope:  scope,
+			}); err != nil {
+				t.Fatalf("failed to create token: %s", err)
+			}
+			p.tokens[id+"/"+string(scope)] = rawToken
+		}
+	}
+	return p
+}
+
+func (p *permissionTestServer) do(t *testing.T, user string, method string, path string, header http.Header, body io.Reader) *http.Response {
+	t.Helper()
+	rq, err := http.NewRequest(method, p.ts.URL+path, body)
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err)
+	}
+	for key, values := range header {
+		rq.Header[key] = values
+	}
+	if token, ok := p.tokens[user]; ok {
+		rq.Header.Set("Authorization", "Bearer "+token)
+	}
+	rs, err := p.ts.Client().Do(rq)
+	if err != nil {
+		t.Fatalf("failed to send request: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = rs.Body.Close()
+	})
+	return rs
+}
+
+func (p *permissionTestServer) upload(t *testing.T, user string, dir string, name string, content string) *http.Response {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="json"`)
+	header.Set("Content-Type", "application/json")
+	part, _ := mw.CreatePart(header)
+	_ = json.NewEncoder(part).Encode(map[string]any{"size": len(content), "dir": dir})
+	part, _ = mw.CreateFormFile("file", name)
+	_, _ = part.Write([]byte(content))
+	_ = mw.Close()
+
+	return p.do(t, user, http.MethodPost, dir, http.Header{"Content-Type": {mw.FormDataContentType()}}, buf)
+}
+
+// deniedByMiddleware reports whether the request was stopped by RequirePermission or by the auth and token scope checks before it.
+func deniedByMiddleware(t *testing.T, rs *http.Response) bool {
+	t.Helper()
+	if rs.StatusCode == http.StatusFound && rs.Header.Get("Location") == "/login" {
+		return true
+	}
+	if rs.StatusCode != http.StatusForbidden {
+		return false
+	}
+	var errRs ErrorResponse
+	if err := json.NewDecoder(rs.Body).Decode(&errRs); err != nil {
+		t.Fatalf("failed to decode error response: %s", err)
+	}
+	return strings.HasPrefix(errRs.Message, ErrPermissionDenied.Error()) || errRs.Message == "not authorized" || errRs.Message == "token scope does not allow changes"
+}
+
+func TestRequirePermission(t *testing.T) {
+	p := newPermissionTestServer(t)
+	p.ts.Client().CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	routes := []struct {
+		method     string
+		path       string
+		permission Permission
+	}{
+		{method: http.MethodPatch, path: "/settings/users/missing", permission: PermissionAdmin},
+		{method: http.MethodDelete, path: "/settings/users/missing", permission: PermissionAdmin},
+		{method: http.MethodPost, path: "/settings/users/missing/password-reset", permission: PermissionAdmin},
+		{method: http.MethodGet, path: "/settings/fsck", permission: PermissionAdmin},
+		{method: http.MethodPost, path: "/settings/fsck", permission: PermissionAdmin},
+		{method: http.MethodPost, path: "/settings/invitations", permission: PermissionAdmin},
+		{method: http.MethodPost, path: "/tus", permission: PermissionUpload},
+		{method: http.MethodPost, path: "/uploads", permission: PermissionUpload},
+		{method: http.MethodGet, path: "/versions/missing.txt", permission: PermissionRead},
+		{method: http.MethodPost, path: "/versions/missing.txt", permission: PermissionModifyOwn},
+		{method: http.MethodGet, path: "/trash", permission: PermissionModifyOwn},
+		{method: http.MethodDelete, path: "/trash/missing", permission: PermissionModifyOwn},
+		{method: http.MethodGet, path: "/shares", permission: PermissionModifyOwn},
+		{method: http.MethodPost, path: "/shares", permission: PermissionModifyOwn},
+		{method: http.MethodGet, path: "/acl/missing", permission: PermissionRead},
+		{method: http.MethodGet, path: "/missing.txt", permission: PermissionRead},
+		{method: http.MethodPost, path: "/missing", permission: PermissionUpload},
+		{method: http.MethodPatch, path: "/missing.txt", permission: PermissionModifyOwn},
+		{method: http.MethodPut, path: "/missing.txt", permission: PermissionModifyOwn},
+		{method: "COPY", path: "/missing.txt", permission: PermissionUpload},
+		{method: http.MethodDelete, path: "/missing.txt", permission: PermissionModifyOwn},
+		{method: http.MethodGet, path: "/api/v1/files/missing.txt", permission: PermissionRead},
+		{method: http.MethodPost, path: "/api/v1/files/missing", permission: PermissionUpload},
+		{method: http.MethodPatch, path: "/api/v1/files/missing.txt", permission: PermissionModifyOwn},
+		{method: http.MethodPut, path: "/api/v1/files/missing.txt", permission: PermissionModifyOwn},
+		{method: "COPY", path: "/api/v1/files/missing.txt", permission: PermissionUpload},
+		{method: http.MethodDelete, path: "/api/v1/files/missing.txt", permission: PermissionModifyOwn},
+		{method: http.MethodPost, path: "/api/v1/uploads", permission: PermissionUpload},
+		{method: http.MethodGet, path: "/api/v1/acl/missing", permission: PermissionRead},
+	}
+	for _, role := range testRoles {
+		scopes := testScopes
+		if role == "guest" {
+			scopes = []TokenScope{""}
+		}
+		for _, scope := range scopes {
+			user := role + "/" + string(scope)
+			for _, route := range routes {
+				t.Run(user+" "+route.method+" "+route.path, func(t *testing.T) {
+					// guests can not open the settings at all
+					want := hasExpectedPermission(role, scope, route.permission) && !(role == "guest" && strings.HasPrefix(route.path, "/settings"))
+					rs := p.do(t, user, route.method, route.path, nil, nil)
+					if got := !deniedByMiddleware(t, rs); got != want {
+						t.Errorf("allowed = %t (status %d), want %t", got, rs.StatusCode, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestModifyOwnAndOtherFiles(t *testing.T) {
+	p := newPermissionTestServer(t)
+
+	tests := []struct {
+		name   string
+		user   string
+		method string
+		status int
+	}{
+		{name: "owner moves", user: "user/write", method: http.MethodPut, status: http.StatusNoContent},
+		{name: "other user moves", user: "other/write", method: http.MethodPut, status: http.StatusForbidden},
+		{name: "viewer moves", user: "viewer/write", method: http.MethodPut, status: http.StatusForbidden},
+		{name: "admin moves", user: "admin/admin", method: http.MethodPut, status: http.StatusNoContent},
+		{name: "owner deletes", user: "user/write", method: http.MethodDelete, status: http.StatusNoContent},
+		{name: "other user deletes", user: "other/write", method: http.MethodDelete, status: http.StatusForbidden},
+		{name: "viewer deletes", user: "viewer/write", method: http.MethodDelete, status: http.StatusForbidden},
+		{name: "admin deletes", user: "admin/admin", method: http.MethodDelete, status: http.StatusNoContent},
+		{name: "admin with write token deletes", user: "admin/write", method: http.MethodDelete, status: http.StatusNoContent},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := "/files" + string(rune('a'+i))
+			if rs := p.upload(t, "user/write", dir, "file.txt", "content"); rs.StatusCode != http.StatusNoContent {
+				t.Fatalf("failed to upload file: %d", rs.StatusCode)
+			}
+
+			var header http.Header
+			if tt.method == http.MethodPut {
+				header = http.Header{"Destination": {dir + "/moved.txt"}}
+			}
+			rs := p.do(t, tt.user, tt.method, dir+"/file.txt", header, nil)
+			if rs.StatusCode != tt.status {
+				t.Errorf("got status %d, want %d", rs.StatusCode, tt.status)
+			}
+
+			_, err := p.s.db.GetFile(context.Background(), dir+"/file.txt")
+			if unchanged := err == nil; unchanged != (tt.status != http.StatusNoContent) {
+				t.Errorf("file unchanged = %t after status %d", unchanged, rs.StatusCode)
+			}
+		})
+	}
+}
+
+func TestCanModify(t *testing.T) {
+	own := File{Path: "/home/user/own.txt", UserID: "user"}
+	other := File{Path: "/home/user/other.txt", UserID: "other"}
+	outside := File{Path: "/shared/own.txt", UserID: "user"}
+
+	tests := []struct {
+		name          string
+		permissions   permissionSet
+		confineWrites bool
+		acls          map[string][]ACL
+		file          File
+		want          bool
+	}{
+		{name: "modify own file", permissions: permissionSet{PermissionModifyOwn: true}, file: own, want: true},
+		{name: "modify other file", permissions: permissionSet{PermissionModifyOwn: true}, file: other, want: false},
+		{name: "modify any other file", permissions: permissionSet{PermissionModifyAny: true}, file: other, want: true},
+		{name: "read only own file", permissions: permissionSet{PermissionRead: true, PermissionUpload: true}, file: own, want: false},
+		{name: "admin other file", permissions: permissionSet{PermissionAdmin: true}, file: other, want: true},
+		{name: "confined own file outside home", permissions: permissionSet{PermissionModifyOwn: true}, confineWrites: true, file: outside, want: false},
+		{name: "confined own file in home", permissions: permissionSet{PermissionModifyOwn: true}, confineWrites: true, file: own, want: true},
+		{
+			name:        "acl grants other file",
+			permissions: permissionSet{PermissionModifyOwn: true},
+			acls:        map[string][]ACL{"/home/user": {{Path: "/home/user", SubjectType: ACLSubjectUser, Subject: "user", Permission: ACLPermissionWrite}}},
+			file:        other,
+			want:        true,
+		},
+		{
+			name:        "acl denies own file",
+			permissions: permissionSet{PermissionModifyOwn: true},
+			acls:        map[string][]ACL{"/home/user": {{Path: "/home/user", SubjectType: ACLSubjectUser, Subject: "user", Permission: ACLPermissionWrite, Deny: true}}},
+			file:        own,
+			want:        false,
+		},
+		{
+			name:        "acl does not grant without modify permission",
+			permissions: permissionSet{PermissionRead: true},
+			acls:        map[string][]ACL{"/home/user": {{Path: "/home/user", SubjectType: ACLSubjectUser, Subject: "user", Permission: ACLPermissionWrite}}},
+			file:        other,
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &access{
+				userID:        "user",
+				home:          "/home/user",
+				permissions:   tt.permissions,
+				acls:          tt.acls,
+				confineWrites: tt.confineWrites,
+			}
+			if got := a.canModify(tt.file); got != tt.want {
+				t.Errorf("canModify(%s) = %t, want %t", tt.file.Path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package godrive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/exp/slog"
+)
+
+// maxUploadParts is the most parts S3 accepts for a multipart upload.
+const maxUploadParts = 10000
+
+var ErrPresignDisabled = errors.New("presigned uploads are disabled")
+
+// PresignRoutes lets clients upload directly to the storage with presigned URLs.
+// The content is uploaded as the first chunk of an upload like with tus, so unfinished uploads expire the same way,
+// and is moved to the file path once the client completes the upload.
+func (s *Server) PresignRoutes(r chi.Router) {
+	r.Post("/", s.PostPresignedUpload)
+	r.Post("/{id}", s.CompletePresignedUpload)
+	r.Delete("/{id}", s.DeletePresignedUpload)
+}
+
+// presigner returns the storage if presigned URLs are enabled and supported by it.
+func (s *Server) presigner() (Presigner, bool) {
+	if s.cfg.Storage.Presign == nil {
+		return nil, false
+	}
+	presigner, ok := s.storage.(Presigner)
+	return presigner, ok
+}
+
+// redirectPresigned redirects to a presigned URL of the file, which also handles range requests.
+func (s *Server) redirectPresigned(w http.ResponseWriter, r *http.Request, presigner Presigner, file File, download bool) {
+	params := url.Values{
+		"response-content-type": {file.ContentType},
+	}
+	if download {
+		params.Set("response-content-disposition", "attachment; filename="+path.Base(file.Path))
+	}
+	u, err := presigner.PresignGetObject(r.Context(), file.Path, s.cfg.Storage.Presign.expiry(), params)
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u, http.StatusTemporaryRedirect)
+}
+
+// PostPresignedUpload creates an upload and returns the URLs to upload its content to.
+// Uploads larger than the configured part size are split into parts which have to be uploaded separately.
+func (s *Server) PostPresignedUpload(w http.ResponseWriter, r *http.Request) {
+	presigner, ok := s.presigner()
+	if !ok || !s.cfg.Storage.Presign.Uploads {
+		s.error(w, r, ErrPresignDisabled, http.StatusNotFound)
+		return
+	}
+
+	var uploadRequest PresignedUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&uploadRequest); err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	filePath := path.Join("/", uploadRequest.Path)
+	if filePath == "/" {
+		s.error(w, r, errors.New("missing path"), http.StatusBadRequest)
+		return
+	}
+	if isReservedPath(filePath) {
+		s.error(w, r, ErrReservedPath, http.StatusBadRequest)
+		return
+	}
+	contentType := uploadRequest.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	if !a.canUpload(filePath) {
+		s.error(w, r, uploadDenied(filePath), http.StatusForbidden)
+		return
+	}
+
+	if _, err := s.db.GetFile(r.Context(), filePath); err == nil {
+		s.error(w, r, ErrFileAlreadyExists, http.StatusConflict)
+		return
+	} else if !errors.Is(err, ErrFileNotFound) {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the declared content type is only checked early, the content type of the content is checked once the upload is completed
+	if err := s.cfg.Upload.check(filePath, uploadRequest.Size, contentType); err != nil {
+		s.uploadError(w, r, err)
+		return
+	}
+	userID := GetUserInfo(r).Subject
+	if !s.hasQuota(w, r, userID, uploadRequest.Size) {
+		return
+	}
+
+	upload := Upload{
+		ID:          s.newID(32),
+		Path:        filePath,
+		Size:        uploadRequest.Size,
+		Chunks:      1,
+		ContentType: contentType,
+		Description: uploadRequest.Description,
+		UserID:      userID,
+		Presigned:   true,
+	}
+	objectPath := uploadChunkPath(upload.ID, 0)
+	expiry := s.cfg.Storage.Presign.expiry()
+	response := PresignedUploadResponse{
+		ID:        upload.ID,
+		ExpiresAt: time.Now().Add(expiry),
+	}
+
+	partSize := s.cfg.Storage.Presign.partSize()
+	if upload.Size <= partSize {
+		u, err := presigner.PresignPutObject(r.Context(), objectPath, expiry)
+		if err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		response.URL = u
+	} else {
+		if (upload.Size+partSize-1)/partSize > maxUploadParts {
+			partSize = (upload.Size + maxUploadParts - 1) / maxUploadParts
+		}
+		multipartID, err := presigner.NewMultipartUpload(r.Context(), objectPath, contentType)
+		if err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		upload.MultipartID = multipartID
+		response.PartSize = partSize
+		for part := 1; uint64(part-1)*partSize < upload.Size; part++ {
+			u, err := presigner.PresignUploadPart(r.Context(), objectPath, multipartID, part, expiry)
+			if err != nil {
+				s.abortMultipartUpload(r.Context(), upload)
+				s.error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+			response.Parts = append(response.Parts, PresignedUploadPart{
+				Number: part,
+				URL:    u,
+			})
+		}
+	}
+
+	if err := s.db.CreateUpload(r.Context(), upload); err != nil {
+		s.abortMultipartUpload(r.Context(), upload)
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.json(w, r, response, http.StatusCreated)
+}
+
+// CompletePresignedUpload is called by the client once all content is uploaded. It checks the size of the uploaded content and creates the file.
+func (s *Server) CompletePresignedUpload(w http.ResponseWriter, r *http.Request) {
+	upload, ok := s.getUpload(w, r, true)
+	if !ok {
+		return
+	}
+
+	var completeRequest CompleteUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&completeRequest); err != nil && err != io.EOF {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	objectPath := uploadChunkPath(upload.ID, 0)
+	info, err := s.storage.StatObject(r.Context(), objectPath)
+	// the object of a multipart upload only exists once it is completed, which may have happened in a previous attempt
+	if isObjectNotExist(err) && upload.MultipartID != "" {
+		presigner, ok := s.storage.(Presigner)
+		if !ok {
+			s.error(w, r, ErrPresignDisabled, http.StatusNotFound)
+			return
+		}
+		if len(completeRequest.Parts) == 0 {
+			s.error(w, r, errors.New("missing parts"), http.StatusBadRequest)
+			return
+		}
+		if err = presigner.CompleteMultipartUpload(r.Context(), objectPath, upload.MultipartID, completeRequest.Parts); err != nil {
+			s.error(w, r, fmt.Errorf("failed to complete multipart upload: %w", err), http.StatusBadRequest)
+			return
+		}
+		upload.MultipartID = ""
+		info, err = s.storage.StatObject(r.Context(), objectPath)
+	}
+	if err != nil {
+		if isObjectNotExist(err) {
+			s.error(w, r, errors.New("upload content not found"), http.StatusBadRequest)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if info.Size != upload.Size {
+		if err = s.deleteUpload(r.Context(), *upload); err != nil {
+			slog.ErrorCtx(r.Context(), "failed to delete upload", slog.String("id", upload.ID), slog.Any("err", err))
+		}
+		s.error(w, r, fmt.Errorf("%w: expected %d bytes but got %d", ErrSizeMismatch, upload.Size, info.Size), http.StatusBadRequest)
+		return
+	}
+	contentType, err := s.sniffObject(r.Context(), objectPath, upload.Path)
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.cfg.Upload.checkContentType(contentType); err != nil {
+		if deleteErr := s.deleteUpload(r.Context(), *upload); deleteErr != nil {
+			slog.ErrorCtx(r.Context(), "failed to delete upload", slog.String("id", upload.ID), slog.Any("err", deleteErr))
+		}
+		s.uploadError(w, r, err)
+		return
+	}
+	// the quota was checked when the upload was created, but other uploads may have been completed since
+	if err = s.checkQuota(r.Context(), upload.UserID, upload.Size); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			if deleteErr := s.deleteUpload(r.Context(), *upload); deleteErr != nil {
+				slog.ErrorCtx(r.Context(), "failed to delete upload", slog.String("id", upload.ID), slog.Any("err", deleteErr))
+			}
+		}
+		s.uploadError(w, r, err)
+		return
+	}
+
+	// create the file first, so an existing file is never overwritten
+	if _, err = s.db.CreateFile(r.Context(), upload.Path, upload.Size, contentType, upload.Description, upload.UserID); err != nil {
+		if errors.Is(err, ErrFileAlreadyExists) {
+			s.error(w, r, err, http.StatusConflict)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.storage.MoveObject(r.Context(), objectPath, upload.Path); err != nil {
+		if dbErr := s.db.DeleteFile(r.Context(), upload.Path); dbErr != nil {
+			slog.ErrorCtx(r.Context(), "failed to delete file after failed upload", slog.String("path", upload.Path), slog.Any("err", dbErr))
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.db.DeleteUpload(r.Context(), upload.ID); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) DeletePresignedUpload(w http.ResponseWriter, r *http.Request) {
+	upload, ok := s.getUpload(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := s.deleteUpload(r.Context(), *upload); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// abortMultipartUpload discards the uploaded parts of an upload, if it is a multipart upload.
+func (s *Server) abortMultipartUpload(ctx context.Context, upload Upload) {
+	if upload.MultipartID == "" {
+		return
+	}
+	presigner, ok := s.storage.(Presigner)
+	if !ok {
+		return
+	}
+	if err := presigner.AbortMultipartUpload(ctx, uploadChunkPath(upload.ID, 0), upload.MultipartID); err != nil {
+		slog.WarnCtx(ctx, "failed to abort multipart upload", slog.String("id", upload.ID), slog.Any("err", err))
+	}
+}
+
+// sniffObject detects the content type of content which was uploaded directly to the storage.
+func (s *Server) sniffObject(ctx context.Context, objectPath string, name string) (string, error) {
+	obj, err := s.storage.GetObject(ctx, objectPath, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+	contentType, _, err := sniffContentType(obj, name)
+	return contentType, err
+}
@@ -0,0 +1,121 @@
+package godrive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Quota is how many bytes a user stores and may store. Limit is nil if the user may store unlimited bytes.
+type Quota struct {
+	Usage uint64
+	Limit *uint64
+}
+
+// getQuota returns the usage and the quota of the user. The quota of the user overrides the quotas of their groups.
+// Without authentication there are no users and nothing is limited.
+func (s *Server) getQuota(ctx context.Context, userID string) (*Quota, error) {
+	usage, err := s.db.GetUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	quota := &Quota{Usage: usage}
+	if s.cfg.Auth == nil {
+		return quota, nil
+	}
+
+	user, err := s.db.GetUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return quota, nil
+		}
+		return nil, err
+	}
+	quota.Limit = s.quotaLimit(*user)
+	return quota, nil
+}
+
+func (s *Server) quotaLimit(user User) *uint64 {
+	if user.Quota != nil {
+		if *user.Quota == 0 {
+			return nil
+		}
+		return user.Quota
+	}
+
+	groups := strings.Split(user.Groups, ",")
+	var limit *uint64
+	for _, groupQuota := range s.cfg.Auth.Groups.Quotas {
+		if !slices.Contains(groups, groupQuota.Group) {
+			continue
+		}
+		if groupQuota.Quota == 0 {
+			return nil
+		}
+		if limit == nil || groupQuota.Quota > *limit {
+			quota := groupQuota.Quota
+			limit = &quota
+		}
+	}
+	return limit
+}
+
+// checkQuota returns ErrQuotaExceeded if the user can not store size more bytes.
+func (s *Server) checkQuota(ctx context.Context, userID string, size uint64) error {
+	quota, err := s.getQuota(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if quota.Limit != nil && quota.Usage+size > *quota.Limit {
+		return fmt.Errorf("%w: %s of %s used", ErrQuotaExceeded, humanize.IBytes(quota.Usage), humanize.IBytes(*quota.Limit))
+	}
+	return nil
+}
+
+// hasQuota checks the quota before content is stored for the user and writes the error response if the user can not store size more bytes.
+func (s *Server) hasQuota(w http.ResponseWriter, r *http.Request, userID string, size uint64) bool {
+	if err := s.checkQuota(r.Context(), userID, size); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			s.error(w, r, err, http.StatusInsufficientStorage)
+			return false
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// templateQuota returns the quota shown in the header. Errors are only logged, so pages still render.
+func (s *Server) templateQuota(ctx context.Context, info *UserInfo) *TemplateQuota {
+	if s.cfg.Auth == nil || s.isGuest(info) {
+		return nil
+	}
+	quota, err := s.getQuota(ctx, info.Subject)
+	if err != nil {
+		slog.ErrorCtx(ctx, "failed to get quota", slog.String("user_id", info.Subject), slog.Any("err", err))
+		return nil
+	}
+	return toTemplateQuota(*quota)
+}
+
+func toTemplateQuota(quota Quota) *TemplateQuota {
+	templateQuota := &TemplateQuota{
+		Usage: quota.Usage,
+	}
+	if quota.Limit != nil {
+		templateQuota.Limit = *quota.Limit
+		templateQuota.Percent = 100
+		if quota.Usage < *quota.Limit {
+			templateQuota.Percent = int(quota.Usage * 100 / *quota.Limit)
+		}
+	}
+	return templateQuota
+}
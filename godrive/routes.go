@@ -6,7 +6,9 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/riandyrn/otelchi"
@@ -47,22 +49,71 @@ func (s *Server) Routes() http.Handler {
 	r.Handle("/robots.txt", s.file("/assets/robots.txt"))
 
 	r.Get("/version", s.GetVersion)
+	r.Route("/s", s.PublicShareRoutes)
+	r.Get("/api/v1/openapi.json", s.GetOpenAPI)
 
 	r.Group(func(r chi.Router) {
 		if s.cfg.Auth != nil {
 			r.Use(s.Auth)
 			r.Group(func(r chi.Router) {
 				r.Get("/login", s.Login)
-				r.Get("/callback", s.Callback)
 				r.Get("/logout", s.Logout)
+				if s.cfg.Auth.Mode == AuthModeLocal {
+					s.LocalAccountRoutes(r)
+				} else {
+					r.Get("/callback", s.Callback)
+				}
 				r.Route("/settings", func(r chi.Router) {
+					r.Use(s.CheckAuth(func(r *http.Request, info *UserInfo) AuthAction {
+						if s.hasAccess(info) && !s.isGuest(info) {
+							return AuthActionAllow
+						}
+						if r.Method == http.MethodGet {
+							return AuthActionLogin
+						}
+						return AuthActionDeny
+					}))
 					r.Get("/", s.GetSettings)
-					// r.Head("/", s.GetSettings)
-					// r.Patch("/", s.PatchSettings)
+					r.Head("/", s.GetSettings)
+					r.With(s.RequirePermission(PermissionAdmin)).Route("/users", s.UserRoutes)
+					r.Route("/tokens", s.TokenRoutes)
+					r.With(s.RequirePermission(PermissionAdmin)).Route("/fsck", s.FsckRoutes)
+					if s.cfg.Auth.Mode == AuthModeLocal {
+						r.With(s.RequirePermission(PermissionAdmin)).Route("/invitations", s.InvitationRoutes)
+					}
 				})
 			})
 		}
 
+		if s.cfg.WebDAV != nil {
+			r.Group(func(r chi.Router) {
+				if s.cfg.Auth != nil {
+					r.Use(s.CheckAuth(func(r *http.Request, info *UserInfo) AuthAction {
+						if s.hasAccess(info) {
+							return AuthActionAllow
+						}
+						if !isAuthenticated(r) {
+							return AuthActionUnauthorized
+						}
+						return AuthActionDeny
+					}))
+				}
+				r.Mount(s.cfg.WebDAV.Prefix, s.WebDAV())
+			})
+		}
+
+		r.Group(func(r chi.Router) {
+			if s.cfg.Auth != nil {
+				r.Use(s.CheckAuth(func(r *http.Request, info *UserInfo) AuthAction {
+					if s.hasAccess(info) {
+						return AuthActionAllow
+					}
+					return AuthActionDeny
+				}))
+			}
+			r.Route("/api/v1", s.APIRoutes)
+		})
+
 		r.Group(func(r chi.Router) {
 			if s.cfg.Auth != nil {
 				r.Use(s.CheckAuth(func(r *http.Request, info *UserInfo) AuthAction {
@@ -75,12 +126,23 @@ func (s *Server) Routes() http.Handler {
 					return AuthActionDeny
 				}))
 			}
-			r.Get("/*", s.GetFiles)
-			r.Head("/*", s.GetFiles)
-			r.Post("/*", s.PostFile)
-			r.Patch("/*", s.PatchFile)
-			r.Put("/*", s.MoveFiles)
-			r.Delete("/*", s.DeleteFiles)
+			read := r.With(s.RequirePermission(PermissionRead))
+			upload := r.With(s.RequirePermission(PermissionUpload))
+			modify := r.With(s.RequirePermission(PermissionModifyOwn))
+			upload.Route("/tus", s.TusRoutes)
+			upload.Route("/uploads", s.PresignRoutes)
+			read.Route("/versions", s.VersionRoutes)
+			modify.Route("/trash", s.TrashRoutes)
+			modify.Route("/shares", s.ShareRoutes)
+			// managing ACLs is granted by ACL entries
+			read.Route("/acl", s.ACLRoutes)
+			read.Get("/*", s.GetFiles)
+			read.Head("/*", s.GetFiles)
+			upload.Post("/*", s.PostFile)
+			modify.Patch("/*", s.PatchFile)
+			modify.Put("/*", s.MoveFiles)
+			upload.Method("COPY", "/*", http.HandlerFunc(s.CopyFiles))
+			modify.Delete("/*", s.DeleteFiles)
 		})
 	})
 	r.NotFound(s.notFound)
@@ -90,24 +152,79 @@ func (s *Server) Routes() http.Handler {
 
 func (s *Server) GetSettings(w http.ResponseWriter, r *http.Request) {
 	userInfo := GetUserInfo(r)
-	if !s.isAdmin(userInfo) {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
+
+	var (
+		templateUsers []TemplateUser
+		templateAudit []TemplateAuditEntry
+	)
+	if s.isAdmin(userInfo) {
+		users, err := s.db.GetAllUsers(r.Context())
+		if err != nil {
+			s.prettyError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		usages, err := s.db.GetUsages(r.Context())
+		if err != nil {
+			s.prettyError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		templateUsers = make([]TemplateUser, len(users))
+		for i, user := range users {
+			templateUsers[i] = TemplateUser{
+				ID:    user.ID,
+				Name:  user.Username,
+				Email: user.Email,
+				Home:  user.Home,
+				Quota: toTemplateQuota(Quota{
+					Usage: usages[user.ID],
+					Limit: s.quotaLimit(user),
+				}),
+				Disabled:    user.Disabled,
+				HasPassword: user.Password != "",
+			}
+			if user.Quota != nil {
+				templateUsers[i].UserQuota = humanize.IBytes(*user.Quota)
+			}
+			if user.Role != nil {
+				templateUsers[i].Role = *user.Role
+			}
+		}
+
+		audit, err := s.db.GetAuditEntries(r.Context(), auditLogSize)
+		if err != nil {
+			s.prettyError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		templateAudit = make([]TemplateAuditEntry, len(audit))
+		for i, entry := range audit {
+			templateAudit[i] = TemplateAuditEntry{
+				Username:  entry.Username,
+				Action:    entry.Action,
+				Target:    entry.Target,
+				Details:   entry.Details,
+				CreatedAt: entry.CreatedAt,
+			}
+		}
 	}
 
-	users, err := s.db.GetAllUsers(r.Context())
+	tokens, err := s.db.GetTokens(r.Context(), userInfo.Subject)
 	if err != nil {
 		s.prettyError(w, r, err, http.StatusInternalServerError)
 		return
 	}
-
-	templateUsers := make([]TemplateUser, len(users))
-	for i, user := range users {
-		templateUsers[i] = TemplateUser{
-			ID:    user.ID,
-			Name:  user.Username,
-			Email: user.Email,
-			Home:  user.Home,
+	now := time.Now()
+	templateTokens := make([]TemplateToken, len(tokens))
+	for i, token := range tokens {
+		templateTokens[i] = TemplateToken{
+			ID:         token.ID,
+			Name:       token.Name,
+			Scope:      token.Scope,
+			ExpiresAt:  token.ExpiresAt,
+			LastUsedAt: token.LastUsedAt,
+			CreatedAt:  token.CreatedAt,
+			Expired:    token.ExpiresAt != nil && token.ExpiresAt.Before(now),
 		}
 	}
 
@@ -116,8 +233,13 @@ func (s *Server) GetSettings(w http.ResponseWriter, r *http.Request) {
 			Theme: "dark",
 			Auth:  s.cfg.Auth != nil,
 			User:  s.ToTemplateUser(userInfo),
+			Quota: s.templateQuota(r.Context(), userInfo),
 		},
-		Users: templateUsers,
+		Users:  templateUsers,
+		Tokens: templateTokens,
+		Audit:  templateAudit,
+
+		LocalAccounts: s.cfg.Auth.Mode == AuthModeLocal,
 	}
 	if err = s.tmpl(w, "settings.gohtml", vars); err != nil {
 		slog.ErrorCtx(r.Context(), "error rendering template", slog.Any("err", err))
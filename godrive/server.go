@@ -1,9 +1,11 @@
 package godrive
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
 	"io"
-	"math/rand"
+	"math/big"
 	"net/http"
 	"runtime"
 	"strings"
@@ -20,7 +22,10 @@ type (
 )
 
 func NewServer(version string, cfg Config, db *DB, auth *Auth, storage Storage, tracer trace.Tracer, meter metric.Meter, assets http.FileSystem, tmpl ExecuteTemplateFunc, js WriterFunc, css WriterFunc) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
 	s := &Server{
+		ctx:     ctx,
+		cancel:  cancel,
 		version: version,
 		cfg:     cfg,
 		db:      db,
@@ -32,7 +37,6 @@ func NewServer(version string, cfg Config, db *DB, auth *Auth, storage Storage,
 		tmpl:    tmpl,
 		js:      js,
 		css:     css,
-		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 
 	s.server = &http.Server{
@@ -44,6 +48,8 @@ func NewServer(version string, cfg Config, db *DB, auth *Auth, storage Storage,
 }
 
 type Server struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
 	version string
 	cfg     Config
 	db      *DB
@@ -56,16 +62,17 @@ type Server struct {
 	tmpl    ExecuteTemplateFunc
 	js      WriterFunc
 	css     WriterFunc
-	rand    *rand.Rand
 }
 
 func (s *Server) Start() {
+	go s.cleanup()
 	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		slog.Error("Error while listening", slog.Any("err", err))
 	}
 }
 
 func (s *Server) Close() {
+	s.cancel()
 	if err := s.server.Close(); err != nil {
 		slog.Error("Error while closing server", slog.Any("err", err))
 	}
@@ -75,10 +82,16 @@ func (s *Server) Close() {
 	}
 }
 
+// newID returns a random ID. It is safe for concurrent use, but use newSecret for anything which grants access.
 func (s *Server) newID(length int) string {
 	b := make([]rune, length)
+	limit := big.NewInt(int64(len(letters)))
 	for i := range b {
-		b[i] = letters[s.rand.Intn(len(letters))]
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			panic(fmt.Errorf("error reading random bytes: %w", err))
+		}
+		b[i] = letters[n.Int64()]
 	}
 	return string(b)
 }
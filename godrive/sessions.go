@@ -0,0 +1,154 @@
+package godrive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSessionLifespan is used when no refresh token lifespan is configured.
+	defaultSessionLifespan = 30 * 24 * time.Hour
+	// stateLifespan is how long a login can take between redirecting to the OIDC provider and the callback.
+	stateLifespan = 10 * time.Minute
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrStateNotFound   = errors.New("state not found")
+)
+
+// Session is a login session. Sessions of OIDC users keep the tokens of the provider, sessions of local users only the UserID.
+type Session struct {
+	ID           string    `db:"id"`
+	UserID       string    `db:"user_id"`
+	AccessToken  string    `db:"access_token"`
+	Expiry       time.Time `db:"expiry"`
+	RefreshToken string    `db:"refresh_token"`
+	IDToken      string    `db:"id_token"`
+	ExpiresAt    time.Time `db:"expires_at"`
+}
+
+// SessionStore keeps login sessions and the state <-> nonce pairs of pending OIDC logins.
+type SessionStore interface {
+	GetSession(ctx context.Context, id string) (*Session, error)
+	SetSession(ctx context.Context, session Session) error
+	DeleteSession(ctx context.Context, id string) error
+	// DeleteUserSessions deletes all sessions of a local user.
+	DeleteUserSessions(ctx context.Context, userID string) error
+
+	SetState(ctx context.Context, state string, nonce string, expiresAt time.Time) error
+	// PopState returns the nonce of the state and deletes it, so every state can only be used once.
+	PopState(ctx context.Context, state string) (string, error)
+
+	DeleteExpiredSessions(ctx context.Context, before time.Time) error
+}
+
+// NewSessionStore creates the configured session store. Only the database store can be shared between multiple instances.
+func NewSessionStore(cfg AuthConfig, db *DB) (SessionStore, error) {
+	switch cfg.SessionStore {
+	case SessionStoreTypeDatabase, "":
+		return db, nil
+	case SessionStoreTypeMemory:
+		return NewMemorySessionStore(), nil
+	}
+	return nil, fmt.Errorf("unknown session store type: %s", cfg.SessionStore)
+}
+
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{
+		sessions: map[string]Session{},
+		states:   map[string]memoryState{},
+	}
+}
+
+type memoryState struct {
+	nonce     string
+	expiresAt time.Time
+}
+
+type memorySessionStore struct {
+	// session id <-> session
+	sessions   map[string]Session
+	sessionsMu sync.Mutex
+	// state <-> nonce
+	states   map[string]memoryState
+	statesMu sync.Mutex
+}
+
+func (m *memorySessionStore) GetSession(_ context.Context, id string) (*Session, error) {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok || session.ExpiresAt.Before(time.Now()) {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (m *memorySessionStore) SetSession(_ context.Context, session Session) error {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+	m.sessions[session.ID] = session
+	return nil
+}
+
+func (m *memorySessionStore) DeleteSession(_ context.Context, id string) error {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memorySessionStore) DeleteUserSessions(_ context.Context, userID string) error {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+	for id, session := range m.sessions {
+		if session.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *memorySessionStore) SetState(_ context.Context, state string, nonce string, expiresAt time.Time) error {
+	m.statesMu.Lock()
+	defer m.statesMu.Unlock()
+	m.states[state] = memoryState{
+		nonce:     nonce,
+		expiresAt: expiresAt,
+	}
+	return nil
+}
+
+func (m *memorySessionStore) PopState(_ context.Context, state string) (string, error) {
+	m.statesMu.Lock()
+	defer m.statesMu.Unlock()
+	s, ok := m.states[state]
+	if !ok || s.expiresAt.Before(time.Now()) {
+		return "", ErrStateNotFound
+	}
+	delete(m.states, state)
+	return s.nonce, nil
+}
+
+func (m *memorySessionStore) DeleteExpiredSessions(_ context.Context, before time.Time) error {
+	m.sessionsMu.Lock()
+	for id, session := range m.sessions {
+		if session.ExpiresAt.Before(before) {
+			delete(m.sessions, id)
+		}
+	}
+	m.sessionsMu.Unlock()
+
+	m.statesMu.Lock()
+	for state, s := range m.states {
+		if s.expiresAt.Before(before) {
+			delete(m.states, state)
+		}
+	}
+	m.statesMu.Unlock()
+	return nil
+}
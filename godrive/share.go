@@ -0,0 +1,481 @@
+package godrive
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/slog"
+)
+
+const ShareCookiePrefix = "X-Share-"
+
+var (
+	ErrShareExpired         = errors.New("share expired")
+	ErrShareReadOnly        = errors.New("share does not allow uploads")
+	ErrSharePasswordInvalid = errors.New("invalid password")
+)
+
+// PublicShareRoutes serves shared files and folders to anyone who knows the share token. These routes bypass the normal authentication.
+func (s *Server) PublicShareRoutes(r chi.Router) {
+	r.Get("/{token}", s.GetShare)
+	r.Head("/{token}", s.GetShare)
+	r.Post("/{token}", s.PostShare)
+	r.Get("/{token}/*", s.GetShare)
+	r.Head("/{token}/*", s.GetShare)
+	r.Post("/{token}/*", s.PostShare)
+}
+
+// ShareRoutes lets users manage their share links.
+func (s *Server) ShareRoutes(r chi.Router) {
+	r.Get("/", s.GetShares)
+	r.Post("/", s.CreateShare)
+	r.Delete("/{token}", s.DeleteShare)
+}
+
+func (s *Server) GetShare(w http.ResponseWriter, r *http.Request) {
+	share, sharePath, ok := s.lookupShare(w, r, s.prettyError)
+	if !ok {
+		return
+	}
+	if !s.authorizeShare(w, r, *share, s.prettyError) {
+		return
+	}
+	download, filesFilter := parseDownload(r)
+
+	files, err := s.db.FindFiles(r.Context(), sharePath)
+	if err != nil {
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	// shares never show more than their owner can read
+	a, err := s.getUserAccess(r.Context(), share.UserID)
+	if err != nil {
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	files = a.readable(files)
+	if len(files) == 0 {
+		s.notFound(w, r)
+		return
+	}
+
+	if len(files) == 1 && files[0].Path == sharePath {
+		if r.Method != http.MethodHead && isFirstRange(r) && !s.countShareDownload(w, r, *share) {
+			return
+		}
+		s.serveFile(w, r, files[0], download)
+		return
+	}
+
+	if download {
+		if r.Method != http.MethodHead && !s.countShareDownload(w, r, *share) {
+			return
+		}
+		s.serveZip(w, r, shareName(sharePath), files, sharePath, path.Dir(sharePath), filesFilter)
+		return
+	}
+
+	templateFiles := toTemplateFiles(files, sharePath, func(file File) bool {
+		return false
+	})
+	for i := range templateFiles {
+		templateFiles[i].Path = shareURL(*share, templateFiles[i].Path)
+		templateFiles[i].Dir = shareURL(*share, templateFiles[i].Dir)
+	}
+
+	relPath := strings.TrimPrefix(sharePath, share.Path)
+	s.renderShare(w, r, http.StatusOK, ShareVariables{
+		Token:     share.Token,
+		Name:      shareName(share.Path),
+		Path:      shareURL(*share, sharePath),
+		PathParts: strings.FieldsFunc(relPath, func(r rune) bool { return r == '/' }),
+		Files:     templateFiles,
+		CanUpload: share.Permission == SharePermissionUpload,
+		ExpiresAt: share.ExpiresAt,
+	})
+}
+
+// PostShare either unlocks a password protected share via the password form or uploads a file into a share which allows uploads.
+func (s *Server) PostShare(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		s.unlockShare(w, r)
+		return
+	}
+
+	share, sharePath, ok := s.lookupShare(w, r, s.error)
+	if !ok {
+		return
+	}
+	if !s.authorizeShare(w, r, *share, s.error) {
+		return
+	}
+	if share.Permission != SharePermissionUpload {
+		s.error(w, r, ErrShareReadOnly, http.StatusForbidden)
+		return
+	}
+	if _, err := s.db.GetFile(r.Context(), sharePath); err == nil {
+		s.error(w, r, errors.New("can not upload into a file"), http.StatusBadRequest)
+		return
+	}
+
+	file, err := s.parseMultipartBody(r, sharePath)
+	if err != nil {
+		s.uploadError(w, r, err)
+		return
+	}
+	defer file.Content.Close()
+
+	a, err := s.getUserAccess(r.Context(), share.UserID)
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if !a.canUpload(file.Path) {
+		s.error(w, r, uploadDenied(file.Path), http.StatusForbidden)
+		return
+	}
+
+	if _, err = s.db.GetFile(r.Context(), file.Path); err == nil {
+		s.error(w, r, ErrFileAlreadyExists, http.StatusConflict)
+		return
+	} else if !errors.Is(err, ErrFileNotFound) {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err = s.cfg.Upload.check(file.Path, file.Size, file.ContentType); err != nil {
+		s.uploadError(w, r, err)
+		return
+	}
+	// uploaded files are owned by the owner of the share
+	if !s.hasQuota(w, r, share.UserID, file.Size) {
+		return
+	}
+	// create the file first, so an existing file is never overwritten
+	if _, err = s.db.CreateFile(r.Context(), file.Path, file.Size, file.ContentType, file.Description, share.UserID); err != nil {
+		s.uploadError(w, r, err)
+		return
+	}
+	if err = s.storage.PutObject(r.Context(), file.Path, file.Size, file.Content, file.ContentType); err != nil {
+		if dbErr := s.db.DeleteFile(r.Context(), file.Path); dbErr != nil {
+			slog.ErrorCtx(r.Context(), "failed to delete file after failed upload", slog.String("path", file.Path), slog.Any("err", dbErr))
+		}
+		s.uploadError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) unlockShare(w http.ResponseWriter, r *http.Request) {
+	share, _, ok := s.lookupShare(w, r, s.prettyError)
+	if !ok {
+		return
+	}
+	if share.Password == "" {
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(share.Password), []byte(r.PostFormValue("password"))) != nil {
+		s.renderShare(w, r, http.StatusUnauthorized, ShareVariables{
+			Token:            share.Token,
+			Name:             shareName(share.Path),
+			PasswordRequired: true,
+			Error:            ErrSharePasswordInvalid.Error(),
+		})
+		return
+	}
+
+	s.setShareCookie(w, *share)
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
+
+func (s *Server) GetShares(w http.ResponseWriter, r *http.Request) {
+	userInfo := GetUserInfo(r)
+	var userID string
+	if !s.isAdmin(userInfo) {
+		userID = userInfo.Subject
+	}
+	shares, err := s.db.GetShares(r.Context(), userID)
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	filterPath := r.URL.Query().Get("path")
+	response := make([]ShareResponse, 0, len(shares))
+	for _, share := range shares {
+		if filterPath != "" && share.Path != filterPath {
+			continue
+		}
+		response = append(response, toShareResponse(share))
+	}
+	s.ok(w, r, response)
+}
+
+func (s *Server) CreateShare(w http.ResponseWriter, r *http.Request) {
+	var shareRq ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&shareRq); err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	userInfo := GetUserInfo(r)
+	if s.cfg.Auth != nil && s.isGuest(userInfo) {
+		s.error(w, r, errors.New("guests can not create shares"), http.StatusForbidden)
+		return
+	}
+
+	sharePath := path.Join("/", shareRq.Path)
+	if isInternalPath(sharePath) {
+		s.error(w, r, ErrReservedPath, http.StatusBadRequest)
+		return
+	}
+	if shareRq.Permission == "" {
+		shareRq.Permission = SharePermissionRead
+	}
+	if shareRq.Permission != SharePermissionRead && shareRq.Permission != SharePermissionUpload {
+		s.error(w, r, errors.New("invalid permission"), http.StatusBadRequest)
+		return
+	}
+	if shareRq.ExpiresAt != nil && shareRq.ExpiresAt.Before(time.Now()) {
+		s.error(w, r, errors.New("expiry must be in the future"), http.StatusBadRequest)
+		return
+	}
+
+	files, err := s.db.FindFiles(r.Context(), sharePath)
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	files = a.readable(files)
+	if len(files) == 0 {
+		s.error(w, r, ErrFileNotFound, http.StatusNotFound)
+		return
+	}
+	if shareRq.Permission == SharePermissionUpload && !a.canUpload(sharePath) {
+		s.error(w, r, fmt.Errorf("%w: you can not upload to %s", ErrAccessDenied, sharePath), http.StatusForbidden)
+		return
+	}
+	if shareRq.Permission == SharePermissionUpload && len(files) == 1 && files[0].Path == sharePath {
+		s.error(w, r, errors.New("only folders can be shared with upload permission"), http.StatusBadRequest)
+		return
+	}
+
+	var passwordHash string
+	if shareRq.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(shareRq.Password), bcrypt.DefaultCost)
+		if err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		passwordHash = string(hash)
+	}
+
+	token, err := newSecret()
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	share, err := s.db.CreateShare(r.Context(), Share{
+		Token:        token,
+		Path:         sharePath,
+		UserID:       userInfo.Subject,
+		Username:     &userInfo.Username,
+		Password:     passwordHash,
+		Permission:   shareRq.Permission,
+		MaxDownloads: shareRq.MaxDownloads,
+		ExpiresAt:    shareRq.ExpiresAt,
+	})
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.json(w, r, toShareResponse(*share), http.StatusCreated)
+}
+
+func (s *Server) DeleteShare(w http.ResponseWriter, r *http.Request) {
+	share, err := s.db.GetShare(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if errors.Is(err, ErrShareNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	userInfo := GetUserInfo(r)
+	if share.UserID != userInfo.Subject && !s.isAdmin(userInfo) {
+		s.error(w, r, ErrShareNotFound, http.StatusNotFound)
+		return
+	}
+
+	if err = s.db.DeleteShare(r.Context(), share.Token); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookupShare resolves the share of the request and the requested path inside it.
+func (s *Server) lookupShare(w http.ResponseWriter, r *http.Request, errorFunc func(w http.ResponseWriter, r *http.Request, err error, status int)) (*Share, string, bool) {
+	share, err := s.db.GetShare(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if errors.Is(err, ErrShareNotFound) {
+			errorFunc(w, r, err, http.StatusNotFound)
+			return nil, "", false
+		}
+		errorFunc(w, r, err, http.StatusInternalServerError)
+		return nil, "", false
+	}
+	if share.ExpiresAt != nil && share.ExpiresAt.Before(time.Now()) {
+		errorFunc(w, r, ErrShareExpired, http.StatusGone)
+		return nil, "", false
+	}
+
+	sharePath := path.Join(share.Path, chi.URLParam(r, "*"))
+	if sharePath != share.Path && !strings.HasPrefix(sharePath, strings.TrimSuffix(share.Path, "/")+"/") {
+		errorFunc(w, r, ErrShareNotFound, http.StatusNotFound)
+		return nil, "", false
+	}
+	return share, sharePath, true
+}
+
+// authorizeShare checks the password of a protected share. The password is accepted from a cookie set by the password form or via basic auth.
+// Browsers without any credentials get the password form.
+func (s *Server) authorizeShare(w http.ResponseWriter, r *http.Request, share Share, errorFunc func(w http.ResponseWriter, r *http.Request, err error, status int)) bool {
+	if share.Password == "" {
+		return true
+	}
+	if cookie, err := r.Cookie(ShareCookiePrefix + share.Token); err == nil && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(shareCookieValue(share))) == 1 {
+		return true
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		if bcrypt.CompareHashAndPassword([]byte(share.Password), []byte(password)) == nil {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="godrive share"`)
+		errorFunc(w, r, ErrSharePasswordInvalid, http.StatusUnauthorized)
+		return false
+	}
+
+	if r.Method == http.MethodGet {
+		s.renderShare(w, r, http.StatusUnauthorized, ShareVariables{
+			Token:            share.Token,
+			Name:             shareName(share.Path),
+			PasswordRequired: true,
+		})
+		return false
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="godrive share"`)
+	errorFunc(w, r, errors.New("password required"), http.StatusUnauthorized)
+	return false
+}
+
+func (s *Server) countShareDownload(w http.ResponseWriter, r *http.Request, share Share) bool {
+	if err := s.db.IncrementShareDownloads(r.Context(), share.Token); err != nil {
+		if errors.Is(err, ErrShareExhausted) {
+			s.prettyError(w, r, err, http.StatusGone)
+			return false
+		}
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (s *Server) setShareCookie(w http.ResponseWriter, share Share) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ShareCookiePrefix + share.Token,
+		Value:    shareCookieValue(share),
+		Path:     "/s/" + share.Token,
+		Expires:  time.Now().Add(24 * time.Hour),
+		Secure:   s.cfg.Auth != nil && s.cfg.Auth.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) renderShare(w http.ResponseWriter, r *http.Request, status int, vars ShareVariables) {
+	vars.BaseVariables = BaseVariables{
+		Theme: "dark",
+	}
+	w.WriteHeader(status)
+	if err := s.tmpl(w, "share.gohtml", vars); err != nil {
+		slog.ErrorCtx(r.Context(), "error executing template", slog.Any("err", err))
+	}
+}
+
+func (s *Server) deleteExpiredShares(ctx context.Context, now time.Time) {
+	if _, err := s.db.DeleteExpiredShares(ctx, now); err != nil {
+		slog.ErrorCtx(ctx, "failed to delete expired shares", slog.Any("err", err))
+	}
+}
+
+// shareCookieValue derives the cookie value from the password hash, so changing the password invalidates existing cookies.
+func shareCookieValue(share Share) string {
+	sum := sha256.Sum256([]byte(share.Token + ":" + share.Password))
+	return hex.EncodeToString(sum[:])
+}
+
+// shareURL maps a path inside the share to its public url.
+func shareURL(share Share, filePath string) string {
+	return path.Join("/s", share.Token, strings.TrimPrefix(filePath, share.Path))
+}
+
+func shareName(sharePath string) string {
+	if sharePath == "/" {
+		return "godrive"
+	}
+	return path.Base(sharePath)
+}
+
+func toShareResponse(share Share) ShareResponse {
+	owner := "Unknown"
+	if share.Username != nil {
+		owner = *share.Username
+	}
+	return ShareResponse{
+		Token:        share.Token,
+		URL:          "/s/" + share.Token,
+		Path:         share.Path,
+		Owner:        owner,
+		Password:     share.Password != "",
+		Permission:   share.Permission,
+		MaxDownloads: share.MaxDownloads,
+		Downloads:    share.Downloads,
+		ExpiresAt:    share.ExpiresAt,
+		CreatedAt:    share.CreatedAt,
+	}
+}
+
+// isFirstRange reports whether the request reads a file from the start, so that resumed range requests don't count as separate downloads.
+func isFirstRange(r *http.Request) bool {
+	start, end, err := parseRange(r.Header.Get("Range"))
+	if err != nil {
+		return true
+	}
+	if start == nil {
+		return end == nil
+	}
+	return *start == 0
+}
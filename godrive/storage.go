@@ -2,11 +2,21 @@ package godrive
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -14,8 +24,55 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
 )
 
+// internalPathPrefix is the prefix of all objects godrive stores which are not files, like partial uploads.
+// Files can not be created below it.
+const internalPathPrefix = "/.godrive"
+
+const (
+	// localTempSuffix marks files in the local storage which are still being written.
+	localTempSuffix = ".godrive-tmp"
+	// localTempMaxAge is how old a temporary file has to be before it is considered left behind by a crash.
+	localTempMaxAge = time.Hour
+)
+
+var ErrReservedPath = errors.New("path is reserved")
+
+func isInternalPath(filePath string) bool {
+	return filePath == internalPathPrefix || strings.HasPrefix(filePath, internalPathPrefix+"/")
+}
+
+// reservedNames are the top-level routes of the server. Files and folders with these names would be hidden by the routes.
+var reservedNames = []string{
+	"acl", "api", "assets", "callback", "debug", "favicon.ico", "favicon.png", "favicon-light.png", "invite", "login", "logout",
+	"ping", "reset", "robots.txt", "s", "settings", "shares", "trash", "tus", "uploads", "version", "versions",
+}
+
+// isReservedPath reports whether files can not be created at filePath because it is internal or below a top-level route.
+func isReservedPath(filePath string) bool {
+	if isInternalPath(filePath) {
+		return true
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(filePath, "/"), "/")
+	return slices.Contains(reservedNames, name)
+}
+
+// contentTypeByExtension guesses the content type of objects which have none stored.
+func contentTypeByExtension(filePath string) string {
+	if contentType := mime.TypeByExtension(path.Ext(filePath)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// isObjectNotExist reports whether err means that an object does not exist in any storage.
+func isObjectNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
 func NewStorage(ctx context.Context, config StorageConfig, tracer trace.Tracer) (Storage, error) {
 	switch config.Type {
 	case StorageTypeLocal:
@@ -27,10 +84,46 @@ func NewStorage(ctx context.Context, config StorageConfig, tracer trace.Tracer)
 }
 
 type Storage interface {
+	// GetObject reads an object. If start and end are both set only the bytes from start to end are read,
+	// end is inclusive like in HTTP ranges and S3.
 	GetObject(ctx context.Context, filePath string, start *int64, end *int64) (io.ReadCloser, error)
 	MoveObject(ctx context.Context, from string, to string) error
 	PutObject(ctx context.Context, filePath string, size uint64, reader io.Reader, contentType string) error
 	DeleteObject(ctx context.Context, filePath string) error
+	// CopyObject copies an object to another path, replacing any object stored there.
+	CopyObject(ctx context.Context, from string, to string) error
+	// StatObject returns the metadata of an object without reading its content.
+	StatObject(ctx context.Context, filePath string) (*ObjectInfo, error)
+	// ListObjects calls fn for every object whose path starts with prefix. Listing stops at the first error returned by fn.
+	ListObjects(ctx context.Context, prefix string, fn func(info ObjectInfo) error) error
+}
+
+// Presigner is implemented by storages which can create URLs for clients to access objects directly instead of through godrive.
+type Presigner interface {
+	// PresignGetObject returns a URL to download an object. params can override response headers like response-content-disposition.
+	PresignGetObject(ctx context.Context, filePath string, expiry time.Duration, params url.Values) (string, error)
+	// PresignPutObject returns a URL to upload an object with a single PUT request.
+	PresignPutObject(ctx context.Context, filePath string, expiry time.Duration) (string, error)
+	// NewMultipartUpload starts an upload whose parts are uploaded to URLs returned by PresignUploadPart.
+	NewMultipartUpload(ctx context.Context, filePath string, contentType string) (string, error)
+	PresignUploadPart(ctx context.Context, filePath string, uploadID string, part int, expiry time.Duration) (string, error)
+	CompleteMultipartUpload(ctx context.Context, filePath string, uploadID string, parts []UploadPart) error
+	AbortMultipartUpload(ctx context.Context, filePath string, uploadID string) error
+}
+
+// UploadPart is a part of a multipart upload with the ETag returned by the storage for it.
+type UploadPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+}
+
+type ObjectInfo struct {
+	Path         string
+	Size         uint64
+	LastModified time.Time
+	// ETag changes whenever the content changes. It is not a hash of the content for local storages.
+	ETag        string
+	ContentType string
 }
 
 func newLocalStorage(config StorageConfig, tracer trace.Tracer) (Storage, error) {
@@ -79,7 +172,7 @@ func (l *localStorage) GetObject(ctx context.Context, filePath string, start *in
 		}
 
 		return &limitedReader{
-			Reader: io.LimitReader(file, *end-*start),
+			Reader: io.LimitReader(file, *end-*start+1),
 			closeFunc: func() error {
 				return file.Close()
 			},
@@ -109,25 +202,65 @@ func (l *localStorage) PutObject(ctx context.Context, filePath string, size uint
 	))
 	defer span.End()
 
-	if err := os.MkdirAll(path.Dir(l.path+filePath), 0777); err != nil {
-		span.SetStatus(codes.Error, "failed to create directory")
+	if err := l.putObject(l.path+filePath, size, reader); err != nil {
+		span.SetStatus(codes.Error, "failed to write file")
 		span.RecordError(err)
 		return err
 	}
-	file, err := os.Create(l.path + filePath)
+	return nil
+}
+
+// putObject writes to a temporary file next to the destination and renames it into place once all bytes are written and synced,
+// so the destination either keeps its old content or has the complete new content.
+func (l *localStorage) putObject(name string, size uint64, reader io.Reader) (err error) {
+	dir := filepath.Dir(name)
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	b := make([]byte, 8)
+	if _, err = rand.Read(b); err != nil {
+		return err
+	}
+	tmpName := filepath.Join(dir, "."+filepath.Base(name)+"."+hex.EncodeToString(b)+localTempSuffix)
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
 	if err != nil {
-		span.SetStatus(codes.Error, "failed to create file")
-		span.RecordError(err)
 		return err
 	}
-	defer file.Close()
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(tmpName)
+		}
+	}()
 
-	_, err = io.Copy(file, reader)
+	n, err := io.Copy(file, reader)
 	if err != nil {
-		span.SetStatus(codes.Error, "failed to copy file")
-		span.RecordError(err)
+		return err
 	}
-	return err
+	if uint64(n) != size {
+		return fmt.Errorf("expected %d bytes but got %d", size, n)
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, name); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir persists renames in dir.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
 func (l *localStorage) MoveObject(ctx context.Context, from string, to string) error {
@@ -146,7 +279,7 @@ func (l *localStorage) MoveObject(ctx context.Context, from string, to string) e
 		span.RecordError(err)
 		return err
 	}
-	return l.cleanup()
+	return nil
 }
 
 func (l *localStorage) DeleteObject(ctx context.Context, filePath string) error {
@@ -159,13 +292,126 @@ func (l *localStorage) DeleteObject(ctx context.Context, filePath string) error
 		span.RecordError(err)
 		return err
 	}
-	return l.cleanup()
+	return nil
 }
 
-func (l *localStorage) cleanup() error {
+func (l *localStorage) ListObjects(ctx context.Context, prefix string, fn func(info ObjectInfo) error) error {
+	ctx, span := l.tracer.Start(ctx, "localStorage.ListObjects", trace.WithAttributes(
+		attribute.String("prefix", prefix),
+	))
+	defer span.End()
+
+	// only walk the directory containing the prefix
+	root := l.path + prefix[:strings.LastIndex(prefix, "/")+1]
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && filePath == root {
+				return nil
+			}
+			return err
+		}
+		if err = ctx.Err(); err != nil || entry.IsDir() || strings.HasSuffix(entry.Name(), localTempSuffix) {
+			return err
+		}
+		rel, err := filepath.Rel(l.path, filePath)
+		if err != nil {
+			return err
+		}
+		objectPath := "/" + filepath.ToSlash(rel)
+		if !strings.HasPrefix(objectPath, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(localObjectInfo(objectPath, info))
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to list objects")
+		span.RecordError(err)
+	}
+	return err
+}
+
+func (l *localStorage) CopyObject(ctx context.Context, from string, to string) error {
+	ctx, span := l.tracer.Start(ctx, "localStorage.CopyObject", trace.WithAttributes(
+		attribute.String("from", from),
+		attribute.String("to", to),
+	))
+	defer span.End()
+
+	file, err := os.Open(l.path + from)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to open file")
+		span.RecordError(err)
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to stat file")
+		span.RecordError(err)
+		return err
+	}
+	if err = l.putObject(l.path+to, uint64(info.Size()), file); err != nil {
+		span.SetStatus(codes.Error, "failed to write file")
+		span.RecordError(err)
+		return err
+	}
 	return nil
 }
 
+func (l *localStorage) StatObject(ctx context.Context, filePath string) (*ObjectInfo, error) {
+	ctx, span := l.tracer.Start(ctx, "localStorage.StatObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+	))
+	defer span.End()
+
+	info, err := os.Stat(l.path + filePath)
+	if err == nil && info.IsDir() {
+		err = &fs.PathError{Op: "stat", Path: l.path + filePath, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to stat file")
+		span.RecordError(err)
+		return nil, err
+	}
+	objectInfo := localObjectInfo(filePath, info)
+	return &objectInfo, nil
+}
+
+// localObjectInfo derives the ETag from the modification time and size like most web servers do for static files.
+func localObjectInfo(filePath string, info fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Path:         filePath,
+		Size:         uint64(info.Size()),
+		LastModified: info.ModTime(),
+		ETag:         fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+		ContentType:  contentTypeByExtension(filePath),
+	}
+}
+
+// cleanup removes temporary files left behind by writes which were interrupted by a crash.
+// Recent temporary files are kept, since commands like fsck create a storage while the server may still be writing.
+func (l *localStorage) cleanup() error {
+	staleBefore := time.Now().Add(-localTempMaxAge)
+	return filepath.WalkDir(l.path, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), localTempSuffix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(staleBefore) {
+			return err
+		}
+		slog.Info("Removing stale temporary file", slog.String("path", filePath))
+		return os.Remove(filePath)
+	})
+}
+
 func newS3Storage(ctx context.Context, config StorageConfig, tracer trace.Tracer) (Storage, error) {
 	client, err := minio.New(config.Endpoint, &minio.Options{
 		Creds:     credentials.NewStaticV4(config.AccessKeyID, config.SecretAccessKey, ""),
@@ -189,17 +435,36 @@ func newS3Storage(ctx context.Context, config StorageConfig, tracer trace.Tracer
 		}
 	}
 
+	// presigned URLs are signed for the host clients use, which may differ from the endpoint godrive uses
+	presignClient := client
+	if config.Presign != nil && config.Presign.PublicURL != "" {
+		publicURL, err := url.Parse(config.Presign.PublicURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid presign public url: %w", err)
+		}
+		presignClient, err = minio.New(publicURL.Host, &minio.Options{
+			Creds:  credentials.NewStaticV4(config.AccessKeyID, config.SecretAccessKey, ""),
+			Secure: publicURL.Scheme == "https",
+			Region: config.Region,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &s3Storage{
-		client: client,
-		bucket: config.Bucket,
-		tracer: tracer,
+		client:        client,
+		presignClient: presignClient,
+		bucket:        config.Bucket,
+		tracer:        tracer,
 	}, nil
 }
 
 type s3Storage struct {
-	client *minio.Client
-	bucket string
-	tracer trace.Tracer
+	client        *minio.Client
+	presignClient *minio.Client
+	bucket        string
+	tracer        trace.Tracer
 }
 
 func (s *s3Storage) GetObject(ctx context.Context, filePath string, start *int64, end *int64) (io.ReadCloser, error) {
@@ -285,3 +550,174 @@ func (s *s3Storage) DeleteObject(ctx context.Context, filePath string) error {
 	}
 	return err
 }
+
+func (s *s3Storage) ListObjects(ctx context.Context, prefix string, fn func(info ObjectInfo) error) error {
+	ctx, span := s.tracer.Start(ctx, "s3Storage.ListObjects", trace.WithAttributes(
+		attribute.String("prefix", prefix),
+	))
+	defer span.End()
+
+	// cancel the listing if fn returns early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			span.SetStatus(codes.Error, "failed to list objects")
+			span.RecordError(object.Err)
+			return object.Err
+		}
+		if err := fn(s3ObjectInfo(object)); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *s3Storage) CopyObject(ctx context.Context, from string, to string) error {
+	ctx, span := s.tracer.Start(ctx, "s3Storage.CopyObject", trace.WithAttributes(
+		attribute.String("from", from),
+		attribute.String("to", to),
+	))
+	defer span.End()
+	_, err := s.client.CopyObject(ctx, minio.CopyDestOptions{
+		Bucket: s.bucket,
+		Object: to,
+	}, minio.CopySrcOptions{
+		Bucket: s.bucket,
+		Object: from,
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to copy object")
+		span.RecordError(err)
+	}
+	return err
+}
+
+func (s *s3Storage) StatObject(ctx context.Context, filePath string) (*ObjectInfo, error) {
+	ctx, span := s.tracer.Start(ctx, "s3Storage.StatObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+	))
+	defer span.End()
+	object, err := s.client.StatObject(ctx, s.bucket, filePath, minio.StatObjectOptions{})
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to stat object")
+		span.RecordError(err)
+		return nil, err
+	}
+	info := s3ObjectInfo(object)
+	return &info, nil
+}
+
+// s3ObjectInfo converts listed or stat'ed objects. Listed objects have no content type, it is guessed from the extension instead.
+func s3ObjectInfo(object minio.ObjectInfo) ObjectInfo {
+	info := ObjectInfo{
+		Path:         "/" + strings.TrimPrefix(object.Key, "/"),
+		Size:         uint64(object.Size),
+		LastModified: object.LastModified,
+		ETag:         object.ETag,
+		ContentType:  object.ContentType,
+	}
+	if info.ContentType == "" {
+		info.ContentType = contentTypeByExtension(info.Path)
+	}
+	return info
+}
+
+func (s *s3Storage) PresignGetObject(ctx context.Context, filePath string, expiry time.Duration, params url.Values) (string, error) {
+	ctx, span := s.tracer.Start(ctx, "s3Storage.PresignGetObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+	))
+	defer span.End()
+	u, err := s.presignClient.PresignedGetObject(ctx, s.bucket, filePath, expiry, params)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to presign object")
+		span.RecordError(err)
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *s3Storage) PresignPutObject(ctx context.Context, filePath string, expiry time.Duration) (string, error) {
+	ctx, span := s.tracer.Start(ctx, "s3Storage.PresignPutObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+	))
+	defer span.End()
+	u, err := s.presignClient.PresignedPutObject(ctx, s.bucket, filePath, expiry)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to presign object")
+		span.RecordError(err)
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *s3Storage) NewMultipartUpload(ctx context.Context, filePath string, contentType string) (string, error) {
+	ctx, span := s.tracer.Start(ctx, "s3Storage.NewMultipartUpload", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+		attribute.String("contentType", contentType),
+	))
+	defer span.End()
+	uploadID, err := minio.Core{Client: s.client}.NewMultipartUpload(ctx, s.bucket, filePath, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to create multipart upload")
+		span.RecordError(err)
+	}
+	return uploadID, err
+}
+
+func (s *s3Storage) PresignUploadPart(ctx context.Context, filePath string, uploadID string, part int, expiry time.Duration) (string, error) {
+	ctx, span := s.tracer.Start(ctx, "s3Storage.PresignUploadPart", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+		attribute.Int("part", part),
+	))
+	defer span.End()
+	u, err := s.presignClient.Presign(ctx, http.MethodPut, s.bucket, filePath, expiry, url.Values{
+		"partNumber": {strconv.Itoa(part)},
+		"uploadId":   {uploadID},
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to presign upload part")
+		span.RecordError(err)
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *s3Storage) CompleteMultipartUpload(ctx context.Context, filePath string, uploadID string, parts []UploadPart) error {
+	ctx, span := s.tracer.Start(ctx, "s3Storage.CompleteMultipartUpload", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+		attribute.Int("parts", len(parts)),
+	))
+	defer span.End()
+	completeParts := make([]minio.CompletePart, len(parts))
+	for i, part := range parts {
+		completeParts[i] = minio.CompletePart{
+			PartNumber: part.Number,
+			ETag:       part.ETag,
+		}
+	}
+	_, err := minio.Core{Client: s.client}.CompleteMultipartUpload(ctx, s.bucket, filePath, uploadID, completeParts, minio.PutObjectOptions{})
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to complete multipart upload")
+		span.RecordError(err)
+	}
+	return err
+}
+
+func (s *s3Storage) AbortMultipartUpload(ctx context.Context, filePath string, uploadID string) error {
+	ctx, span := s.tracer.Start(ctx, "s3Storage.AbortMultipartUpload", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+	))
+	defer span.End()
+	err := minio.Core{Client: s.client}.AbortMultipartUpload(ctx, s.bucket, filePath, uploadID)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to abort multipart upload")
+		span.RecordError(err)
+	}
+	return err
+}
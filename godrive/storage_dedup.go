@@ -0,0 +1,298 @@
+package godrive
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+)
+
+// blobsPath is where the deduplicating storage keeps the content of all objects.
+const blobsPath = internalPathPrefix + "/blobs"
+
+func blobPath(hash string) string {
+	return blobsPath + "/" + hash
+}
+
+// NewDedupStorage wraps a storage so every distinct content is only stored once.
+// Objects are stored as blobs named by the SHA-256 of their content and paths only reference them, which makes moving objects a database update.
+// Objects stored before deduplication was enabled are still read, moved and deleted directly.
+func NewDedupStorage(storage Storage, db *DB, tracer trace.Tracer) Storage {
+	return &dedupStorage{
+		storage: storage,
+		db:      db,
+		tracer:  tracer,
+	}
+}
+
+type dedupStorage struct {
+	storage Storage
+	db      *DB
+	tracer  trace.Tracer
+	// mu serializes storing and deleting blobs, so a blob is not deleted while another object referencing it is stored.
+	// This does not protect multiple instances sharing the same storage.
+	mu sync.Mutex
+}
+
+func (d *dedupStorage) GetObject(ctx context.Context, filePath string, start *int64, end *int64) (io.ReadCloser, error) {
+	ctx, span := d.tracer.Start(ctx, "dedupStorage.GetObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+	))
+	defer span.End()
+
+	hash, err := d.db.GetBlobRef(ctx, filePath)
+	if errors.Is(err, ErrBlobRefNotFound) {
+		return d.storage.GetObject(ctx, filePath, start, end)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to get blob ref")
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("hash", hash))
+	return d.storage.GetObject(ctx, blobPath(hash), start, end)
+}
+
+// PutObject stores the content in a temporary object first, since its hash is only known after reading it completely.
+func (d *dedupStorage) PutObject(ctx context.Context, filePath string, size uint64, reader io.Reader, contentType string) error {
+	ctx, span := d.tracer.Start(ctx, "dedupStorage.PutObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+		attribute.Int64("size", int64(size)),
+	))
+	defer span.End()
+
+	tmpPath, err := newBlobTmpPath()
+	if err != nil {
+		return err
+	}
+	hr := &hashReader{
+		Reader: reader,
+		hash:   sha256.New(),
+	}
+	if err = d.storage.PutObject(ctx, tmpPath, size, hr, contentType); err != nil {
+		span.SetStatus(codes.Error, "failed to put object")
+		span.RecordError(err)
+		return err
+	}
+	hash := hex.EncodeToString(hr.hash.Sum(nil))
+	span.SetAttributes(attribute.String("hash", hash))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	exists, err := d.db.HasBlob(ctx, hash)
+	if err != nil {
+		d.deleteTmp(ctx, tmpPath)
+		return err
+	}
+	if exists {
+		d.deleteTmp(ctx, tmpPath)
+	} else if err = d.storage.MoveObject(ctx, tmpPath, blobPath(hash)); err != nil {
+		span.SetStatus(codes.Error, "failed to move blob")
+		span.RecordError(err)
+		d.deleteTmp(ctx, tmpPath)
+		return err
+	}
+
+	released, err := d.db.SetBlobRef(ctx, filePath, hash, hr.n)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to set blob ref")
+		span.RecordError(err)
+		return err
+	}
+	return d.deleteBlob(ctx, released)
+}
+
+func (d *dedupStorage) MoveObject(ctx context.Context, from string, to string) error {
+	ctx, span := d.tracer.Start(ctx, "dedupStorage.MoveObject", trace.WithAttributes(
+		attribute.String("from", from),
+		attribute.String("to", to),
+	))
+	defer span.End()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	released, err := d.db.MoveBlobRef(ctx, from, to)
+	if errors.Is(err, ErrBlobRefNotFound) {
+		return d.storage.MoveObject(ctx, from, to)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to move blob ref")
+		span.RecordError(err)
+		return err
+	}
+	return d.deleteBlob(ctx, released)
+}
+
+func (d *dedupStorage) DeleteObject(ctx context.Context, filePath string) error {
+	ctx, span := d.tracer.Start(ctx, "dedupStorage.DeleteObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+	))
+	defer span.End()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	released, err := d.db.DeleteBlobRef(ctx, filePath)
+	if errors.Is(err, ErrBlobRefNotFound) {
+		return d.storage.DeleteObject(ctx, filePath)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to delete blob ref")
+		span.RecordError(err)
+		return err
+	}
+	return d.deleteBlob(ctx, released)
+}
+
+// CopyObject only adds a reference to the blob of the source, the content is not copied.
+func (d *dedupStorage) CopyObject(ctx context.Context, from string, to string) error {
+	ctx, span := d.tracer.Start(ctx, "dedupStorage.CopyObject", trace.WithAttributes(
+		attribute.String("from", from),
+		attribute.String("to", to),
+	))
+	defer span.End()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	released, err := d.db.CopyBlobRef(ctx, from, to)
+	if errors.Is(err, ErrBlobRefNotFound) {
+		return d.storage.CopyObject(ctx, from, to)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to copy blob ref")
+		span.RecordError(err)
+		return err
+	}
+	return d.deleteBlob(ctx, released)
+}
+
+// StatObject returns the metadata of the blob a path references with the path of the object.
+func (d *dedupStorage) StatObject(ctx context.Context, filePath string) (*ObjectInfo, error) {
+	ctx, span := d.tracer.Start(ctx, "dedupStorage.StatObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+	))
+	defer span.End()
+
+	hash, err := d.db.GetBlobRef(ctx, filePath)
+	if errors.Is(err, ErrBlobRefNotFound) {
+		return d.storage.StatObject(ctx, filePath)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to get blob ref")
+		span.RecordError(err)
+		return nil, err
+	}
+	info, err := d.storage.StatObject(ctx, blobPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	info.Path = filePath
+	info.ContentType = contentTypeByExtension(filePath)
+	return info, nil
+}
+
+// deleteBlob deletes a blob which is no longer referenced, hash may be empty.
+func (d *dedupStorage) deleteBlob(ctx context.Context, hash string) error {
+	if hash == "" {
+		return nil
+	}
+	if err := d.storage.DeleteObject(ctx, blobPath(hash)); err != nil {
+		return fmt.Errorf("failed to delete blob %s: %w", hash, err)
+	}
+	return nil
+}
+
+func (d *dedupStorage) deleteTmp(ctx context.Context, tmpPath string) {
+	if err := d.storage.DeleteObject(ctx, tmpPath); err != nil {
+		slog.ErrorCtx(ctx, "failed to delete temporary blob", slog.String("path", tmpPath), slog.Any("err", err))
+	}
+}
+
+func newBlobTmpPath() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return blobsPath + "/tmp/" + hex.EncodeToString(b), nil
+}
+
+// hashReader hashes and counts everything read through it.
+type hashReader struct {
+	io.Reader
+	hash hash.Hash
+	n    uint64
+}
+
+func (r *hashReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.hash.Write(p[:n])
+		r.n += uint64(n)
+	}
+	return n, err
+}
+
+// ListObjects lists the paths referencing a blob with the size of the blob and objects stored before deduplication was enabled.
+// Paths referencing a missing blob are skipped, blobs which are not referenced at all are listed with their blob path.
+func (d *dedupStorage) ListObjects(ctx context.Context, prefix string, fn func(info ObjectInfo) error) error {
+	ctx, span := d.tracer.Start(ctx, "dedupStorage.ListObjects", trace.WithAttributes(
+		attribute.String("prefix", prefix),
+	))
+	defer span.End()
+
+	refs, err := d.db.GetBlobRefs(ctx)
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]struct{}, len(refs))
+	for _, ref := range refs {
+		referenced[ref.Hash] = struct{}{}
+	}
+
+	blobs := map[string]ObjectInfo{}
+	if err = d.storage.ListObjects(ctx, "/", func(info ObjectInfo) error {
+		if hash, ok := strings.CutPrefix(info.Path, blobsPath+"/"); ok && !strings.Contains(hash, "/") {
+			blobs[hash] = info
+			if _, ok = referenced[hash]; ok {
+				return nil
+			}
+		}
+		if !strings.HasPrefix(info.Path, prefix) {
+			return nil
+		}
+		return fn(info)
+	}); err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		blob, ok := blobs[ref.Hash]
+		if !ok || !strings.HasPrefix(ref.Path, prefix) {
+			continue
+		}
+		if err = fn(ObjectInfo{
+			Path:         ref.Path,
+			Size:         blob.Size,
+			LastModified: blob.LastModified,
+			ETag:         blob.ETag,
+			ContentType:  contentTypeByExtension(ref.Path),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
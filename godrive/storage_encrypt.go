@@ -0,0 +1,570 @@
+package godrive
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Encrypted objects start with a header followed by the content encrypted with AES-GCM in chunks of encChunkSize bytes.
+//
+//	magic (4) | key id (8) | plaintext size (8) | wrapped data key: nonce (12) + sealed key (32 + 16)
+//
+// Every object has its own random data key which is wrapped with the master key, so the chunk index can be used as nonce.
+// Chunks are authenticated with their index, which prevents reordering, and the authenticated size prevents truncation.
+const (
+	encMagic          = "GDE1"
+	encKeyIDSize      = 8
+	encDataKeySize    = 32
+	encWrappedKeySize = 12 + encDataKeySize + 16
+	encHeaderSize     = len(encMagic) + encKeyIDSize + 8 + encWrappedKeySize
+	encChunkSize      = 64 * 1024
+	encTagSize        = 16
+)
+
+var errNotEncrypted = errors.New("object is not encrypted, run godrive rekey or allow plaintext objects")
+
+type encryptionKey struct {
+	id   [encKeyIDSize]byte
+	aead cipher.AEAD
+}
+
+// NewEncryptStorage wraps a storage so all objects are encrypted at rest. Ranged reads only fetch and decrypt the chunks they need.
+// Objects stored before encryption was enabled are only read unencrypted if the config allows plaintext, until they are encrypted with the rekey command.
+func NewEncryptStorage(storage Storage, cfg EncryptionConfig, tracer trace.Tracer) (Storage, error) {
+	rawKey, err := loadEncryptionKey(cfg.Key, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption key: %w", err)
+	}
+	if rawKey == nil {
+		return nil, errors.New("missing encryption key or key file")
+	}
+	current, err := newEncryptionKey(rawKey)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &encryptStorage{
+		storage:        storage,
+		tracer:         tracer,
+		current:        current,
+		allowPlaintext: cfg.AllowPlaintext,
+		keys: map[[encKeyIDSize]byte]*encryptionKey{
+			current.id: current,
+		},
+	}
+
+	var oldKeys [][]byte
+	for _, key := range cfg.OldKeys {
+		rawKey, err = loadEncryptionKey(key, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load old encryption key: %w", err)
+		}
+		oldKeys = append(oldKeys, rawKey)
+	}
+	for _, keyFile := range cfg.OldKeyFiles {
+		rawKey, err = loadEncryptionKey("", keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load old encryption key file: %w", err)
+		}
+		oldKeys = append(oldKeys, rawKey)
+	}
+	for _, rawKey = range oldKeys {
+		key, err := newEncryptionKey(rawKey)
+		if err != nil {
+			return nil, err
+		}
+		e.keys[key.id] = key
+	}
+
+	return e, nil
+}
+
+func loadEncryptionKey(key string, keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 32 {
+			return data, nil
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		return nil, nil
+	}
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	return rawKey, nil
+}
+
+func newEncryptionKey(rawKey []byte) (*encryptionKey, error) {
+	if len(rawKey) != 32 {
+		return nil, fmt.Errorf("encryption keys must be 32 bytes, got %d", len(rawKey))
+	}
+	aead, err := newAEAD(rawKey)
+	if err != nil {
+		return nil, err
+	}
+	key := &encryptionKey{aead: aead}
+	sum := sha256.Sum256(rawKey)
+	copy(key.id[:], sum[:])
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type encryptStorage struct {
+	storage        Storage
+	tracer         trace.Tracer
+	current        *encryptionKey
+	allowPlaintext bool
+	keys           map[[encKeyIDSize]byte]*encryptionKey
+}
+
+type encHeader struct {
+	keyID [encKeyIDSize]byte
+	size  uint64
+	// dataKey is the unwrapped data key
+	dataKey []byte
+}
+
+func (e *encryptStorage) GetObject(ctx context.Context, filePath string, start *int64, end *int64) (io.ReadCloser, error) {
+	ctx, span := e.tracer.Start(ctx, "encryptStorage.GetObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+	))
+	defer span.End()
+
+	// without a range the header and the content are read in one request
+	if start == nil && end == nil {
+		r, err := e.storage.GetObject(ctx, filePath, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		header, raw, err := e.readHeader(r)
+		if errors.Is(err, errNotEncrypted) && e.allowPlaintext {
+			return &limitedReader{
+				Reader:    io.MultiReader(bytes.NewReader(raw), r),
+				closeFunc: r.Close,
+			}, nil
+		}
+		if err != nil {
+			_ = r.Close()
+			span.SetStatus(codes.Error, "failed to read header")
+			span.RecordError(err)
+			return nil, err
+		}
+		if header.size == 0 {
+			_ = r.Close()
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		return newDecryptReader(r, header, 0, header.size-1)
+	}
+
+	headerStart, headerEnd := int64(0), int64(encHeaderSize-1)
+	r, err := e.storage.GetObject(ctx, filePath, &headerStart, &headerEnd)
+	if err != nil {
+		return nil, err
+	}
+	header, _, err := e.readHeader(r)
+	_ = r.Close()
+	if errors.Is(err, errNotEncrypted) && e.allowPlaintext {
+		return e.storage.GetObject(ctx, filePath, start, end)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to read header")
+		span.RecordError(err)
+		return nil, err
+	}
+
+	var first, last uint64
+	if start != nil {
+		first = uint64(*start)
+	}
+	last = header.size - 1
+	if end != nil && uint64(*end) < last {
+		last = uint64(*end)
+	}
+	if header.size == 0 || first > last {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	firstChunk, lastChunk := first/encChunkSize, last/encChunkSize
+	storedStart := int64(encHeaderSize) + int64(firstChunk)*(encChunkSize+encTagSize)
+	storedEnd := int64(encHeaderSize) + int64(lastChunk)*(encChunkSize+encTagSize) + int64(chunkPlainSize(lastChunk, header.size)) + encTagSize - 1
+	r, err = e.storage.GetObject(ctx, filePath, &storedStart, &storedEnd)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(r, header, first, last)
+}
+
+// PutObject encrypts the content while it is written. The reader must provide exactly size bytes.
+func (e *encryptStorage) PutObject(ctx context.Context, filePath string, size uint64, reader io.Reader, contentType string) error {
+	ctx, span := e.tracer.Start(ctx, "encryptStorage.PutObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+		attribute.Int64("size", int64(size)),
+	))
+	defer span.End()
+
+	dataKey := make([]byte, encDataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	header, err := e.sealHeader(size, dataKey)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	r := io.MultiReader(bytes.NewReader(header), &encryptReader{
+		r:         reader,
+		aead:      aead,
+		remaining: size,
+		plain:     make([]byte, encChunkSize),
+	})
+	if err = e.storage.PutObject(ctx, filePath, encryptedSize(size), r, contentType); err != nil {
+		span.SetStatus(codes.Error, "failed to put object")
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+func (e *encryptStorage) MoveObject(ctx context.Context, from string, to string) error {
+	return e.storage.MoveObject(ctx, from, to)
+}
+
+func (e *encryptStorage) DeleteObject(ctx context.Context, filePath string) error {
+	return e.storage.DeleteObject(ctx, filePath)
+}
+
+// ListObjects lists all objects with their unencrypted size, which is read from the header of every object.
+func (e *encryptStorage) ListObjects(ctx context.Context, prefix string, fn func(info ObjectInfo) error) error {
+	ctx, span := e.tracer.Start(ctx, "encryptStorage.ListObjects", trace.WithAttributes(
+		attribute.String("prefix", prefix),
+	))
+	defer span.End()
+
+	return e.storage.ListObjects(ctx, prefix, func(info ObjectInfo) error {
+		if err := e.plainSize(ctx, &info); err != nil {
+			return err
+		}
+		return fn(info)
+	})
+}
+
+// CopyObject copies the encrypted content as is, both objects share the data key in the header.
+func (e *encryptStorage) CopyObject(ctx context.Context, from string, to string) error {
+	return e.storage.CopyObject(ctx, from, to)
+}
+
+// StatObject returns the metadata of an object with its unencrypted size.
+func (e *encryptStorage) StatObject(ctx context.Context, filePath string) (*ObjectInfo, error) {
+	ctx, span := e.tracer.Start(ctx, "encryptStorage.StatObject", trace.WithAttributes(
+		attribute.String("filePath", filePath),
+	))
+	defer span.End()
+
+	info, err := e.storage.StatObject(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.plainSize(ctx, info); err != nil {
+		span.SetStatus(codes.Error, "failed to read header")
+		span.RecordError(err)
+		return nil, err
+	}
+	return info, nil
+}
+
+// plainSize replaces the size of an encrypted object with the unencrypted size from its header.
+func (e *encryptStorage) plainSize(ctx context.Context, info *ObjectInfo) error {
+	if info.Size < uint64(encHeaderSize) {
+		return nil
+	}
+	headerStart, headerEnd := int64(0), int64(encHeaderSize-1)
+	r, err := e.storage.GetObject(ctx, info.Path, &headerStart, &headerEnd)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	raw := make([]byte, encHeaderSize)
+	if _, err = io.ReadFull(r, raw); err != nil {
+		return err
+	}
+	// the size can be read without the key of the object
+	if string(raw[:len(encMagic)]) == encMagic {
+		info.Size = binary.BigEndian.Uint64(raw[len(encMagic)+encKeyIDSize:])
+	}
+	return nil
+}
+
+// rekey re-wraps the data key of an object with the current master key, the content itself is not re-encrypted.
+// Unencrypted objects are encrypted. It reports whether the object was changed, objects which do not exist are skipped.
+// The object is rewritten to a temporary path and moved over the original, so content written to it in between is lost.
+// There is no lock against the server, rekey must only run while godrive is stopped.
+func (e *encryptStorage) rekey(ctx context.Context, filePath string) (bool, error) {
+	r, err := e.storage.GetObject(ctx, filePath, nil, nil)
+	if err != nil {
+		if isObjectNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer r.Close()
+
+	header, raw, err := e.readHeader(r)
+	if isObjectNotExist(err) {
+		return false, nil
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return false, err
+	}
+	tmpPath := internalPathPrefix + "/rekey/" + hex.EncodeToString(b)
+	if errors.Is(err, errNotEncrypted) {
+		info, err := e.storage.StatObject(ctx, filePath)
+		if err != nil {
+			return false, err
+		}
+		if err = e.PutObject(ctx, tmpPath, info.Size, io.MultiReader(bytes.NewReader(raw), r), info.ContentType); err != nil {
+			return false, err
+		}
+		return true, e.storage.MoveObject(ctx, tmpPath, filePath)
+	}
+	if err != nil {
+		return false, err
+	}
+	if header.keyID == e.current.id {
+		return false, nil
+	}
+
+	newHeader, err := e.sealHeader(header.size, header.dataKey)
+	if err != nil {
+		return false, err
+	}
+	if err = e.storage.PutObject(ctx, tmpPath, encryptedSize(header.size), io.MultiReader(bytes.NewReader(newHeader), r), ""); err != nil {
+		return false, err
+	}
+	return true, e.storage.MoveObject(ctx, tmpPath, filePath)
+}
+
+func (e *encryptStorage) sealHeader(size uint64, dataKey []byte) ([]byte, error) {
+	header := make([]byte, 0, encHeaderSize)
+	header = append(header, encMagic...)
+	header = append(header, e.current.id[:]...)
+	header = binary.BigEndian.AppendUint64(header, size)
+
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// the header is authenticated together with the data key
+	aad := header
+	header = append(header, nonce...)
+	return e.current.aead.Seal(header, nonce, dataKey, aad), nil
+}
+
+// readHeader reads and unwraps the header of an object. If the object is not encrypted errNotEncrypted and the consumed bytes are returned.
+func (e *encryptStorage) readHeader(r io.Reader) (*encHeader, []byte, error) {
+	raw := make([]byte, encHeaderSize)
+	n, err := io.ReadFull(r, raw)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, raw[:n], errNotEncrypted
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(raw[:len(encMagic)]) != encMagic {
+		return nil, raw, errNotEncrypted
+	}
+
+	header := &encHeader{}
+	offset := len(encMagic)
+	copy(header.keyID[:], raw[offset:offset+encKeyIDSize])
+	offset += encKeyIDSize
+	header.size = binary.BigEndian.Uint64(raw[offset : offset+8])
+	offset += 8
+
+	key, ok := e.keys[header.keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("object is encrypted with unknown key %x, add it to the old keys", header.keyID)
+	}
+	nonce := raw[offset : offset+12]
+	if header.dataKey, err = key.aead.Open(nil, nonce, raw[offset+12:], raw[:offset]); err != nil {
+		return nil, nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return header, nil, nil
+}
+
+// RekeyStorage rekeys all objects known to the database with the current encryption key, see encryptStorage.rekey.
+// fn is called for every object which was changed or failed. It must only run while godrive is stopped.
+func RekeyStorage(ctx context.Context, storage Storage, db *DB, fn func(filePath string, err error)) error {
+	e, ok := storage.(*encryptStorage)
+	if !ok {
+		return errors.New("storage is not encrypted")
+	}
+	objects, err := db.GetExpectedObjects(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, object := range objects {
+		filePath := object.Path
+		changed, err := e.rekey(ctx, filePath)
+		if err != nil {
+			err = fmt.Errorf("failed to rekey %s: %w", filePath, err)
+			errs = errors.Join(errs, err)
+		}
+		if changed || err != nil {
+			fn(filePath, err)
+		}
+		if ctx.Err() != nil {
+			return errors.Join(errs, ctx.Err())
+		}
+	}
+	return errs
+}
+
+func encryptedSize(size uint64) uint64 {
+	chunks := (size + encChunkSize - 1) / encChunkSize
+	return uint64(encHeaderSize) + size + chunks*encTagSize
+}
+
+func chunkPlainSize(chunk uint64, size uint64) uint64 {
+	if remaining := size - chunk*encChunkSize; remaining < encChunkSize {
+		return remaining
+	}
+	return encChunkSize
+}
+
+func chunkNonce(chunk uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], chunk)
+	return nonce
+}
+
+type encryptReader struct {
+	r         io.Reader
+	aead      cipher.AEAD
+	remaining uint64
+	chunk     uint64
+	plain     []byte
+	buf       []byte
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.remaining == 0 {
+			if n, _ := r.r.Read(make([]byte, 1)); n > 0 {
+				return 0, errors.New("object is larger than its size")
+			}
+			return 0, io.EOF
+		}
+		n := chunkPlainSize(r.chunk, r.chunk*encChunkSize+r.remaining)
+		if _, err := io.ReadFull(r.r, r.plain[:n]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, fmt.Errorf("object is smaller than its size: %w", err)
+		}
+		r.buf = r.aead.Seal(r.buf[:0], chunkNonce(r.chunk), r.plain[:n], nil)
+		r.chunk++
+		r.remaining -= n
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// newDecryptReader decrypts the plaintext bytes first to last (inclusive) from r, which has to start at the chunk containing first.
+func newDecryptReader(r io.ReadCloser, header *encHeader, first uint64, last uint64) (io.ReadCloser, error) {
+	aead, err := newAEAD(header.dataKey)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return &decryptReader{
+		r:         r,
+		aead:      aead,
+		size:      header.size,
+		chunk:     first / encChunkSize,
+		skip:      first % encChunkSize,
+		remaining: last - first + 1,
+		sealed:    make([]byte, encChunkSize+encTagSize),
+	}, nil
+}
+
+type decryptReader struct {
+	r         io.ReadCloser
+	aead      cipher.AEAD
+	size      uint64
+	chunk     uint64
+	skip      uint64
+	remaining uint64
+	sealed    []byte
+	buf       []byte
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if len(r.buf) == 0 {
+		sealed := r.sealed[:chunkPlainSize(r.chunk, r.size)+encTagSize]
+		if _, err := io.ReadFull(r.r, sealed); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		plain, err := r.aead.Open(sealed[:0], chunkNonce(r.chunk), sealed, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt chunk %d: %w", r.chunk, err)
+		}
+		r.buf = plain[r.skip:]
+		r.skip = 0
+		r.chunk++
+	}
+	if uint64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.remaining -= uint64(n)
+	return n, nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.r.Close()
+}
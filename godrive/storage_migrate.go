@@ -0,0 +1,181 @@
+package godrive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type MigrateStatus string
+
+const (
+	MigrateStatusCopied MigrateStatus = "copied"
+	// MigrateStatusSkipped is an object which was already copied and did not change since.
+	MigrateStatusSkipped MigrateStatus = "skipped"
+	// MigrateStatusMissing is an object the database expects which does not exist in the source storage.
+	MigrateStatusMissing MigrateStatus = "missing"
+	MigrateStatusFailed  MigrateStatus = "failed"
+)
+
+type MigrateOptions struct {
+	// Target identifies the destination storage, see StorageConfig.Location.
+	Target string
+	// Parallel is the number of objects copied at the same time.
+	Parallel int
+}
+
+// MigrateStorage copies all objects the database expects from one storage to another and verifies their size and checksum.
+// Copied objects are recorded in the database, so an interrupted migration continues where it stopped and objects changed since are copied again.
+// The source is only read, so the server can keep using it. fn is called for every object and is never called concurrently.
+//
+// Deduplicated blobs are copied like any other object, so both storages must be passed without the deduplicating wrapper.
+func MigrateStorage(ctx context.Context, db *DB, from Storage, to Storage, opts MigrateOptions, fn func(filePath string, status MigrateStatus, err error)) error {
+	if _, ok := from.(*dedupStorage); ok {
+		return errors.New("the source storage must not be deduplicating")
+	}
+	if _, ok := to.(*dedupStorage); ok {
+		return errors.New("the destination storage must not be deduplicating")
+	}
+	if opts.Parallel < 1 {
+		opts.Parallel = 1
+	}
+
+	objects, err := db.GetExpectedObjects(ctx)
+	if err != nil {
+		return err
+	}
+	// paths referencing a blob are not stored themselves
+	refs, err := db.GetBlobRefs(ctx)
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]struct{}, len(refs))
+	for _, ref := range refs {
+		referenced[ref.Path] = struct{}{}
+	}
+	migratedObjects, err := db.GetMigratedObjects(ctx, opts.Target)
+	if err != nil {
+		return err
+	}
+	migrated := make(map[string]MigratedObject, len(migratedObjects))
+	for _, object := range migratedObjects {
+		migrated[object.Path] = object
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs error
+	)
+	paths := make(chan string)
+	for i := 0; i < opts.Parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range paths {
+				status, err := migrateObject(ctx, db, from, to, opts.Target, filePath, migrated[filePath])
+				mu.Lock()
+				if err != nil {
+					errs = errors.Join(errs, fmt.Errorf("failed to migrate %s: %w", filePath, err))
+				}
+				fn(filePath, status, err)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, object := range objects {
+		if _, ok := referenced[object.Path]; ok {
+			continue
+		}
+		select {
+		case paths <- object.Path:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(paths)
+	wg.Wait()
+
+	if err = ctx.Err(); err != nil {
+		return errors.Join(errs, err)
+	}
+	return errs
+}
+
+// migrateObject copies a single object unless it was already copied with the same ETag.
+// The ETag is read before copying, so an object changing while it is copied is copied again by the next migration.
+func migrateObject(ctx context.Context, db *DB, from Storage, to Storage, target string, filePath string, migrated MigratedObject) (MigrateStatus, error) {
+	info, err := from.StatObject(ctx, filePath)
+	if err != nil {
+		if isObjectNotExist(err) {
+			return MigrateStatusMissing, nil
+		}
+		return MigrateStatusFailed, err
+	}
+	if migrated.Path != "" && migrated.SourceETag == info.ETag && migrated.Size == info.Size {
+		return MigrateStatusSkipped, nil
+	}
+
+	r, err := from.GetObject(ctx, filePath, nil, nil)
+	if err != nil {
+		return MigrateStatusFailed, err
+	}
+	defer r.Close()
+	hr := &hashReader{
+		Reader: r,
+		hash:   sha256.New(),
+	}
+	if err = to.PutObject(ctx, filePath, info.Size, hr, info.ContentType); err != nil {
+		return MigrateStatusFailed, err
+	}
+	checksum := hex.EncodeToString(hr.hash.Sum(nil))
+
+	if err = verifyObject(ctx, to, filePath, info.Size, checksum); err != nil {
+		return MigrateStatusFailed, err
+	}
+
+	if err = db.SetMigratedObject(ctx, MigratedObject{
+		Target:     target,
+		Path:       filePath,
+		Size:       info.Size,
+		SourceETag: info.ETag,
+		Checksum:   checksum,
+		MigratedAt: time.Now(),
+	}); err != nil {
+		return MigrateStatusFailed, err
+	}
+	return MigrateStatusCopied, nil
+}
+
+// verifyObject reads an object back and compares its size and SHA-256 checksum.
+func verifyObject(ctx context.Context, storage Storage, filePath string, size uint64, checksum string) error {
+	info, err := storage.StatObject(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	if info.Size != size {
+		return fmt.Errorf("expected %d bytes but the copy has %d", size, info.Size)
+	}
+
+	r, err := storage.GetObject(ctx, filePath, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, r); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != checksum {
+		return fmt.Errorf("expected checksum %s but the copy has %s", checksum, actual)
+	}
+	return nil
+}
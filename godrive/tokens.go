@@ -0,0 +1,187 @@
+package godrive
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// TokenPrefix makes personal access tokens recognizable, e.g. for secret scanners.
+	TokenPrefix = "gdp_"
+
+	// tokenLastUsedInterval limits how often the last used timestamp of a token is written.
+	tokenLastUsedInterval = time.Minute
+)
+
+var (
+	ErrTokenExpired = errors.New("token expired")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// TokenRoutes lets users manage their personal access tokens.
+func (s *Server) TokenRoutes(r chi.Router) {
+	r.Get("/", s.GetTokens)
+	r.Post("/", s.CreateToken)
+	r.Delete("/{id}", s.DeleteToken)
+}
+
+func (s *Server) GetTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := s.db.GetTokens(r.Context(), GetUserInfo(r).Subject)
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]TokenResponse, len(tokens))
+	for i, token := range tokens {
+		response[i] = toTokenResponse(token)
+	}
+	s.ok(w, r, response)
+}
+
+func (s *Server) CreateToken(w http.ResponseWriter, r *http.Request) {
+	var tokenRq TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&tokenRq); err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	userInfo := GetUserInfo(r)
+	if tokenRq.Name == "" {
+		s.error(w, r, errors.New("name is required"), http.StatusBadRequest)
+		return
+	}
+	switch tokenRq.Scope {
+	case TokenScopeRead, TokenScopeWrite:
+	case TokenScopeAdmin:
+		if !s.isAdmin(userInfo) {
+			s.error(w, r, errors.New("only admins can create admin tokens"), http.StatusForbidden)
+			return
+		}
+	default:
+		s.error(w, r, errors.New("invalid scope"), http.StatusBadRequest)
+		return
+	}
+	if tokenRq.ExpiresAt != nil && tokenRq.ExpiresAt.Before(time.Now()) {
+		s.error(w, r, errors.New("expiry must be in the future"), http.StatusBadRequest)
+		return
+	}
+
+	rawToken, err := newToken()
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	token, err := s.db.CreateToken(r.Context(), Token{
+		ID:        s.newID(16),
+		UserID:    userInfo.Subject,
+		Name:      tokenRq.Name,
+		Hash:      hashToken(rawToken),
+		Scope:     tokenRq.Scope,
+		ExpiresAt: tokenRq.ExpiresAt,
+	})
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := toTokenResponse(*token)
+	response.Token = rawToken
+	s.json(w, r, response, http.StatusCreated)
+}
+
+func (s *Server) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.DeleteToken(r.Context(), chi.URLParam(r, "id"), GetUserInfo(r).Subject); err != nil {
+		if errors.Is(err, ErrTokenNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticateToken resolves a personal access token to the user it belongs to.
+func (s *Server) authenticateToken(ctx context.Context, rawToken string) (*UserInfo, error) {
+	token, err := s.db.GetTokenByHash(ctx, hashToken(rawToken))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(now) {
+		return nil, ErrTokenExpired
+	}
+
+	user, err := s.db.GetUser(ctx, token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > tokenLastUsedInterval {
+		if err = s.db.UpdateTokenLastUsed(ctx, token.ID, now); err != nil {
+			slog.ErrorCtx(ctx, "failed to update token last used", slog.String("id", token.ID), slog.Any("err", err))
+		}
+	}
+
+	info := toUserInfo(*user)
+	info.Scope = token.Scope
+	return info, nil
+}
+
+func newToken() (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	return TokenPrefix + secret, nil
+}
+
+// newSecret returns a random string which is safe to use in URLs.
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a token for storage. Tokens are random enough that a fast unsalted hash is sufficient.
+func hashToken(rawToken string) string {
+	sum := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(sum[:])
+}
+
+func isReadOnlyMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return true
+	}
+	return false
+}
+
+func toTokenResponse(token Token) TokenResponse {
+	return TokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Scope:      token.Scope,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
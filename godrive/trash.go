@@ -0,0 +1,179 @@
+package godrive
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/exp/slog"
+)
+
+func (s *Server) TrashRoutes(r chi.Router) {
+	r.Get("/", s.GetTrash)
+	r.Delete("/", s.EmptyTrash)
+	r.Post("/{id}", s.RestoreTrashedFile)
+	r.Delete("/{id}", s.DeleteTrashedFile)
+}
+
+func (s *Server) GetTrash(w http.ResponseWriter, r *http.Request) {
+	userInfo := GetUserInfo(r)
+	trash, err := s.getTrash(r.Context(), userInfo)
+	if err != nil {
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	templateFiles := make([]TemplateTrashedFile, len(trash))
+	for i, file := range trash {
+		owner := "Unknown"
+		if file.Username != nil {
+			owner = *file.Username
+		}
+		templateFiles[i] = TemplateTrashedFile{
+			ID:        file.ID,
+			Path:      file.Path,
+			Name:      path.Base(file.Path),
+			Size:      file.Size,
+			Owner:     owner,
+			DeletedAt: file.DeletedAt,
+		}
+	}
+
+	vars := TrashVariables{
+		BaseVariables: BaseVariables{
+			Theme: "dark",
+			Auth:  s.cfg.Auth != nil,
+			User:  s.ToTemplateUser(userInfo),
+			Quota: s.templateQuota(r.Context(), userInfo),
+		},
+		Files: templateFiles,
+	}
+	if err = s.tmpl(w, "trash.gohtml", vars); err != nil {
+		slog.ErrorCtx(r.Context(), "error executing template", slog.Any("err", err))
+	}
+}
+
+func (s *Server) RestoreTrashedFile(w http.ResponseWriter, r *http.Request) {
+	trashed, ok := s.getTrashedFile(w, r)
+	if !ok {
+		return
+	}
+	// the original folder may have been locked down since the file was deleted
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	if !a.canUpload(trashed.Path) {
+		s.error(w, r, uploadDenied(trashed.Path), http.StatusForbidden)
+		return
+	}
+
+	if err := s.db.RestoreTrashedFile(r.Context(), *trashed, trashPath(trashed.ID)); err != nil {
+		if errors.Is(err, ErrFileAlreadyExists) {
+			s.error(w, r, err, http.StatusConflict)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err := s.storage.MoveObject(r.Context(), trashPath(trashed.ID), trashed.Path); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) DeleteTrashedFile(w http.ResponseWriter, r *http.Request) {
+	trashed, ok := s.getTrashedFile(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.purgeTrashedFile(r.Context(), *trashed); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	trash, err := s.getTrash(r.Context(), GetUserInfo(r))
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	var errs error
+	for _, trashed := range trash {
+		errs = errors.Join(errs, s.purgeTrashedFile(r.Context(), trashed))
+	}
+	if errs != nil {
+		s.error(w, r, errs, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getTrash(ctx context.Context, userInfo *UserInfo) ([]TrashedFile, error) {
+	var userID string
+	if !s.isAdmin(userInfo) {
+		userID = userInfo.Subject
+	}
+	return s.db.GetTrash(ctx, userID)
+}
+
+func (s *Server) getTrashedFile(w http.ResponseWriter, r *http.Request) (*TrashedFile, bool) {
+	trashed, err := s.db.GetTrashedFile(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, ErrTrashNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return nil, false
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	userInfo := GetUserInfo(r)
+	if trashed.DeletedBy != userInfo.Subject && trashed.UserID != userInfo.Subject && !s.isAdmin(userInfo) {
+		s.error(w, r, ErrTrashNotFound, http.StatusNotFound)
+		return nil, false
+	}
+	return trashed, true
+}
+
+// trashFile moves a file and its versions into the trash of the deleting user.
+func (s *Server) trashFile(ctx context.Context, file File, userInfo *UserInfo) error {
+	id := s.newID(32)
+	if err := s.storage.MoveObject(ctx, file.Path, trashPath(id)); err != nil {
+		return err
+	}
+
+	if _, err := s.db.TrashFile(ctx, id, file, userInfo.Subject, trashPath(id)); err != nil {
+		if moveErr := s.storage.MoveObject(ctx, trashPath(id), file.Path); moveErr != nil {
+			slog.ErrorCtx(ctx, "failed to move trashed file back", slog.String("path", file.Path), slog.Any("err", moveErr))
+		}
+		return err
+	}
+	return nil
+}
+
+// purgeTrashedFile permanently deletes a trashed file and its versions.
+func (s *Server) purgeTrashedFile(ctx context.Context, trashed TrashedFile) error {
+	if err := s.db.DeleteTrashedFile(ctx, trashed.ID); err != nil {
+		return err
+	}
+	if err := s.storage.DeleteObject(ctx, trashPath(trashed.ID)); err != nil {
+		return err
+	}
+	s.deleteFileVersions(ctx, trashPath(trashed.ID))
+	return nil
+}
+
+func trashPath(id string) string {
+	return path.Join(internalPathPrefix, "trash", id)
+}
@@ -0,0 +1,461 @@
+package godrive
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	TusVersion            = "1.0.0"
+	TusExtensions         = "creation,termination,checksum"
+	TusChecksumAlgorithms = "sha1,sha256,md5"
+	TusContentType        = "application/offset+octet-stream"
+
+	// StatusChecksumMismatch is returned when the checksum of a chunk does not match the Upload-Checksum header.
+	StatusChecksumMismatch = 460
+)
+
+// TusRoutes implements the tus resumable upload protocol (https://tus.io/protocols/resumable-upload).
+// Chunks are stored as separate objects below the internal path prefix and concatenated into the final file once the upload is complete.
+func (s *Server) TusRoutes(r chi.Router) {
+	r.Use(tusResumable)
+	r.Options("/", s.TusOptions)
+	r.Post("/", s.TusCreate)
+	r.Head("/{id}", s.TusHead)
+	r.Patch("/{id}", s.TusPatch)
+	r.Delete("/{id}", s.TusDelete)
+}
+
+func tusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", TusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != TusVersion {
+			w.Header().Set("Tus-Version", TusVersion)
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) TusOptions(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Tus-Version", TusVersion)
+	w.Header().Set("Tus-Extension", TusExtensions)
+	w.Header().Set("Tus-Checksum-Algorithm", TusChecksumAlgorithms)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) TusCreate(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.ParseUint(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		s.error(w, r, errors.New("invalid or missing Upload-Length header"), http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	name := metadata["filename"]
+	if name == "" {
+		s.error(w, r, errors.New("missing filename metadata"), http.StatusBadRequest)
+		return
+	}
+	filePath := path.Join("/", metadata["dir"], name)
+	if isReservedPath(filePath) {
+		s.error(w, r, ErrReservedPath, http.StatusBadRequest)
+		return
+	}
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	if !a.canUpload(filePath) {
+		s.error(w, r, uploadDenied(filePath), http.StatusForbidden)
+		return
+	}
+
+	if _, err = s.db.GetFile(r.Context(), filePath); err == nil {
+		s.error(w, r, ErrFileAlreadyExists, http.StatusConflict)
+		return
+	} else if !errors.Is(err, ErrFileNotFound) {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the declared content type is only checked early, the content type of the content is checked once the upload finished
+	if err = s.cfg.Upload.check(filePath, size, contentType); err != nil {
+		s.uploadError(w, r, err)
+		return
+	}
+	// the size of the upload is reserved until it finishes, so parallel uploads can not exceed the quota
+	userID := GetUserInfo(r).Subject
+	if !s.hasQuota(w, r, userID, size) {
+		return
+	}
+
+	upload := Upload{
+		ID:          s.newID(32),
+		Path:        filePath,
+		Size:        size,
+		ContentType: contentType,
+		Description: metadata["description"],
+		UserID:      userID,
+	}
+	if err = s.db.CreateUpload(r.Context(), upload); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", path.Join(r.URL.Path, upload.ID))
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) TusHead(w http.ResponseWriter, r *http.Request) {
+	upload, ok := s.getUpload(w, r, false)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatUint(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatUint(upload.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) TusPatch(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != TusContentType {
+		s.error(w, r, fmt.Errorf("content type must be %s", TusContentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if r.ContentLength < 0 {
+		s.error(w, r, errors.New("missing Content-Length header"), http.StatusLengthRequired)
+		return
+	}
+	offset, err := strconv.ParseUint(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		s.error(w, r, errors.New("invalid or missing Upload-Offset header"), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		checksumHash hash.Hash
+		checksum     []byte
+	)
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		checksumHash, checksum, err = parseTusChecksum(header)
+		if err != nil {
+			s.error(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	upload, ok := s.getUpload(w, r, false)
+	if !ok {
+		return
+	}
+	if offset != upload.Offset {
+		s.error(w, r, ErrUploadConflict, http.StatusConflict)
+		return
+	}
+
+	size := uint64(r.ContentLength)
+	if upload.Offset+size > upload.Size {
+		s.error(w, r, errors.New("chunk exceeds upload length"), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// the body is buffered, so the bytes received before the request was interrupted are kept and the upload resumes from there
+	tmp, err := os.CreateTemp("", "godrive-tus-*")
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var body io.Reader = io.LimitReader(r.Body, int64(size))
+	if checksumHash != nil {
+		body = io.TeeReader(body, checksumHash)
+	}
+	received, readErr := io.Copy(tmp, body)
+	if readErr == nil && uint64(received) != size {
+		readErr = io.ErrUnexpectedEOF
+	}
+	if readErr != nil {
+		// chunks with a checksum can only be verified once they were received completely
+		if checksumHash != nil || received == 0 {
+			s.error(w, r, fmt.Errorf("failed to receive chunk: %w", readErr), http.StatusBadRequest)
+			return
+		}
+		slog.InfoCtx(r.Context(), "upload chunk interrupted, keeping received bytes", slog.String("id", upload.ID), slog.Int64("received", received), slog.Any("err", readErr))
+		size = uint64(received)
+	} else if checksumHash != nil && string(checksumHash.Sum(nil)) != string(checksum) {
+		s.error(w, r, errors.New("checksum mismatch"), StatusChecksumMismatch)
+		return
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the client may be gone already, the received bytes are stored nevertheless
+	ctx := r.Context()
+	if readErr != nil {
+		ctx = detachedContext{ctx}
+	}
+	chunkPath := uploadChunkPath(upload.ID, upload.Chunks)
+	if err = s.storage.PutObject(ctx, chunkPath, size, tmp, TusContentType); err != nil {
+		s.deleteChunk(ctx, chunkPath)
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err = s.db.UpdateUploadOffset(ctx, upload.ID, upload.Offset, upload.Offset+size, upload.Chunks+1); err != nil {
+		s.deleteChunk(ctx, chunkPath)
+		if errors.Is(err, ErrUploadConflict) {
+			s.error(w, r, err, http.StatusConflict)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	upload.Offset += size
+	upload.Chunks++
+	w.Header().Set("Upload-Offset", strconv.FormatUint(upload.Offset, 10))
+	if readErr != nil {
+		s.error(w, r, fmt.Errorf("failed to receive chunk: %w", readErr), http.StatusBadRequest)
+		return
+	}
+
+	if upload.Offset == upload.Size {
+		if err = s.finishUpload(r.Context(), *upload); err != nil {
+			s.uploadError(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) TusDelete(w http.ResponseWriter, r *http.Request) {
+	upload, ok := s.getUpload(w, r, false)
+	if !ok {
+		return
+	}
+
+	if err := s.deleteUpload(r.Context(), *upload); err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getUpload returns the upload of the request if it belongs to the user. Presigned and tus uploads can not be used with the endpoints of the other.
+func (s *Server) getUpload(w http.ResponseWriter, r *http.Request, presigned bool) (*Upload, bool) {
+	upload, err := s.db.GetUpload(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return nil, false
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	userInfo := GetUserInfo(r)
+	if upload.Presigned != presigned || (upload.UserID != userInfo.Subject && !s.isAdmin(userInfo)) {
+		s.error(w, r, ErrUploadNotFound, http.StatusNotFound)
+		return nil, false
+	}
+	return upload, true
+}
+
+// finishUpload concatenates all chunks of the upload into the final file and creates its database entry.
+// Uploads with content of a type which is not allowed, to a path which was taken or to a folder the user can no longer upload to are deleted.
+func (s *Server) finishUpload(ctx context.Context, upload Upload) error {
+	// the access may have changed while the upload was in progress
+	a, err := s.getUserAccess(ctx, upload.UserID)
+	if err != nil {
+		return err
+	}
+	if !a.canUpload(upload.Path) {
+		s.discardUpload(ctx, upload)
+		return uploadDenied(upload.Path)
+	}
+
+	reader := &chunksReader{
+		ctx:     ctx,
+		storage: s.storage,
+		id:      upload.ID,
+		chunks:  upload.Chunks,
+	}
+	defer reader.Close()
+	contentType, content, err := sniffContentType(reader, upload.Path)
+	if err != nil {
+		return err
+	}
+	if err = s.cfg.Upload.checkContentType(contentType); err != nil {
+		s.discardUpload(ctx, upload)
+		return err
+	}
+
+	// create the file first, so a file created while the upload was in progress is never overwritten
+	if _, err = s.db.CreateFile(ctx, upload.Path, upload.Size, contentType, upload.Description, upload.UserID); err != nil {
+		if errors.Is(err, ErrFileAlreadyExists) {
+			s.discardUpload(ctx, upload)
+		}
+		return err
+	}
+	if err = s.storage.PutObject(ctx, upload.Path, upload.Size, content, contentType); err != nil {
+		if dbErr := s.db.DeleteFile(ctx, upload.Path); dbErr != nil {
+			slog.ErrorCtx(ctx, "failed to delete file after failed upload", slog.String("path", upload.Path), slog.Any("err", dbErr))
+		}
+		return err
+	}
+
+	return s.deleteUpload(ctx, upload)
+}
+
+// discardUpload deletes an upload which can not be finished.
+func (s *Server) discardUpload(ctx context.Context, upload Upload) {
+	if err := s.deleteUpload(ctx, upload); err != nil {
+		slog.ErrorCtx(ctx, "failed to delete upload", slog.String("id", upload.ID), slog.Any("err", err))
+	}
+}
+
+func (s *Server) deleteUpload(ctx context.Context, upload Upload) error {
+	if err := s.db.DeleteUpload(ctx, upload.ID); err != nil {
+		return err
+	}
+	s.abortMultipartUpload(ctx, upload)
+	for i := 0; i < upload.Chunks; i++ {
+		s.deleteChunk(ctx, uploadChunkPath(upload.ID, i))
+	}
+	return nil
+}
+
+func (s *Server) deleteChunk(ctx context.Context, chunkPath string) {
+	if err := s.storage.DeleteObject(ctx, chunkPath); err != nil {
+		slog.WarnCtx(ctx, "failed to delete upload chunk", slog.String("path", chunkPath), slog.Any("err", err))
+	}
+}
+
+// detachedContext keeps the values of a request context without its cancellation, so work can be finished after the client disconnected.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func uploadChunkPath(id string, chunk int) string {
+	return path.Join(internalPathPrefix, "uploads", id, strconv.Itoa(chunk))
+}
+
+// chunksReader lazily reads all chunks of an upload one after another.
+type chunksReader struct {
+	ctx     context.Context
+	storage Storage
+	id      string
+	chunks  int
+
+	chunk int
+	cur   io.ReadCloser
+}
+
+func (r *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if r.chunk >= r.chunks {
+				return 0, io.EOF
+			}
+			cur, err := r.storage.GetObject(r.ctx, uploadChunkPath(r.id, r.chunk), nil, nil)
+			if err != nil {
+				return 0, err
+			}
+			r.cur = cur
+			r.chunk++
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			_ = r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunksReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
+
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if header == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value for key %s: %w", key, err)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+func parseTusChecksum(header string) (hash.Hash, []byte, error) {
+	algorithm, value, ok := strings.Cut(header, " ")
+	if !ok {
+		return nil, nil, errors.New("invalid Upload-Checksum header")
+	}
+	checksum, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Upload-Checksum value: %w", err)
+	}
+
+	switch algorithm {
+	case "sha1":
+		return sha1.New(), checksum, nil
+	case "sha256":
+		return sha256.New(), checksum, nil
+	case "md5":
+		return md5.New(), checksum, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+}
@@ -0,0 +1,232 @@
+package godrive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/exp/slog"
+)
+
+// auditLogSize is how many audit entries are shown in the settings.
+const auditLogSize = 100
+
+func (s *Server) UserRoutes(r chi.Router) {
+	r.Patch("/{id}", s.PatchUser)
+	r.Delete("/{id}", s.DeleteUser)
+	if s.cfg.Auth.Mode == AuthModeLocal {
+		r.Post("/{id}/password-reset", s.CreatePasswordReset)
+	}
+}
+
+// PatchUser lets admins change the settings of a user. Fields missing in the request are not changed.
+func (s *Server) PatchUser(w http.ResponseWriter, r *http.Request) {
+	var userRequest UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&userRequest); err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.getUser(w, r)
+	if !ok {
+		return
+	}
+	userInfo := GetUserInfo(r)
+	if user.ID == userInfo.Subject && (userRequest.Role != nil || userRequest.Disabled != nil) {
+		s.error(w, r, errors.New("you can not change the role or disable your own account"), http.StatusBadRequest)
+		return
+	}
+
+	// validate all fields first, so a request with an invalid field changes nothing
+	updated := *user
+	var (
+		changes []string
+		acls    []ACL
+	)
+	if userRequest.Quota != nil {
+		updated.Quota = nil
+		if *userRequest.Quota != "" {
+			bytes, err := humanize.ParseBytes(*userRequest.Quota)
+			if err != nil {
+				s.error(w, r, fmt.Errorf("invalid quota: %w", err), http.StatusBadRequest)
+				return
+			}
+			updated.Quota = &bytes
+		}
+		changes = append(changes, "quota: "+formatQuota(updated.Quota))
+	}
+	if userRequest.Home != nil {
+		home, err := cleanHome(*userRequest.Home)
+		if err != nil {
+			s.error(w, r, err, http.StatusBadRequest)
+			return
+		}
+		updated.Home = home
+		if home != "" {
+			acls = append(acls, homeACL(user.ID, home))
+		}
+		changes = append(changes, "home: "+home)
+	}
+	if userRequest.Role != nil {
+		updated.Role = nil
+		if *userRequest.Role != "" {
+			role := Role(*userRequest.Role)
+			if role.permissions() == nil {
+				s.error(w, r, errors.New("invalid role, must be one of: admin, user, viewer"), http.StatusBadRequest)
+				return
+			}
+			updated.Role = &role
+		}
+		changes = append(changes, "role: "+formatRole(updated.Role))
+	}
+	action := AuditActionUserUpdated
+	if userRequest.Disabled != nil && *userRequest.Disabled != user.Disabled {
+		updated.Disabled = *userRequest.Disabled
+		// changing only the disabled state is audited as its own action
+		if len(changes) == 0 {
+			action = AuditActionUserEnabled
+			if updated.Disabled {
+				action = AuditActionUserDisabled
+			}
+		} else {
+			changes = append(changes, fmt.Sprintf("disabled: %t", updated.Disabled))
+		}
+	}
+	if len(changes) == 0 && action == AuditActionUserUpdated {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := s.db.UpdateUser(r.Context(), updated, acls...); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.audit(r.Context(), userInfo, action, user.Username, strings.Join(changes, ", "))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUser deletes a user. The files query parameter decides what happens to everything the user stores:
+// "transfer" transfers it to the user in the to query parameter and "delete" moves the files of the user to the trash.
+// Users who can still log in with the identity provider are created again on their next login, disable them to prevent that.
+func (s *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.getUser(w, r)
+	if !ok {
+		return
+	}
+	userInfo := GetUserInfo(r)
+	if user.ID == userInfo.Subject {
+		s.error(w, r, errors.New("you can not delete your own account"), http.StatusBadRequest)
+		return
+	}
+
+	var details string
+	switch r.URL.Query().Get("files") {
+	case "transfer":
+		to, err := s.db.GetUserByName(r.Context(), r.URL.Query().Get("to"))
+		if errors.Is(err, ErrUserNotFound) {
+			to, err = s.db.GetUser(r.Context(), r.URL.Query().Get("to"))
+		}
+		if err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				s.error(w, r, fmt.Errorf("can not transfer files: %w", err), http.StatusBadRequest)
+				return
+			}
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		if to.ID == user.ID {
+			s.error(w, r, errors.New("can not transfer files to the deleted user"), http.StatusBadRequest)
+			return
+		}
+		if err = s.db.DeleteUser(r.Context(), user.ID, to.ID); err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		details = "files transferred to " + to.Username
+
+	case "delete":
+		files, err := s.db.GetUserFiles(r.Context(), user.ID)
+		if err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		var errs error
+		for _, file := range files {
+			errs = errors.Join(errs, s.trashFile(r.Context(), file, userInfo))
+		}
+		if errs != nil {
+			s.error(w, r, errs, http.StatusInternalServerError)
+			return
+		}
+		if err = s.db.DeleteUser(r.Context(), user.ID, ""); err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		details = fmt.Sprintf("%d files moved to the trash", len(files))
+
+	default:
+		s.error(w, r, errors.New("invalid files, must be one of: transfer, delete"), http.StatusBadRequest)
+		return
+	}
+
+	s.audit(r.Context(), userInfo, AuditActionUserDeleted, user.Username, details)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getUser returns the user in the id URL parameter and writes the error response if it does not exist.
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	user, err := s.db.GetUser(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return nil, false
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// audit records a change made by an admin. The change is already made, so failing to record it is only logged.
+func (s *Server) audit(ctx context.Context, info *UserInfo, action AuditAction, target string, details string) {
+	slog.InfoCtx(ctx, "audit", slog.String("user", info.Username), slog.String("action", string(action)), slog.String("target", target), slog.String("details", details))
+	if err := s.db.CreateAuditEntry(ctx, AuditEntry{
+		ID:        s.newID(16),
+		UserID:    info.Subject,
+		Username:  info.Username,
+		Action:    action,
+		Target:    target,
+		Details:   details,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		slog.ErrorCtx(ctx, "failed to create audit entry", slog.String("action", string(action)), slog.String("target", target), slog.Any("err", err))
+	}
+}
+
+func formatQuota(quota *uint64) string {
+	if quota == nil {
+		return "groups"
+	}
+	if *quota == 0 {
+		return "unlimited"
+	}
+	return humanize.IBytes(*quota)
+}
+
+func formatRole(role *Role) string {
+	if role == nil {
+		return "groups"
+	}
+	return string(*role)
+}
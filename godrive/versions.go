@@ -0,0 +1,236 @@
+package godrive
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/exp/slog"
+)
+
+func (s *Server) VersionRoutes(r chi.Router) {
+	r.Get("/*", s.GetFileVersions)
+	r.Head("/*", s.GetFileVersions)
+	r.With(s.RequirePermission(PermissionModifyOwn)).Post("/*", s.RestoreFileVersion)
+}
+
+// GetFileVersions lists all versions of a file or downloads a specific version when the version query parameter is set.
+func (s *Server) GetFileVersions(w http.ResponseWriter, r *http.Request) {
+	filePath := "/" + chi.URLParam(r, "*")
+	if _, err := s.db.GetFile(r.Context(), filePath); err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	if !a.canRead(filePath) {
+		s.error(w, r, ErrFileNotFound, http.StatusNotFound)
+		return
+	}
+
+	if versionStr := r.URL.Query().Get("version"); versionStr != "" {
+		version, ok := s.getFileVersion(w, r, filePath, versionStr)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Disposition", "attachment; filename="+path.Base(filePath))
+		w.Header().Set("Content-Type", version.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatUint(version.Size, 10))
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err := s.writeFile(r.Context(), w, fileVersionPath(version.ID), nil, nil); err != nil {
+			slog.ErrorCtx(r.Context(), "Failed to write file version", slog.Any("err", err))
+		}
+		return
+	}
+
+	versions, err := s.db.GetFileVersions(r.Context(), filePath)
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]FileVersionResponse, len(versions))
+	for i, version := range versions {
+		owner := "Unknown"
+		if version.Username != nil {
+			owner = *version.Username
+		}
+		response[i] = FileVersionResponse{
+			Version:     version.Version,
+			Size:        version.Size,
+			ContentType: version.ContentType,
+			Owner:       owner,
+			CreatedAt:   version.CreatedAt,
+			ArchivedAt:  version.ArchivedAt,
+		}
+	}
+	s.ok(w, r, response)
+}
+
+// RestoreFileVersion replaces the content of a file with the given version. The current content is kept as a new version.
+func (s *Server) RestoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	filePath := "/" + chi.URLParam(r, "*")
+	file, err := s.db.GetFile(r.Context(), filePath)
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	a, ok := s.getRequestAccess(w, r)
+	if !ok {
+		return
+	}
+	if !a.canModify(*file) {
+		s.error(w, r, modifyDenied(file.Path), http.StatusForbidden)
+		return
+	}
+
+	version, ok := s.getFileVersion(w, r, filePath, r.URL.Query().Get("version"))
+	if !ok {
+		return
+	}
+	// the current content is kept as version, so the restored content is stored in addition
+	if !s.hasQuota(w, r, file.UserID, version.Size) {
+		return
+	}
+
+	archived, err := s.archiveFile(r.Context(), *file)
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	// update the row first like PatchFile, so a failed update never leaves the restored content with the metadata of the old content
+	if err = s.db.UpdateFile(r.Context(), file.Path, file.Path, version.Size, version.ContentType, file.Description); err != nil {
+		s.unarchiveFile(r.Context(), *archived)
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.restoreVersionObject(r.Context(), *version, file.Path); err != nil {
+		if dbErr := s.db.UpdateFile(r.Context(), file.Path, file.Path, file.Size, file.ContentType, file.Description); dbErr != nil {
+			slog.ErrorCtx(r.Context(), "failed to restore file after failed version restore", slog.String("path", file.Path), slog.Any("err", dbErr))
+		} else {
+			s.unarchiveFile(r.Context(), *archived)
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.pruneFileVersions(r.Context(), file.Path)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreVersionObject copies the content of the version to filePath.
+func (s *Server) restoreVersionObject(ctx context.Context, version FileVersion, filePath string) error {
+	obj, err := s.storage.GetObject(ctx, fileVersionPath(version.ID), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	return s.storage.PutObject(ctx, filePath, version.Size, obj, version.ContentType)
+}
+
+func (s *Server) getFileVersion(w http.ResponseWriter, r *http.Request, filePath string, versionStr string) (*FileVersion, bool) {
+	versionNumber, err := strconv.Atoi(versionStr)
+	if err != nil {
+		s.error(w, r, errors.New("invalid version"), http.StatusBadRequest)
+		return nil, false
+	}
+	version, err := s.db.GetFileVersion(r.Context(), filePath, versionNumber)
+	if err != nil {
+		if errors.Is(err, ErrVersionNotFound) {
+			s.error(w, r, err, http.StatusNotFound)
+			return nil, false
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return version, true
+}
+
+// archiveFile moves the current content of the file to a new version.
+func (s *Server) archiveFile(ctx context.Context, file File) (*FileVersion, error) {
+	id := s.newID(32)
+	if err := s.storage.MoveObject(ctx, file.Path, fileVersionPath(id)); err != nil {
+		return nil, err
+	}
+
+	version, err := s.db.CreateFileVersion(ctx, id, file)
+	if err != nil {
+		if moveErr := s.storage.MoveObject(ctx, fileVersionPath(id), file.Path); moveErr != nil {
+			slog.ErrorCtx(ctx, "failed to move archived file back", slog.String("path", file.Path), slog.Any("err", moveErr))
+		}
+		return nil, err
+	}
+	return version, nil
+}
+
+// unarchiveFile reverts archiveFile after the new content could not be stored.
+func (s *Server) unarchiveFile(ctx context.Context, version FileVersion) {
+	if err := s.storage.MoveObject(ctx, fileVersionPath(version.ID), version.Path); err != nil {
+		slog.ErrorCtx(ctx, "failed to move archived file back", slog.String("path", version.Path), slog.Any("err", err))
+		return
+	}
+	if err := s.db.DeleteFileVersion(ctx, version.ID); err != nil {
+		slog.ErrorCtx(ctx, "failed to delete file version", slog.String("path", version.Path), slog.Any("err", err))
+	}
+}
+
+// pruneFileVersions deletes the versions of a file which exceed the configured retention.
+func (s *Server) pruneFileVersions(ctx context.Context, filePath string) {
+	var before time.Time
+	if s.cfg.Versions.KeepFor > 0 {
+		before = time.Now().Add(-s.cfg.Versions.KeepFor)
+	}
+	versions, err := s.db.GetExpiredFileVersions(ctx, filePath, s.cfg.Versions.Keep, before)
+	if err != nil {
+		slog.ErrorCtx(ctx, "failed to get expired file versions", slog.String("path", filePath), slog.Any("err", err))
+		return
+	}
+	for _, version := range versions {
+		s.deleteFileVersion(ctx, version)
+	}
+}
+
+// deleteFileVersions deletes all versions of a file.
+func (s *Server) deleteFileVersions(ctx context.Context, filePath string) {
+	versions, err := s.db.GetFileVersions(ctx, filePath)
+	if err != nil {
+		slog.ErrorCtx(ctx, "failed to get file versions", slog.String("path", filePath), slog.Any("err", err))
+		return
+	}
+	for _, version := range versions {
+		s.deleteFileVersion(ctx, version)
+	}
+}
+
+func (s *Server) deleteFileVersion(ctx context.Context, version FileVersion) {
+	if err := s.db.DeleteFileVersion(ctx, version.ID); err != nil {
+		slog.ErrorCtx(ctx, "failed to delete file version", slog.String("path", version.Path), slog.Int("version", version.Version), slog.Any("err", err))
+		return
+	}
+	if err := s.storage.DeleteObject(ctx, fileVersionPath(version.ID)); err != nil {
+		slog.ErrorCtx(ctx, "failed to delete file version object", slog.String("path", version.Path), slog.Int("version", version.Version), slog.Any("err", err))
+	}
+}
+
+func fileVersionPath(id string) string {
+	return path.Join(internalPathPrefix, "versions", id)
+}
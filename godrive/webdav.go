@@ -0,0 +1,666 @@
+package godrive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	"golang.org/x/net/webdav"
+)
+
+// contentLengthKey holds the announced length of a PUT body, so the written content can be checked against it.
+type contentLengthKey struct{}
+
+func init() {
+	for _, method := range []string{"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"} {
+		chi.RegisterMethod(method)
+	}
+}
+
+func (s *Server) WebDAV() http.Handler {
+	handler := &webdav.Handler{
+		Prefix: s.cfg.WebDAV.Prefix,
+		FileSystem: &webdavFileSystem{
+			s: s,
+		},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				slog.DebugCtx(r.Context(), "webdav request failed", slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.Any("err", err))
+			}
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if permission := webdavPermission(r.Method); !s.hasPermission(GetUserInfo(r), permission) {
+			// guests may be allowed to browse, clients need to be asked for credentials to change anything
+			if s.cfg.Auth != nil && !isAuthenticated(r) {
+				s.unauthorized(w, r, errors.New("not authenticated"))
+				return
+			}
+			s.permissionDenied(w, r, permission)
+			return
+		}
+		// limits and quota are checked again once the content was received, but checking the announced size first avoids receiving it at all
+		if r.Method == http.MethodPut {
+			name := cleanDAVPath(strings.TrimPrefix(r.URL.Path, s.cfg.WebDAV.Prefix))
+			if err := s.cfg.Upload.checkName(name); err != nil {
+				s.uploadError(w, r, err)
+				return
+			}
+			if r.ContentLength > 0 {
+				if err := s.cfg.Upload.checkSize(uint64(r.ContentLength)); err != nil {
+					s.uploadError(w, r, err)
+					return
+				}
+				ownerID := GetUserInfo(r).Subject
+				if file, err := s.db.GetFile(r.Context(), name); err == nil {
+					ownerID = file.UserID
+				}
+				if !s.hasQuota(w, r, ownerID, uint64(r.ContentLength)) {
+					return
+				}
+			}
+			r = r.WithContext(context.WithValue(r.Context(), contentLengthKey{}, r.ContentLength))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// webdavPermission returns the permission a WebDAV method requires. Overwriting existing files is checked per file like other changes.
+func webdavPermission(method string) Permission {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return PermissionRead
+	case http.MethodPut, "MKCOL", "COPY", "LOCK", "UNLOCK":
+		return PermissionUpload
+	}
+	return PermissionModifyOwn
+}
+
+// webdavFileSystem translates WebDAV operations onto the Storage and DB.
+// Directories only exist implicitly through the files they contain, so directories created with MKCOL are stored as folders until the first file is put into them.
+type webdavFileSystem struct {
+	s *Server
+}
+
+func cleanDAVPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// findFiles returns the files below name which the user of the request can read.
+func (f *webdavFileSystem) findFiles(ctx context.Context, name string) ([]File, *access, error) {
+	files, err := f.s.db.FindFiles(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := f.s.getAccess(ctx, GetUserInfoFromContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.readable(files), a, nil
+}
+
+func (f *webdavFileSystem) Mkdir(ctx context.Context, name string, _ os.FileMode) error {
+	name = cleanDAVPath(name)
+	if isReservedPath(name) {
+		return os.ErrPermission
+	}
+	a, err := f.s.getAccess(ctx, GetUserInfoFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if !a.canUpload(name) {
+		return os.ErrPermission
+	}
+	if _, err := f.Stat(ctx, name); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, err := f.Stat(ctx, path.Dir(name)); err != nil {
+		return err
+	}
+
+	return f.s.db.CreateFolder(ctx, Folder{
+		Path:   name,
+		UserID: GetUserInfoFromContext(ctx).Subject,
+	})
+}
+
+// isEmptyDir reports whether name is a folder created with MKCOL or the home folder of the user, which exist without files.
+func (f *webdavFileSystem) isEmptyDir(ctx context.Context, name string) (bool, error) {
+	if isHomeDir(GetUserInfoFromContext(ctx).Home, name) {
+		return true, nil
+	}
+	return f.s.db.HasFolder(ctx, name)
+}
+
+func (f *webdavFileSystem) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	name = cleanDAVPath(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return f.openWriter(ctx, name, flag)
+	}
+
+	info, err := f.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		children, err := f.readDir(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return &webdavFile{
+			info:     info,
+			children: children,
+		}, nil
+	}
+
+	return &webdavFile{
+		ctx:     ctx,
+		storage: f.s.storage,
+		info:    info,
+	}, nil
+}
+
+func (f *webdavFileSystem) openWriter(ctx context.Context, name string, flag int) (webdav.File, error) {
+	if name == "/" {
+		return nil, os.ErrInvalid
+	}
+	if isReservedPath(name) {
+		return nil, os.ErrPermission
+	}
+	userInfo := GetUserInfoFromContext(ctx)
+	a, err := f.s.getAccess(ctx, userInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := f.s.db.GetFile(ctx, name)
+	if err != nil && !errors.Is(err, ErrFileNotFound) {
+		return nil, err
+	}
+	if file == nil && flag&os.O_CREATE == 0 {
+		return nil, os.ErrNotExist
+	}
+	if file != nil && !a.canModify(*file) {
+		return nil, os.ErrPermission
+	}
+	if file == nil && !a.canUpload(name) {
+		return nil, os.ErrPermission
+	}
+	if file == nil {
+		if info, err := f.Stat(ctx, name); err == nil && info.IsDir() {
+			return nil, os.ErrExist
+		}
+	}
+
+	size, ok := ctx.Value(contentLengthKey{}).(int64)
+	if !ok {
+		size = -1
+	}
+
+	tmp, err := os.CreateTemp("", "godrive-webdav-*")
+	if err != nil {
+		return nil, err
+	}
+	return &webdavWriter{
+		File: tmp,
+		ctx:  ctx,
+		fs:   f,
+		name: name,
+		file: file,
+		user: userInfo,
+		size: size,
+	}, nil
+}
+
+func (f *webdavFileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = cleanDAVPath(name)
+	files, a, err := f.findFiles(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		ok, err := f.isEmptyDir(ctx, name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return os.ErrNotExist
+		}
+		return f.s.db.DeleteFolders(ctx, name)
+	}
+
+	userInfo := GetUserInfoFromContext(ctx)
+	var errs error
+	for _, file := range files {
+		if !a.canModify(file) {
+			errs = errors.Join(errs, &fs.PathError{Op: "remove", Path: file.Path, Err: os.ErrPermission})
+			continue
+		}
+		if err = f.s.trashFile(ctx, file, userInfo); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errors.Join(errs, f.s.db.DeleteFolders(ctx, name))
+}
+
+func (f *webdavFileSystem) Rename(ctx context.Context, oldName string, newName string) error {
+	oldName = cleanDAVPath(oldName)
+	newName = cleanDAVPath(newName)
+	if oldName == "/" || newName == "/" {
+		return os.ErrInvalid
+	}
+	if isReservedPath(newName) {
+		return os.ErrPermission
+	}
+
+	files, a, err := f.findFiles(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		ok, err := f.s.db.HasFolder(ctx, oldName)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return os.ErrNotExist
+		}
+		if !a.canUpload(newName) {
+			return &fs.PathError{Op: "rename", Path: newName, Err: os.ErrPermission}
+		}
+		return f.s.db.MoveFolders(ctx, oldName, newName)
+	}
+
+	newPaths := make([]string, len(files))
+	for i, file := range files {
+		if !a.canModify(file) {
+			return &fs.PathError{Op: "rename", Path: file.Path, Err: os.ErrPermission}
+		}
+		// renaming a file may change its extension
+		if file.Path == oldName && f.s.cfg.Upload.checkName(newName) != nil {
+			return &fs.PathError{Op: "rename", Path: newName, Err: os.ErrPermission}
+		}
+		newPaths[i] = newName
+		if file.Path != oldName {
+			newPaths[i] = path.Join(newName, strings.TrimPrefix(file.Path, oldName+"/"))
+		}
+		if !a.canUpload(newPaths[i]) {
+			return &fs.PathError{Op: "rename", Path: newPaths[i], Err: os.ErrPermission}
+		}
+	}
+
+	var errs error
+	for i, file := range files {
+		newPath := newPaths[i]
+		if err = f.s.db.UpdateFile(ctx, file.Path, newPath, 0, "", file.Description); err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		if err = f.s.storage.MoveObject(ctx, file.Path, newPath); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errors.Join(errs, f.s.db.MoveFolders(ctx, oldName, newName))
+}
+
+func (f *webdavFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = cleanDAVPath(name)
+	if name == "/" {
+		return &webdavFileInfo{name: "/", isDir: true}, nil
+	}
+
+	files, _, err := f.findFiles(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		ok, err := f.isEmptyDir(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &webdavFileInfo{name: path.Base(name), isDir: true}, nil
+	}
+	if len(files) == 1 && files[0].Path == name {
+		return newWebDAVFileInfo(files[0]), nil
+	}
+
+	info := &webdavFileInfo{
+		name:  path.Base(name),
+		isDir: true,
+	}
+	for _, file := range files {
+		info.size += int64(file.Size)
+		if modTime := fileModTime(file); modTime.After(info.modTime) {
+			info.modTime = modTime
+		}
+	}
+	return info, nil
+}
+
+func (f *webdavFileSystem) readDir(ctx context.Context, name string) ([]os.FileInfo, error) {
+	files, _, err := f.findFiles(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	var children []os.FileInfo
+	for _, file := range files {
+		rel := strings.TrimPrefix(file.Path, prefix)
+		if !strings.Contains(rel, "/") {
+			children = append(children, newWebDAVFileInfo(file))
+			continue
+		}
+
+		dirName := strings.SplitN(rel, "/", 2)[0]
+		index := slices.IndexFunc(children, func(info os.FileInfo) bool {
+			return info.Name() == dirName
+		})
+		if index == -1 {
+			children = append(children, &webdavFileInfo{
+				name:    dirName,
+				isDir:   true,
+				size:    int64(file.Size),
+				modTime: fileModTime(file),
+			})
+			continue
+		}
+		dir := children[index].(*webdavFileInfo)
+		dir.size += int64(file.Size)
+		if modTime := fileModTime(file); modTime.After(dir.modTime) {
+			dir.modTime = modTime
+		}
+	}
+
+	if dirName, ok := homeChild(GetUserInfoFromContext(ctx).Home, name); ok && !slices.ContainsFunc(children, func(info os.FileInfo) bool {
+		return info.Name() == dirName
+	}) {
+		children = append(children, &webdavFileInfo{name: dirName, isDir: true})
+	}
+
+	folders, err := f.s.db.GetFolders(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range folders {
+		if path.Dir(folder.Path) == name && !slices.ContainsFunc(children, func(info os.FileInfo) bool {
+			return info.Name() == path.Base(folder.Path)
+		}) {
+			children = append(children, &webdavFileInfo{name: path.Base(folder.Path), isDir: true})
+		}
+	}
+	return children, nil
+}
+
+func fileModTime(file File) time.Time {
+	if file.UpdatedAt.After(file.CreatedAt) {
+		return file.UpdatedAt
+	}
+	return file.CreatedAt
+}
+
+func newWebDAVFileInfo(file File) *webdavFileInfo {
+	return &webdavFileInfo{
+		name:        path.Base(file.Path),
+		filePath:    file.Path,
+		size:        int64(file.Size),
+		modTime:     fileModTime(file),
+		contentType: file.ContentType,
+	}
+}
+
+type webdavFileInfo struct {
+	name        string
+	filePath    string
+	size        int64
+	modTime     time.Time
+	isDir       bool
+	contentType string
+}
+
+func (i *webdavFileInfo) Name() string       { return i.name }
+func (i *webdavFileInfo) Size() int64        { return i.size }
+func (i *webdavFileInfo) ModTime() time.Time { return i.modTime }
+func (i *webdavFileInfo) IsDir() bool        { return i.isDir }
+func (i *webdavFileInfo) Sys() any           { return nil }
+
+func (i *webdavFileInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | 0777
+	}
+	return 0666
+}
+
+func (i *webdavFileInfo) ContentType(_ context.Context) (string, error) {
+	if i.isDir || i.contentType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.contentType, nil
+}
+
+// webdavFile is a read only view of a file or directory.
+type webdavFile struct {
+	ctx      context.Context
+	storage  Storage
+	info     os.FileInfo
+	children []os.FileInfo
+
+	reader io.ReadCloser
+	offset int64
+}
+
+func (f *webdavFile) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, os.ErrInvalid
+	}
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.reader == nil {
+		var (
+			start *int64
+			end   *int64
+		)
+		if f.offset > 0 {
+			offset := f.offset
+			last := f.info.Size() - 1
+			start, end = &offset, &last
+		}
+		reader, err := f.storage.GetObject(f.ctx, f.info.(*webdavFileInfo).filePath, start, end)
+		if err != nil {
+			return 0, err
+		}
+		f.reader = reader
+	}
+	n, err := f.reader.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *webdavFile) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = f.offset + offset
+	case io.SeekEnd:
+		newOffset = f.info.Size() + offset
+	default:
+		return 0, os.ErrInvalid
+	}
+	if newOffset < 0 {
+		return 0, os.ErrInvalid
+	}
+	if newOffset != f.offset && f.reader != nil {
+		_ = f.reader.Close()
+		f.reader = nil
+	}
+	f.offset = newOffset
+	return newOffset, nil
+}
+
+func (f *webdavFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.info.IsDir() {
+		return nil, os.ErrInvalid
+	}
+	if count <= 0 {
+		children := f.children
+		f.children = nil
+		return children, nil
+	}
+	if len(f.children) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.children) {
+		count = len(f.children)
+	}
+	children := f.children[:count]
+	f.children = f.children[count:]
+	return children, nil
+}
+
+func (f *webdavFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *webdavFile) Write(_ []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *webdavFile) Close() error {
+	if f.reader != nil {
+		return f.reader.Close()
+	}
+	return nil
+}
+
+// webdavWriter buffers written content in a temporary file and stores it on Close.
+// The webdav handler calls Close even if receiving the content failed, so incomplete content is detected by comparing the written bytes with the announced size.
+type webdavWriter struct {
+	*os.File
+	ctx     context.Context
+	fs      *webdavFileSystem
+	name    string
+	file    *File
+	user    *UserInfo
+	size    int64
+	written int64
+	err     error
+}
+
+func (w *webdavWriter) Write(p []byte) (int, error) {
+	n, err := w.File.Write(p)
+	w.written += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// ReadFrom is used by io.Copy instead of Write, because the embedded file implements it.
+func (w *webdavWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := w.File.ReadFrom(r)
+	w.written += n
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *webdavWriter) Readdir(_ int) ([]fs.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (w *webdavWriter) Stat() (fs.FileInfo, error) {
+	info, err := w.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &webdavFileInfo{
+		name:    path.Base(w.name),
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}, nil
+}
+
+func (w *webdavWriter) Close() error {
+	defer os.Remove(w.File.Name())
+	defer w.File.Close()
+
+	if w.err != nil {
+		return w.err
+	}
+	if w.size >= 0 && w.written != w.size {
+		return fmt.Errorf("%w: expected %d bytes but got %d", ErrSizeMismatch, w.size, w.written)
+	}
+
+	info, err := w.File.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = w.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	size := uint64(info.Size())
+	contentType, content, err := sniffContentType(w.File, w.name)
+	if err != nil {
+		return err
+	}
+	if err = w.fs.s.cfg.Upload.check(w.name, size, contentType); err != nil {
+		return err
+	}
+
+	ownerID := w.user.Subject
+	if w.file != nil {
+		ownerID = w.file.UserID
+	}
+	if err = w.fs.s.checkQuota(w.ctx, ownerID, size); err != nil {
+		return err
+	}
+
+	if w.file == nil {
+		if _, err = w.fs.s.db.CreateFile(w.ctx, w.name, size, contentType, "", w.user.Subject); err != nil {
+			return err
+		}
+		if err = w.fs.s.storage.PutObject(w.ctx, w.name, size, content, contentType); err != nil {
+			if dbErr := w.fs.s.db.DeleteFile(w.ctx, w.name); dbErr != nil {
+				slog.ErrorCtx(w.ctx, "failed to delete file after failed upload", slog.String("path", w.name), slog.Any("err", dbErr))
+			}
+			return err
+		}
+		return w.fs.s.db.DeleteFolders(w.ctx, w.name)
+	}
+
+	version, err := w.fs.s.archiveFile(w.ctx, *w.file)
+	if err != nil {
+		return err
+	}
+	if err = w.fs.s.storage.PutObject(w.ctx, w.name, size, content, contentType); err != nil {
+		w.fs.s.unarchiveFile(w.ctx, *version)
+		return err
+	}
+	err = w.fs.s.db.UpdateFile(w.ctx, w.name, w.name, size, contentType, w.file.Description)
+	w.fs.s.pruneFileVersions(w.ctx, w.name)
+	if err != nil {
+		return err
+	}
+	return w.fs.s.db.DeleteFolders(w.ctx, w.name)
+}
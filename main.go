@@ -47,8 +47,8 @@ var (
 	//go:embed assets
 	Assets embed.FS
 
-	//go:embed sql/schema.sql
-	Schema string
+	//go:embed sql/migrations
+	Migrations embed.FS
 )
 
 func main() {
@@ -61,7 +61,8 @@ func main() {
 	viper.SetDefault("database_type", "sqlite")
 	viper.SetDefault("database_debug", false)
 	viper.SetDefault("database_expire_after", "0")
-	viper.SetDefault("database_cleanup_interval", "1m")
+	viper.SetDefault("trash.retain_for", "720h")
+	viper.SetDefault("database.cleanup_interval", "1m")
 	viper.SetDefault("database_path", "gobin.db")
 	viper.SetDefault("database_host", "localhost")
 	viper.SetDefault("database_port", 5432)
@@ -95,6 +96,15 @@ func main() {
 		os.Exit(-1)
 	}
 	setupLogger(cfg.Log)
+
+	if args := flag.Args(); len(args) > 0 {
+		if err := runCommand(cfg, args); err != nil {
+			slog.Error("Error while running command", slog.String("command", args[0]), slog.Any("err", err))
+			os.Exit(1)
+		}
+		return
+	}
+
 	buildTime, _ := time.Parse(time.RFC3339, BuildTime)
 	slog.Info("Starting godrive", slog.Any("version", Version), slog.Any("commit", Commit), slog.Any("buildTime", buildTime), slog.Any("config", cfg))
 
@@ -118,41 +128,55 @@ func main() {
 		tracer = trace.NewNoopTracerProvider().Tracer(Namespace)
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	db, err := godrive.NewDB(ctx, cfg.Database, migrations())
+	if err != nil {
+		slog.Error("Error while connecting to database", slog.Any("err", err))
+		os.Exit(-1)
+	}
+	defer db.Close()
+
+	if _, err = db.MigrateUp(ctx, 0); err != nil {
+		slog.Error("Error while migrating database", slog.Any("err", err))
+		os.Exit(-1)
+	}
+
 	var auth *godrive.Auth
 	if cfg.Auth != nil {
-		provider, err := oidc.NewProvider(context.Background(), cfg.Auth.Issuer)
-		if err != nil {
-			slog.Error("Error while creating oidc provider", slog.Any("err", err))
-			os.Exit(-1)
-		}
+		auth = &godrive.Auth{}
+		switch cfg.Auth.Mode {
+		case godrive.AuthModeOIDC, "":
+			provider, err := oidc.NewProvider(context.Background(), cfg.Auth.Issuer)
+			if err != nil {
+				slog.Error("Error while creating oidc provider", slog.Any("err", err))
+				os.Exit(-1)
+			}
 
-		auth = &godrive.Auth{
-			Provider: provider,
-			Verifier: provider.Verifier(&oidc.Config{
+			auth.Provider = provider
+			auth.Verifier = provider.Verifier(&oidc.Config{
 				ClientID: cfg.Auth.ClientID,
-			}),
-			Config: &oauth2.Config{
+			})
+			auth.Config = &oauth2.Config{
 				ClientID:     cfg.Auth.ClientID,
 				ClientSecret: cfg.Auth.ClientSecret,
 				Endpoint:     provider.Endpoint(),
 				RedirectURL:  cfg.Auth.RedirectURL,
 				Scopes:       []string{oidc.ScopeOpenID, "groups", "email", "profile", oidc.ScopeOfflineAccess},
-			},
-			Sessions: map[string]*godrive.Session{},
-			States:   map[string]string{},
+			}
+		case godrive.AuthModeLocal:
+			// local accounts only need the session store
+		default:
+			slog.Error("Unknown auth mode", slog.String("mode", string(cfg.Auth.Mode)))
+			os.Exit(-1)
+		}
+		if auth.Sessions, err = godrive.NewSessionStore(*cfg.Auth, db); err != nil {
+			slog.Error("Error while creating session store", slog.Any("err", err))
+			os.Exit(-1)
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	db, err := godrive.NewDB(ctx, cfg.Database, Schema)
-	if err != nil {
-		slog.Error("Error while connecting to database", slog.Any("err", err))
-		os.Exit(-1)
-	}
-	defer db.Close()
-
-	storage, err := godrive.NewStorage(context.Background(), cfg.Storage, tracer)
+	storage, err := newStorage(context.Background(), cfg.Storage, db, tracer)
 	if err != nil {
 		slog.Error("Error while creating storage", slog.Any("err", err))
 		os.Exit(-1)
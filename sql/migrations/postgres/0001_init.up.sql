@@ -17,4 +17,4 @@ CREATE TABLE IF NOT EXISTS users
     email    VARCHAR NOT NULL,
     home     VARCHAR NOT NULL,
     PRIMARY KEY (id)
-);
\ No newline at end of file
+);
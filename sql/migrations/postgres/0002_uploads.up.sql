@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS uploads
+(
+    id            VARCHAR   NOT NULL,
+    path          VARCHAR   NOT NULL,
+    size          BIGINT    NOT NULL,
+    upload_offset BIGINT    NOT NULL,
+    chunks        INT       NOT NULL,
+    content_type  TEXT      NOT NULL,
+    description   TEXT      NOT NULL,
+    user_id       VARCHAR   NOT NULL,
+    created_at    TIMESTAMP NOT NULL,
+    updated_at    TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE file_versions
+(
+    id           VARCHAR   NOT NULL,
+    path         VARCHAR   NOT NULL,
+    version      INT       NOT NULL,
+    size         BIGINT    NOT NULL,
+    content_type TEXT      NOT NULL,
+    user_id      VARCHAR   NOT NULL,
+    created_at   TIMESTAMP NOT NULL,
+    archived_at  TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX file_versions_path ON file_versions (path);
@@ -0,0 +1,20 @@
+CREATE TABLE sessions
+(
+    id            VARCHAR   NOT NULL,
+    access_token  VARCHAR   NOT NULL,
+    expiry        TIMESTAMP NOT NULL,
+    refresh_token VARCHAR   NOT NULL,
+    id_token      VARCHAR   NOT NULL,
+    expires_at    TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX sessions_expires_at_idx ON sessions (expires_at);
+
+CREATE TABLE oidc_states
+(
+    state      VARCHAR   NOT NULL,
+    nonce      VARCHAR   NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (state)
+);
@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN groups VARCHAR NOT NULL DEFAULT '';
+
+CREATE TABLE tokens
+(
+    id           VARCHAR   NOT NULL,
+    user_id      VARCHAR   NOT NULL,
+    name         VARCHAR   NOT NULL,
+    hash         VARCHAR   NOT NULL,
+    scope        VARCHAR   NOT NULL,
+    expires_at   TIMESTAMP,
+    last_used_at TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE (hash)
+);
+
+CREATE INDEX tokens_user_id_idx ON tokens (user_id);
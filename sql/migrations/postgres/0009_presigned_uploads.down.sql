@@ -0,0 +1,2 @@
+ALTER TABLE uploads DROP COLUMN multipart_id;
+ALTER TABLE uploads DROP COLUMN presigned;
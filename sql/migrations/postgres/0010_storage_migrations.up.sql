@@ -0,0 +1,10 @@
+CREATE TABLE storage_migrations
+(
+    target      VARCHAR   NOT NULL,
+    path        VARCHAR   NOT NULL,
+    size        BIGINT    NOT NULL,
+    source_etag VARCHAR   NOT NULL,
+    checksum    VARCHAR   NOT NULL,
+    migrated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (target, path)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE acls
+(
+    path         VARCHAR   NOT NULL,
+    subject_type VARCHAR   NOT NULL,
+    subject      VARCHAR   NOT NULL,
+    permission   VARCHAR   NOT NULL,
+    deny         BOOLEAN   NOT NULL,
+    created_by   VARCHAR   NOT NULL,
+    created_at   TIMESTAMP NOT NULL,
+    PRIMARY KEY (path, subject_type, subject)
+);
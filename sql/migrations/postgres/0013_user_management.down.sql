@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS audit_log;
+ALTER TABLE users DROP COLUMN disabled;
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS invitations;
+ALTER TABLE sessions DROP COLUMN user_id;
+ALTER TABLE users DROP COLUMN password;
@@ -0,0 +1,22 @@
+ALTER TABLE users ADD COLUMN password VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN user_id VARCHAR NOT NULL DEFAULT '';
+
+CREATE TABLE invitations
+(
+    hash       VARCHAR   NOT NULL,
+    role       VARCHAR   NOT NULL,
+    created_by VARCHAR   NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (hash)
+);
+
+CREATE TABLE password_resets
+(
+    hash       VARCHAR   NOT NULL,
+    user_id    VARCHAR   NOT NULL,
+    created_by VARCHAR   NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (hash)
+);
@@ -0,0 +1,16 @@
+CREATE TABLE trash
+(
+    id           VARCHAR   NOT NULL,
+    path         VARCHAR   NOT NULL,
+    size         BIGINT    NOT NULL,
+    content_type TEXT      NOT NULL,
+    description  TEXT      NOT NULL,
+    user_id      VARCHAR   NOT NULL,
+    created_at   TIMESTAMP NOT NULL,
+    updated_at   TIMESTAMP NOT NULL,
+    deleted_by   VARCHAR   NOT NULL,
+    deleted_at   TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX trash_deleted_at ON trash (deleted_at);
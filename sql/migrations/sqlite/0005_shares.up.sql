@@ -0,0 +1,13 @@
+CREATE TABLE shares
+(
+    token         VARCHAR   NOT NULL,
+    path          VARCHAR   NOT NULL,
+    user_id       VARCHAR   NOT NULL,
+    password      VARCHAR   NOT NULL,
+    permission    VARCHAR   NOT NULL,
+    max_downloads BIGINT    NOT NULL,
+    downloads     BIGINT    NOT NULL,
+    expires_at    TIMESTAMP,
+    created_at    TIMESTAMP NOT NULL,
+    PRIMARY KEY (token)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS tokens;
+
+ALTER TABLE users DROP COLUMN groups;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS blob_refs;
+DROP TABLE IF EXISTS blobs;
@@ -0,0 +1,17 @@
+CREATE TABLE blobs
+(
+    hash       VARCHAR   NOT NULL,
+    size       BIGINT    NOT NULL,
+    ref_count  BIGINT    NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (hash)
+);
+
+CREATE TABLE blob_refs
+(
+    path VARCHAR NOT NULL,
+    hash VARCHAR NOT NULL,
+    PRIMARY KEY (path)
+);
+
+CREATE INDEX blob_refs_hash_idx ON blob_refs (hash);
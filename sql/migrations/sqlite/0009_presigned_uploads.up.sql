@@ -0,0 +1,2 @@
+ALTER TABLE uploads ADD COLUMN presigned BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE uploads ADD COLUMN multipart_id VARCHAR NOT NULL DEFAULT '';
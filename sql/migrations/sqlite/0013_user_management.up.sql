@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN role VARCHAR;
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE audit_log
+(
+    id         VARCHAR   NOT NULL,
+    user_id    VARCHAR   NOT NULL,
+    username   VARCHAR   NOT NULL,
+    action     VARCHAR   NOT NULL,
+    target     VARCHAR   NOT NULL,
+    details    VARCHAR   NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
@@ -0,0 +1,6 @@
+CREATE TABLE folders
+(
+    path       VARCHAR   NOT NULL PRIMARY KEY,
+    user_id    VARCHAR   NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);